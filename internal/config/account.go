@@ -0,0 +1,244 @@
+package config
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// What happens to a user's chirps when they delete their account
+const (
+	DeletedChirpsDelete    = "delete"    // Delete them with the account
+	DeletedChirpsAnonymize = "anonymize" // Keep them with no author (author_id 0)
+)
+
+// reauthTokenLifetime is how long a fresh provider login can stand in for
+// the password when deleting the account
+const reauthTokenLifetime = 5 * time.Minute
+
+// HandlerDeleteUser deletes the caller's account. A stolen access token alone
+// is not enough: it takes the password, or a reauth token from a fresh
+// provider login (HandlerOIDCReauth), and the second factor if 2FA is on.
+func (cfg *ApiConfig) HandlerDeleteUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	type deleteParams struct {
+		Password    string `json:"password"`
+		ReauthToken string `json:"reauth_token"`
+		mfaParams
+	}
+
+	var params deleteParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if params.Password == "" && params.ReauthToken == "" {
+		http.Error(w, `{"error": "password or reauth_token is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Wrong passwords and codes count against the login lockout here too
+	emailKey, ipKey := cfg.loginKeys(user.Email, r)
+	if cfg.loginLocked(w, emailKey, ipKey) {
+		return
+	}
+	if params.Password == "" {
+		if !cfg.checkReauthToken(params.ReauthToken, user) {
+			http.Error(w, `{"error": "Invalid or expired reauth_token"}`, http.StatusUnauthorized)
+			return
+		}
+	} else if err := bcrypt.CompareHashAndPassword([]byte(user.Password), []byte(params.Password)); err != nil {
+		cfg.EmailLockout.Fail(emailKey)
+		cfg.IPLockout.Fail(ipKey)
+		http.Error(w, `{"error": "Incorrect password"}`, http.StatusUnauthorized)
+		return
+	}
+
+	if user.TOTPEnabled {
+		if params.Code == "" && params.RecoveryCode == "" {
+			http.Error(w, `{"error": "code or recovery_code is required"}`, http.StatusUnauthorized)
+			return
+		}
+		_, err := cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+			return checkSecondFactor(user, params.mfaParams)
+		})
+		if errors.Is(err, errInvalidMFACode) {
+			cfg.mfaFailed(w, emailKey, ipKey)
+			return
+		}
+		if err != nil && !errors.Is(err, errTOTPNotEnabled) {
+			http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	// Sessions and tokens go with the user, so every token stops working
+	keepChirps := cfg.DeletedUserChirps == DeletedChirpsAnonymize
+	chirpIDs, err := cfg.Store.DeleteUser(userID, keepChirps)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	for _, chirpID := range chirpIDs {
+		cfg.Search.Remove(chirpID)
+	}
+	log.Printf("User %d deleted their account, %d chirps deleted", userID, len(chirpIDs))
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// checkReauthToken reports whether the token is a reauth token for the user
+// from after their last password change
+func (cfg *ApiConfig) checkReauthToken(tokenString string, user handlers.User) bool {
+	claims, err := cfg.parseToken(tokenString, tokenTypeReauth)
+	if err != nil || claims.Subject != strconv.Itoa(user.GetID()) {
+		return false
+	}
+	return !issuedBeforeWatermark(claims.IssuedAt.Time, user)
+}
+
+// exportChirp is a chirp with its earlier bodies
+type exportChirp struct {
+	handlers.Chirp
+	Revisions []handlers.ChirpRevision `json:"revisions"`
+}
+
+// HandlerExportUser sends the caller a zip archive of everything stored about
+// them: their profile, chirps, sessions, API tokens and linked providers.
+// Password and 2FA secrets and token hashes are left out.
+func (cfg *ApiConfig) HandlerExportUser(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	archive, err := cfg.exportUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to export data: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/zip")
+	w.Header().Set("Content-Disposition", fmt.Sprintf(`attachment; filename="chirpy-export-%d.zip"`, userID))
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(archive)
+}
+
+// exportUser builds the archive in memory, so a failure can still be
+// reported as an error response
+func (cfg *ApiConfig) exportUser(userID int) ([]byte, error) {
+	user, err := cfg.Store.GetUser(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	profile := map[string]interface{}{
+		"id":             user.GetID(),
+		"email":          user.GetUniqueIdentifier(),
+		"email_verified": user.EmailVerified,
+		"is_chirpy_red":  user.IsChirpyRed,
+		"role":           user.GetRole(),
+		"totp_enabled":   user.TOTPEnabled,
+		"created_at":     user.CreatedAt,
+		"updated_at":     user.UpdatedAt,
+	}
+
+	chirps, err := cfg.Store.ListChirps(storage.ChirpQuery{AuthorID: userID})
+	if err != nil {
+		return nil, err
+	}
+	exportChirps := []exportChirp{}
+	for _, chirp := range chirps {
+		revisions, err := cfg.Store.ListChirpRevisions(chirp.ID)
+		if err != nil && !errors.Is(err, storage.ErrNotFound) {
+			return nil, err
+		}
+		if revisions == nil {
+			revisions = []handlers.ChirpRevision{}
+		}
+		exportChirps = append(exportChirps, exportChirp{Chirp: chirp, Revisions: revisions})
+	}
+
+	sessions, err := cfg.Store.ListSessions(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	tokens, err := cfg.Store.ListAPITokens(userID)
+	if err != nil {
+		return nil, err
+	}
+	exportTokens := []apiTokenResponse{}
+	for _, token := range tokens {
+		exportTokens = append(exportTokens, newAPITokenResponse(token))
+	}
+
+	identities, err := cfg.Store.ListIdentities(userID)
+	if err != nil {
+		return nil, err
+	}
+
+	files := []struct {
+		name string
+		data interface{}
+	}{
+		{"profile.json", profile},
+		{"chirps.json", exportChirps},
+		{"sessions.json", sessions},
+		{"api_tokens.json", exportTokens},
+		{"identities.json", identities},
+	}
+
+	var buf bytes.Buffer
+	archive := zip.NewWriter(&buf)
+	modified := time.Now().UTC()
+	for _, file := range files {
+		f, err := archive.CreateHeader(&zip.FileHeader{Name: file.name, Method: zip.Deflate, Modified: modified})
+		if err != nil {
+			return nil, fmt.Errorf("could not add %s: %v", file.name, err)
+		}
+		encoder := json.NewEncoder(f)
+		encoder.SetIndent("", "  ")
+		if err := encoder.Encode(file.data); err != nil {
+			return nil, fmt.Errorf("could not write %s: %v", file.name, err)
+		}
+	}
+	if err := archive.Close(); err != nil {
+		return nil, fmt.Errorf("could not finish archive: %v", err)
+	}
+	return buf.Bytes(), nil
+}
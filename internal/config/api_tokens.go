@@ -0,0 +1,268 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"slices"
+	"strconv"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// Scopes personal API tokens can be granted. Routes that accept a token say
+// which scope it needs, see MiddlewareAuthScope.
+const (
+	ScopeChirpsWrite  = "chirps:write"  // Post and edit chirps
+	ScopeChirpsDelete = "chirps:delete" // Delete chirps
+)
+
+// apiTokenScopes are all the scopes, in the order they are documented
+var apiTokenScopes = []string{ScopeChirpsWrite, ScopeChirpsDelete}
+
+const (
+	// apiTokenPrefix marks personal API tokens, so the auth middleware can
+	// tell them from JWTs and leaked tokens are easy to search for
+	apiTokenPrefix = "chirpy_pat_"
+
+	// apiTokenNameMaxLength is the longest name a token can be given
+	apiTokenNameMaxLength = 100
+
+	// apiTokenTouchInterval is how often a token's last use is written down.
+	// Bots can make many requests a second; one write each would be wasteful.
+	apiTokenTouchInterval = time.Minute
+)
+
+// serveAPIToken authenticates a request made with a personal API token and
+// passes it on if the token has the scope
+func (cfg *ApiConfig) serveAPIToken(w http.ResponseWriter, r *http.Request, next http.Handler, tokenString, scope string) {
+	token, err := cfg.checkAPIToken(tokenString)
+	if errors.Is(err, errTokenRevoked) {
+		writeUnauthorized(w)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if scope == "" {
+		w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+		http.Error(w, `{"error": "Personal API tokens cannot be used here, log in instead"}`, http.StatusForbidden)
+		return
+	}
+	if !token.HasScope(scope) {
+		w.Header().Set("WWW-Authenticate", fmt.Sprintf(`Bearer error="insufficient_scope", scope=%q`, scope))
+		http.Error(w, fmt.Sprintf(`{"error": "Token is missing the %s scope"}`, scope), http.StatusForbidden)
+		return
+	}
+
+	ctx := context.WithValue(r.Context(), userIDKey, token.UserID)
+	next.ServeHTTP(w, r.WithContext(ctx))
+}
+
+// checkAPIToken returns the token if it can be used. Like access tokens,
+// personal tokens stop working when the user changes their password.
+func (cfg *ApiConfig) checkAPIToken(tokenString string) (handlers.APIToken, error) {
+	token, err := cfg.Store.GetAPITokenByHash(hashToken(tokenString))
+	if errors.Is(err, storage.ErrNotFound) {
+		return handlers.APIToken{}, errTokenRevoked
+	}
+	if err != nil {
+		return handlers.APIToken{}, err
+	}
+	now := time.Now().UTC()
+	if !token.Active(now) {
+		return handlers.APIToken{}, errTokenRevoked
+	}
+
+	user, err := cfg.Store.GetUser(token.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return handlers.APIToken{}, errTokenRevoked
+	}
+	if err != nil {
+		return handlers.APIToken{}, err
+	}
+	if issuedBeforeWatermark(token.CreatedAt, user) {
+		return handlers.APIToken{}, errTokenRevoked
+	}
+
+	// Failing to record the use is no reason to fail the request
+	if now.Sub(token.LastUsedAt) >= apiTokenTouchInterval {
+		if err := cfg.Store.TouchAPIToken(token.ID, now); err != nil {
+			log.Printf("Could not record use of API token %d: %v", token.ID, err)
+		}
+	}
+	return token, nil
+}
+
+// apiTokenResponse is how a token is shown to its owner. The token itself is
+// only ever in the response that creates it.
+type apiTokenResponse struct {
+	ID         int        `json:"id"`
+	Name       string     `json:"name"`
+	Token      string     `json:"token,omitempty"`
+	Scopes     []string   `json:"scopes"`
+	CreatedAt  time.Time  `json:"created_at"`
+	LastUsedAt *time.Time `json:"last_used_at"`
+	ExpiresAt  *time.Time `json:"expires_at"`
+}
+
+func newAPITokenResponse(token handlers.APIToken) apiTokenResponse {
+	response := apiTokenResponse{
+		ID:        token.ID,
+		Name:      token.Name,
+		Scopes:    token.Scopes,
+		CreatedAt: token.CreatedAt,
+	}
+	if !token.LastUsedAt.IsZero() {
+		response.LastUsedAt = &token.LastUsedAt
+	}
+	if !token.ExpiresAt.IsZero() {
+		response.ExpiresAt = &token.ExpiresAt
+	}
+	return response
+}
+
+// HandlerCreateAPIToken creates a personal API token for the caller
+func (cfg *ApiConfig) HandlerCreateAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	type createParams struct {
+		Name          string   `json:"name"`
+		Scopes        []string `json:"scopes"`
+		ExpiresInDays int      `json:"expires_in_days"` // 0 for a token that never expires
+	}
+
+	var params createParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	var errs []fieldError
+	if params.Name == "" || len(params.Name) > apiTokenNameMaxLength {
+		errs = append(errs, fieldError{Field: "name", Code: "invalid", Message: fmt.Sprintf("Name must be 1 to %d characters", apiTokenNameMaxLength)})
+	}
+	if len(params.Scopes) == 0 {
+		errs = append(errs, fieldError{Field: "scopes", Code: "required", Message: "At least one scope is required"})
+	}
+	for _, scope := range params.Scopes {
+		if !slices.Contains(apiTokenScopes, scope) {
+			errs = append(errs, fieldError{Field: "scopes", Code: "unknown_scope", Message: fmt.Sprintf("Unknown scope %q", scope)})
+		}
+	}
+	if params.ExpiresInDays < 0 {
+		errs = append(errs, fieldError{Field: "expires_in_days", Code: "invalid", Message: "expires_in_days cannot be negative"})
+	}
+	if len(errs) > 0 {
+		writeFieldErrors(w, http.StatusBadRequest, "Invalid API token", errs)
+		return
+	}
+
+	tokenString, _, err := newOpaqueToken()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate token"}`, http.StatusInternalServerError)
+		return
+	}
+	tokenString = apiTokenPrefix + tokenString
+
+	// Granting a scope twice changes nothing
+	scopes := slices.Clone(params.Scopes)
+	slices.Sort(scopes)
+	scopes = slices.Compact(scopes)
+
+	token := handlers.APIToken{
+		UserID: userID,
+		Name:   params.Name,
+		Hash:   hashToken(tokenString),
+		Scopes: scopes,
+	}
+	if params.ExpiresInDays > 0 {
+		token.ExpiresAt = time.Now().UTC().AddDate(0, 0, params.ExpiresInDays).Truncate(time.Microsecond)
+	}
+
+	token, err = cfg.Store.CreateAPIToken(token)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	response := newAPITokenResponse(token)
+	response.Token = tokenString
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// HandlerGetAPITokens lists the caller's tokens that can still be used
+func (cfg *ApiConfig) HandlerGetAPITokens(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	tokens, err := cfg.Store.ListAPITokens(userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read API tokens: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	now := time.Now().UTC()
+	response := []apiTokenResponse{}
+	for _, token := range tokens {
+		if token.Active(now) {
+			response = append(response, newAPITokenResponse(token))
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// HandlerDeleteAPIToken revokes one of the caller's tokens
+func (cfg *ApiConfig) HandlerDeleteAPIToken(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	tokenID, err := strconv.Atoi(r.PathValue("tokenID"))
+	if err != nil {
+		http.Error(w, `{"error": "API token not found"}`, http.StatusNotFound)
+		return
+	}
+
+	// Other users' tokens look the same as missing ones
+	err = cfg.Store.RevokeAPIToken(userID, tokenID)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrRevoked) {
+		http.Error(w, `{"error": "API token not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// errNotChirpAuthor aborts an update when the caller does not own the chirp
+var errNotChirpAuthor = errors.New("not the chirp author")
+
+// HandlerUpdateChirp serves PUT and PATCH /api/chirps/{chirpID}. The only
+// editable field is the body, so both methods behave the same.
+func (cfg *ApiConfig) HandlerUpdateChirp(w http.ResponseWriter, r *http.Request) {
+	authorID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+	if !cfg.requireVerifiedEmail(w, authorID) {
+		return
+	}
+
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
+		return
+	}
+
+	var params struct {
+		Body *string `json:"body"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if params.Body == nil {
+		http.Error(w, `{"error": "body is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Edits follow the same rules as new chirps
+	body, errs := cfg.validateChirpBody(*params.Body)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
+		return
+	}
+
+	// Check ownership and replace the body in one step
+	chirp, err := cfg.Store.UpdateChirp(chirpID, func(chirp *handlers.Chirp) error {
+		if chirp.AuthorID != authorID {
+			return errNotChirpAuthor
+		}
+		chirp.Body = body
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
+		return
+	}
+	if errors.Is(err, errNotChirpAuthor) {
+		http.Error(w, `{"error": "Forbidden: You do not have permission to edit this chirp"}`, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	cfg.Search.Add(chirp.GetID(), chirp.Body)
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(chirp); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// HandlerGetChirpRevisions serves GET /api/chirps/{chirpID}/revisions, the
+// earlier bodies of a chirp, oldest first
+func (cfg *ApiConfig) HandlerGetChirpRevisions(w http.ResponseWriter, r *http.Request) {
+	chirpID, err := strconv.Atoi(r.PathValue("chirpID"))
+	if err != nil {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
+		return
+	}
+
+	revisions, err := cfg.Store.ListChirpRevisions(chirpID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read revisions: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(revisions); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
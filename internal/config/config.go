@@ -1,35 +1,56 @@
 package config
 
 import (
-	"crypto/rand"
-	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"log"
 	"net/http"
-	"os"
+	"net/netip"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
 
 	"github.com/RichardHoa/go-server/internal/handlers"
-	"github.com/golang-jwt/jwt/v5"
+	"github.com/RichardHoa/go-server/internal/lockout"
+	"github.com/RichardHoa/go-server/internal/mail"
+	"github.com/RichardHoa/go-server/internal/oidc"
+	"github.com/RichardHoa/go-server/internal/password"
+	"github.com/RichardHoa/go-server/internal/search"
+	"github.com/RichardHoa/go-server/internal/signing"
+	"github.com/RichardHoa/go-server/internal/storage"
+	"github.com/RichardHoa/go-server/internal/wordfilter"
 	"golang.org/x/crypto/bcrypt"
 )
 
 type ApiConfig struct {
 	FileserverHits int
 	JWTSecret      string
+	SigningKeys    *signing.KeySet // Keys for signing JWTs, HS256 with JWTSecret if nil
 	PolkaAPIKey    string
-	Mu             sync.Mutex // Mutex to ensure safe concurrent access to FileserverHits
+	Store          storage.Store
+	Search         *search.Index
+	ChirpMaxLength int                // Longest chirp body in characters, DefaultChirpMaxLength if unset
+	WordFilter     *wordfilter.Filter // Banned words, nil to allow everything
+	WordFilterMode string             // WordFilterMask (the default) or WordFilterReject
+	Mailer         mail.Mailer        // Sends account emails, the log if nil
+	PublicURL      string             // Where clients reach the server, for links in emails
+
+	RequireVerifiedEmail bool   // Only users with a verified email address can post chirps
+	DeletedUserChirps    string // DeletedChirpsDelete (the default) or DeletedChirpsAnonymize
+
+	PasswordPolicy password.Policy   // Rules for new passwords, password.DefaultPolicy if unset
+	Breaches       password.Breaches // Passwords known from data breaches, nil to skip the check
+	EmailLockout   *lockout.Tracker  // Failed logins per account, nil to never lock
+	IPLockout      *lockout.Tracker  // Failed logins per client IP, nil to never lock
+	TrustedProxies []netip.Prefix    // Proxies whose X-Forwarded-For header names the client, nil to use the connection's address
+
+	OIDCProviders map[string]*oidc.Provider // "Login with" providers by name
+
+	Mu sync.Mutex // Mutex to ensure safe concurrent access to FileserverHits
 }
 
-var (
-	chirpsID = 1
-
-	mutex sync.Mutex
-)
-
 func (apiCfg *ApiConfig) MiddlewareMetricsInc(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		apiCfg.Mu.Lock()
@@ -81,10 +102,6 @@ func (cfg *ApiConfig) HandlerAuthenticateUser(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	cfg.Mu.Lock()
-	JWTSecret := cfg.JWTSecret
-	cfg.Mu.Unlock()
-
 	var user handlers.User
 	decoder := json.NewDecoder(r.Body)
 	if err := decoder.Decode(&user); err != nil {
@@ -92,178 +109,103 @@ func (cfg *ApiConfig) HandlerAuthenticateUser(w http.ResponseWriter, r *http.Req
 		return
 	}
 
-	// Define the file path to the database
-	filePath := "database.json"
-
-	// Define a struct for the entire database
-
-	// Initialize the database struct
-	var database handlers.Database
+	// Too many failures for this account or from this client
+	emailKey, ipKey := cfg.loginKeys(user.GetUniqueIdentifier(), r)
+	if cfg.loginLocked(w, emailKey, ipKey) {
+		return
+	}
 
-	// Read the database file
-	fileBytes, err := os.ReadFile(filePath)
+	// Find the user by email
+	storedUser, err := cfg.Store.GetUserByEmail(user.GetUniqueIdentifier())
+	if errors.Is(err, storage.ErrNotFound) {
+		// Spend the same time as for a wrong password, so the response does
+		// not tell whether the account exists
+		bcrypt.CompareHashAndPassword(dummyPasswordHash, []byte(user.Password))
+		cfg.loginFailed(w, emailKey, ipKey)
+		return
+	}
 	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
 
-	// Parse the JSON data into the database struct
-	if err := json.Unmarshal(fileBytes, &database); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to parse database: %v"}`, err), http.StatusInternalServerError)
-		return
-	}
-
-	// Ensure chirps is initialized if it's null
-	if database.Chirps == nil {
-		database.Chirps = make(map[string]handlers.Chirp)
-	}
-
-	// Extract the "users" map
-	users := database.Users
-
-	// Iterate over users to find a match by email
-	for _, storedUser := range users {
-		if storedUser.GetUniqueIdentifier() == user.GetUniqueIdentifier() {
-			// Compare the hashed password with the provided password
-			if err := bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(user.Password)); err != nil {
-				http.Error(w, `{"error": "Invalid password"}`, http.StatusUnauthorized)
-				return
-			}
-
-			// JWT token generation
-			secretKey := []byte(JWTSecret)
-			expiresInSeconds := 1 * 60 * 60 // Default to 1 hour
-
-			// Override with client-provided expiration time if valid
-			if user.ExpiresInSeconds > 0 && user.ExpiresInSeconds <= 24*60*60 {
-				expiresInSeconds = user.ExpiresInSeconds
-			}
-
-			// Create the JWT claims
-			claims := jwt.RegisteredClaims{
-				Issuer:    "chirpy",
-				Subject:   fmt.Sprintf("%v", storedUser.GetID()),
-				IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-				ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Second * time.Duration(expiresInSeconds))),
-			}
-
-			// Create the token with HS256 signing method
-			token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-			// Sign the token with the secret key
-			tokenString, err := token.SignedString(secretKey)
-			if err != nil {
-				http.Error(w, `{"error": "Failed to sign token"}`, http.StatusInternalServerError)
-				return
-			}
-
-			// Generate refresh token
-			refreshTokenBytes := make([]byte, 32)
-			_, err = rand.Read(refreshTokenBytes)
-			if err != nil {
-				http.Error(w, `{"error": "Failed to generate refresh token"}`, http.StatusInternalServerError)
-				return
-			}
-			refreshToken := hex.EncodeToString(refreshTokenBytes)
-
-			// Store refresh token and its expiration date in the database
-			storedUser.RefreshToken = refreshToken
-			storedUser.RefreshTokenExpiresAt = time.Now().UTC().Add(60 * 24 * time.Hour) // 60 days
-
-			// Update the user in the database
-			database.Users[fmt.Sprintf("%v", storedUser.GetID())] = storedUser
-
-			// Write updated data back to the database, preserving chirps
-			fileBytes, err = json.MarshalIndent(database, "", "  ")
-			if err != nil {
-				http.Error(w, fmt.Sprintf(`{"error": "Failed to serialize database: %v"}`, err), http.StatusInternalServerError)
-				return
-			}
-			if err := os.WriteFile(filePath, fileBytes, 0644); err != nil {
-				http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
-				return
-			}
-
-			// Respond with the token and refresh token
-			response := map[string]interface{}{
-				"id":            storedUser.GetID(),
-				"email":         storedUser.GetUniqueIdentifier(),
-				"refresh_token": refreshToken,
-				"token":         tokenString,
-				"is_chirpy_red": storedUser.IsChirpyRed,
-			}
-
-			w.Header().Set("Content-Type", "application/json")
-			json.NewEncoder(w).Encode(response)
-			return
-		}
+	// Compare the hashed password with the provided password
+	if err := bcrypt.CompareHashAndPassword([]byte(storedUser.Password), []byte(user.Password)); err != nil {
+		cfg.loginFailed(w, emailKey, ipKey)
+		return
 	}
-
-	// If no user is found with the given email
-	http.Error(w, `{"error": "User email does not exist"}`, http.StatusNotFound)
-}
-
-func (cfg *ApiConfig) HandlerPutUser(w http.ResponseWriter, r *http.Request) {
-	// Ensure the method is PUT
-	if r.Method != http.MethodPut {
-		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+	// With two-factor authentication the password only earns a challenge,
+	// completed at /api/login/mfa. The account's count is kept until then.
+	if storedUser.TOTPEnabled {
+		cfg.writeMFAChallenge(w, storedUser)
 		return
 	}
 
-	cfg.Mu.Lock()
-	JWTSecret := cfg.JWTSecret
-	cfg.Mu.Unlock()
+	// Only the account's count is cleared: an attacker logging into their
+	// own account must not reset the count for their IP
+	cfg.EmailLockout.Reset(emailKey)
 
-	// Extract the JWT from the Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, `{"error": "Invalid or missing Authorization header"}`, http.StatusUnauthorized)
+	cfg.writeLogin(w, r, storedUser, user.ExpiresInSeconds)
+}
+
+// writeLogin starts a new session for a user who has proven who they are and
+// responds with its access and refresh tokens. expiresInSeconds is the
+// lifetime the client asked for, if any.
+func (cfg *ApiConfig) writeLogin(w http.ResponseWriter, r *http.Request, storedUser handlers.User, expiresInSeconds int) {
+	// Generate refresh token
+	refreshToken, storedToken, err := newRefreshToken()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate refresh token"}`, http.StatusInternalServerError)
 		return
 	}
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
 
-	// Define the claims struct
-	claims := &jwt.RegisteredClaims{}
-
-	// Parse the JWT token
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(JWTSecret), nil // Replace with your actual secret key
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, `{"error": "Invalid or expired token"}`, http.StatusUnauthorized)
+	// Every login is a new session, so logging in on another device keeps this one
+	session := handlers.Session{UserID: storedUser.GetID(), UserAgent: r.UserAgent(), IP: cfg.clientIP(r)}
+	session, err = cfg.Store.CreateSession(session, storedToken)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
 
-	// Extract user ID from the token's Subject field
-	userID := claims.Subject
-
-	// Define the file path to the database
-	filePath := "database.json"
-
-	// Initialize a map to hold the data from the database
-
-	var database handlers.Database
+	// JWT token generation, defaulting to 1 hour unless the client asked for
+	// a valid expiration time
+	if expiresInSeconds <= 0 || expiresInSeconds > 24*60*60 {
+		expiresInSeconds = 1 * 60 * 60
+	}
 
-	// Read the database file
-	fileBytes, err := os.ReadFile(filePath)
+	tokenString, err := cfg.issueAccessToken(storedUser, session.ID, time.Duration(expiresInSeconds)*time.Second)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to sign token"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Parse the JSON data
-	if err := json.Unmarshal(fileBytes, &database); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to parse database: %v"}`, err), http.StatusInternalServerError)
-		return
+	// Respond with the token and refresh token
+	response := map[string]interface{}{
+		"id":             storedUser.GetID(),
+		"email":          storedUser.GetUniqueIdentifier(),
+		"refresh_token":  refreshToken,
+		"token":          tokenString,
+		"is_chirpy_red":  storedUser.IsChirpyRed,
+		"email_verified": storedUser.EmailVerified,
+		"role":           storedUser.GetRole(),
+		"created_at":     storedUser.CreatedAt,
+		"updated_at":     storedUser.UpdatedAt,
 	}
 
-	// Extract the "users" map
-	users := database.Users
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
 
-	// Find the user by ID
-	user, exists := users[userID]
-	if !exists {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+func (cfg *ApiConfig) HandlerPutUser(w http.ResponseWriter, r *http.Request) {
+	// Ensure the method is PUT
+	if r.Method != http.MethodPut {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
 		return
 	}
 
@@ -280,6 +222,31 @@ func (cfg *ApiConfig) HandlerPutUser(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// A new password invalidates every access token handed out with the old one
+	currentUser, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	passwordChanged := bcrypt.CompareHashAndPassword([]byte(currentUser.Password), []byte(updatedUserObject.Password)) != nil
+
+	// A new password has to meet the password policy
+	if passwordChanged {
+		passwordErrs, err := cfg.checkPassword(updatedUserObject.Password, updatedUserObject.Email)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "Failed to check password: %v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		if len(passwordErrs) > 0 {
+			writePasswordErrors(w, passwordErrs)
+			return
+		}
+	}
+
 	// Hash the new password
 	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(updatedUserObject.Password), bcrypt.DefaultCost)
 	if err != nil {
@@ -288,30 +255,61 @@ func (cfg *ApiConfig) HandlerPutUser(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Update the user's email and password in the database
-	user.Email = updatedUserObject.Email
-	user.Password = string(hashedPassword)
-
-	// Save the updated user back to the database
-	users[userID] = user
-	database.Users = users
-
-	// Convert the database map back to JSON
-	updatedDatabaseBytes, err := json.Marshal(database)
-	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to serialize database: %v"}`, err), http.StatusInternalServerError)
+	emailChanged := false
+	user, err := cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+		// A new address has to be verified again
+		emailChanged = user.Email != updatedUserObject.Email
+		if emailChanged {
+			user.EmailVerified = false
+		}
+		user.Email = updatedUserObject.Email
+		user.Password = string(hashedPassword)
+		if passwordChanged {
+			user.TokensValidAfter = tokensValidAfterNow()
+		}
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
 		return
 	}
-
-	// Write the updated database back to the file
-	if err := os.WriteFile(filePath, updatedDatabaseBytes, 0644); err != nil {
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		http.Error(w, `{"error": "user email already exists"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to write to database: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
 
+	if emailChanged {
+		cfg.sendEmailVerificationLogged(user)
+	}
+
 	// Return the updated user in the response
 	response := map[string]interface{}{
-		"id":    user.GetID(),
-		"email": user.Email,
+		"id":             user.GetID(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+		"created_at":     user.CreatedAt,
+		"updated_at":     user.UpdatedAt,
+	}
+
+	// Log out every other device. This one keeps its session and gets a new
+	// access token, as the one it sent has just been invalidated.
+	if passwordChanged {
+		sessionID := SessionIDFromContext(r.Context())
+		if _, err := cfg.Store.RevokeAllSessions(userID, sessionID); err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+			return
+		}
+
+		tokenString, err := cfg.issueAccessToken(user, sessionID, time.Hour)
+		if err != nil {
+			http.Error(w, `{"error": "Failed to sign token"}`, http.StatusInternalServerError)
+			return
+		}
+		response["token"] = tokenString
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -325,87 +323,57 @@ func (cfg *ApiConfig) HandlerRefreshToken(w http.ResponseWriter, r *http.Request
 		return
 	}
 
-	cfg.Mu.Lock()
-	JWTSecret := cfg.JWTSecret
-	cfg.Mu.Unlock()
-
 	// Extract the refresh token from the Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
+	refreshTokenString, ok := bearerToken(r)
+	if !ok {
 		http.Error(w, `{"error": "Invalid or missing Authorization header"}`, http.StatusUnauthorized)
 		return
 	}
-	refreshTokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// Define the file path to the database
-	filePath := "database.json"
 
-	// Initialize a map to hold the data from the database
-	database := map[string]map[string]handlers.User{}
-
-	// Read the database file
-	fileBytes, err := os.ReadFile(filePath)
+	// Swap the refresh token for a new one
+	refreshToken, storedToken, err := newRefreshToken()
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		http.Error(w, `{"error": "Failed to generate refresh token"}`, http.StatusInternalServerError)
 		return
 	}
-
-	// Parse the JSON data
-	if err := json.Unmarshal(fileBytes, &database); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to parse database: %v"}`, err), http.StatusInternalServerError)
+	session, err := cfg.Store.RotateRefreshToken(hashToken(refreshTokenString), storedToken, cfg.clientIP(r), r.UserAgent())
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrRevoked) {
+		http.Error(w, `{"error": "Invalid or non-existent refresh token"}`, http.StatusUnauthorized)
 		return
 	}
-
-	// Extract the "users" map
-	users := database["users"]
-
-	// Find the user with the given refresh token
-	var storedUser handlers.User
-	found := false
-	for _, user := range users {
-		if user.RefreshToken == refreshTokenString {
-			// Check if the refresh token has expired
-			if time.Now().UTC().After(user.RefreshTokenExpiresAt) {
-				http.Error(w, `{"error": "Refresh token expired"}`, http.StatusUnauthorized)
-				return
-			}
-
-			storedUser = user
-			found = true
-			break
-		}
+	if errors.Is(err, storage.ErrTokenExpired) {
+		http.Error(w, `{"error": "Refresh token expired"}`, http.StatusUnauthorized)
+		return
+	}
+	if errors.Is(err, storage.ErrTokenReused) {
+		http.Error(w, `{"error": "Refresh token was already used, the session has been revoked"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
 	}
 
-	if !found {
+	// JWT token generation, with the user's current role
+	user, err := cfg.Store.GetUser(session.UserID)
+	if errors.Is(err, storage.ErrNotFound) {
 		http.Error(w, `{"error": "Invalid or non-existent refresh token"}`, http.StatusUnauthorized)
 		return
 	}
-
-	// JWT token generation
-	secretKey := []byte(JWTSecret)
-	expiresInSeconds := 1 * 60 * 60
-
-	// Create the JWT claims
-	claims := jwt.RegisteredClaims{
-		Issuer:    "chirpy",
-		Subject:   fmt.Sprintf("%v", storedUser.GetID()),
-		IssuedAt:  jwt.NewNumericDate(time.Now().UTC()),
-		ExpiresAt: jwt.NewNumericDate(time.Now().UTC().Add(time.Second * time.Duration(expiresInSeconds))),
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
 	}
-
-	// Create the token with HS256 signing method
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-
-	// Sign the token with the secret key
-	tokenString, err := token.SignedString(secretKey)
+	tokenString, err := cfg.issueAccessToken(user, session.ID, time.Hour)
 	if err != nil {
 		http.Error(w, `{"error": "Failed to sign token"}`, http.StatusInternalServerError)
 		return
 	}
 
-	// Respond with the new access token
+	// Respond with the new access token and the refresh token that replaces the old one
 	response := map[string]interface{}{
-		"token": tokenString,
+		"token":         tokenString,
+		"refresh_token": refreshToken,
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -420,69 +388,19 @@ func (cfg *ApiConfig) HandlerRevokeToken(w http.ResponseWriter, r *http.Request)
 	}
 
 	// Extract the refresh token from the Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
+	refreshTokenString, ok := bearerToken(r)
+	if !ok {
 		http.Error(w, `{"error": "Invalid or missing Authorization header"}`, http.StatusUnauthorized)
 		return
 	}
-	refreshTokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	// Define the file path to the database
-	filePath := "database.json"
-
-	// Initialize a map to hold the data from the database
-	database := map[string]map[string]handlers.User{}
-
-	// Read the database file
-	fileBytes, err := os.ReadFile(filePath)
-	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
-		return
-	}
 
-	// Parse the JSON data
-	if err := json.Unmarshal(fileBytes, &database); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to parse database: %v"}`, err), http.StatusInternalServerError)
-		return
-	}
-
-	// Extract the "users" map
-	users := database["users"]
-
-	// Find and update the user with the given refresh token
-	var userUpdated bool
-	for id, user := range users {
-		if user.RefreshToken == refreshTokenString {
-			// Check if the refresh token has expired
-			if time.Now().UTC().After(user.RefreshTokenExpiresAt) {
-				http.Error(w, `{"error": "Refresh token expired"}`, http.StatusUnauthorized)
-				return
-			}
-
-			// Remove the refresh token and reset the expiration
-			user.RefreshToken = ""
-			user.RefreshTokenExpiresAt = time.Time{}
-
-			// Update the user in the map
-			users[id] = user
-			userUpdated = true
-			break
-		}
-	}
-
-	if !userUpdated {
+	// End the session the refresh token belongs to
+	err := cfg.Store.RevokeSessionByRefreshToken(hashToken(refreshTokenString))
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrRevoked) {
 		http.Error(w, `{"error": "Invalid or non-existent refresh token"}`, http.StatusUnauthorized)
 		return
 	}
-
-	// Update the database file with the removed refresh token
-	database["users"] = users
-	fileBytes, err = json.Marshal(database)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to serialize database: %v"}`, err), http.StatusInternalServerError)
-		return
-	}
-	if err := os.WriteFile(filePath, fileBytes, 0644); err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
@@ -492,67 +410,51 @@ func (cfg *ApiConfig) HandlerRevokeToken(w http.ResponseWriter, r *http.Request)
 }
 
 func (cfg *ApiConfig) HandlerAddChirps(w http.ResponseWriter, r *http.Request) {
-	var chirp handlers.Chirp
-	decoder := json.NewDecoder(r.Body)
-	if err := decoder.Decode(&chirp); err != nil {
-		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+	authorID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
 		return
 	}
-
-	// Extract the access token from the Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, `{"error": "Invalid or missing Authorization header"}`, http.StatusUnauthorized)
+	if !cfg.requireVerifiedEmail(w, authorID) {
 		return
 	}
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	cfg.Mu.Lock()
-	JWTSecret := cfg.JWTSecret
-	cfg.Mu.Unlock()
-
-	// Define the claims struct
-	claims := &jwt.RegisteredClaims{}
 
-	// Parse and validate the JWT token
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(JWTSecret), nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, `{"error": "Invalid or expired access token"}`, http.StatusUnauthorized)
+	var chirp handlers.Chirp
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&chirp); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
 		return
 	}
 
-	// Extract the user ID (authorID) from the JWT claims
-	authorID, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		http.Error(w, `{"error": "Invalid token subject"}`, http.StatusUnauthorized)
+	// Check the body and mask banned words
+	body, errs := cfg.validateChirpBody(chirp.Body)
+	if len(errs) > 0 {
+		writeValidationErrors(w, errs)
 		return
 	}
+	chirp.Body = body
 
 	// Set the chirp's AuthorID
 	chirp.AuthorID = authorID
 
-	mutex.Lock()
-	chirp.SetID(chirpsID) // Use the setter method
-	chirpsID++
-	mutex.Unlock()
-
-	if err := handlers.AddDataToDatabase(w, chirp, "chirps"); err != nil {
+	// The store assigns the chirp's ID
+	chirp, err := cfg.Store.CreateChirp(chirp)
+	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to save chirp: %v"}`, err), http.StatusInternalServerError)
-		mutex.Lock()
-		chirpsID--
-		mutex.Unlock()
 		return
 	}
+	cfg.Search.Add(chirp.GetID(), chirp.Body)
 
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusCreated)
 	// Include ID in the response explicitly
 	response := map[string]interface{}{
-		"id":        chirp.GetID(),
-		"body":      chirp.Body,
-		"author_id": chirp.AuthorID,
+		"id":         chirp.GetID(),
+		"body":       chirp.Body,
+		"author_id":  chirp.AuthorID,
+		"created_at": chirp.CreatedAt,
+		"updated_at": chirp.UpdatedAt,
+		"edited":     chirp.Edited,
 	}
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
@@ -563,85 +465,43 @@ func (cfg *ApiConfig) HandlerDeleteChirps(w http.ResponseWriter, r *http.Request
 	// Extract the chirp ID from the URL path
 	chirpIDStr := strings.TrimPrefix(r.URL.Path, "/api/chirps/")
 
-	// Extract the access token from the Authorization header
-	authHeader := r.Header.Get("Authorization")
-	if !strings.HasPrefix(authHeader, "Bearer ") {
-		http.Error(w, `{"error": "Invalid or missing Authorization header"}`, http.StatusUnauthorized)
+	authorID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
 		return
 	}
-	tokenString := strings.TrimPrefix(authHeader, "Bearer ")
-
-	cfg.Mu.Lock()
-	JWTSecret := cfg.JWTSecret
-	cfg.Mu.Unlock()
-
-	// Define the claims struct
-	claims := &jwt.RegisteredClaims{}
 
-	// Parse and validate the JWT token
-	token, err := jwt.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
-		return []byte(JWTSecret), nil
-	})
-	if err != nil || !token.Valid {
-		http.Error(w, `{"error": "Invalid or expired access token"}`, http.StatusUnauthorized)
+	// Find the chirp by ID
+	chirpID, err := strconv.Atoi(chirpIDStr)
+	if err != nil {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
 		return
 	}
-
-	// Extract the user ID (authorID) from the JWT claims
-	authorID, err := strconv.Atoi(claims.Subject)
-	if err != nil {
-		http.Error(w, `{"error": "Invalid token subject"}`, http.StatusUnauthorized)
+	chirp, err := cfg.Store.GetChirp(chirpID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
 		return
 	}
-
-	// Define the file path to the database
-	filePath := "database.json"
-
-	// Initialize a map to hold the data from the database
-	var database handlers.Database
-
-	// Read the database file
-	fileBytes, err := os.ReadFile(filePath)
 	if err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
 
-	// Parse the JSON data
-	if err := json.Unmarshal(fileBytes, &database); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to parse database: %v"}`, err), http.StatusInternalServerError)
-		return
-	}
-
-	// Extract the "chirps" map
-	chirps := database.Chirps
-
-	// Find the chirp by ID
-	chirp, exists := chirps[chirpIDStr]
-	if !exists {
-		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
-		return
-	}
-
-	// Check if the chirp's author ID matches the token's author ID
-	if chirp.AuthorID != authorID {
+	// Authors can delete their own chirps, moderators anyone's
+	moderating := chirp.AuthorID != authorID
+	if moderating && !handlers.RoleAtLeast(RoleFromContext(r.Context()), handlers.RoleModerator) {
 		http.Error(w, `{"error": "Forbidden: You do not have permission to delete this chirp"}`, http.StatusForbidden)
 		return
 	}
 
-	delete(chirps, chirpIDStr)
-
-	// Write the updated database back to the file
-	updatedDatabase, err := json.MarshalIndent(database, "", "  ")
-	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to marshal database: %v"}`, err), http.StatusInternalServerError)
-		return
-	}
-
-	if err := os.WriteFile(filePath, updatedDatabase, 0644); err != nil {
+	if err := cfg.Store.DeleteChirp(chirpID); err != nil {
 		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
+	cfg.Search.Remove(chirpID)
+	if moderating {
+		log.Printf("User %d deleted chirp %d by user %d as %s", authorID, chirpID, chirp.AuthorID, RoleFromContext(r.Context()))
+	}
 
 	// Return a success response
 	w.WriteHeader(http.StatusNoContent)
@@ -677,55 +537,236 @@ func (cfg *ApiConfig) HandlerPolkaWebhooks(w http.ResponseWriter, r *http.Reques
 		return
 	}
 
-	// Define the file path to the database
-	filePath := "database.json"
+	// Update the user's IsChirpyRed field
+	_, err := cfg.Store.UpdateUser(webhookReq.Data.UserID, func(user *handlers.User) error {
+		user.IsChirpyRed = true
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Respond with a 204 status code
+	w.WriteHeader(http.StatusNoContent)
 
-	// Initialize a map to hold the data from the database
-	var database handlers.Database
+}
 
-	// Read the database file
-	fileBytes, err := os.ReadFile(filePath)
+func (cfg *ApiConfig) HandlerGetChirps(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var err error
+	query := storage.ChirpQuery{}
+
+	// Filter by author_id if provided
+	authorIDStr := r.URL.Query().Get("author_id")
+	if authorIDStr != "" {
+		authorID, err := strconv.Atoi(authorIDStr)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid author_id format"}`, http.StatusBadRequest)
+			return
+		}
+		query.AuthorID = authorID
+	}
+
+	// Only chirps created in [since, until)
+	query.Since, err = parseTimeParam(r, "since")
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		http.Error(w, `{"error": "Invalid since, expected an RFC 3339 timestamp"}`, http.StatusBadRequest)
 		return
 	}
+	query.Until, err = parseTimeParam(r, "until")
+	if err != nil {
+		http.Error(w, `{"error": "Invalid until, expected an RFC 3339 timestamp"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Determine the sorting order by creation time, defaulting to oldest first
+	query.Desc = r.URL.Query().Get("sort") == "desc"
 
-	// Parse the JSON data
-	if err := json.Unmarshal(fileBytes, &database); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to parse database: %v"}`, err), http.StatusInternalServerError)
+	// Resume where the previous page ended
+	cursor := pageCursor{}
+	if encoded := r.URL.Query().Get("cursor"); encoded != "" {
+		cursor, err = decodeCursor(encoded)
+		if err != nil {
+			http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+	}
+
+	pageSize, err := parsePageSize(r)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid limit"}`, http.StatusBadRequest)
 		return
 	}
 
-	// Extract the "users" map
-	users := database.Users
+	// Keyword searches are ranked by relevance instead of by ID
+	if q := r.URL.Query().Get("q"); q != "" {
+		if cursor.After != nil {
+			http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+			return
+		}
+
+		chirpsArray, hasMore, err := cfg.searchChirps(q, query, cursor.Offset, pageSize)
+		if err != nil {
+			http.Error(w, fmt.Sprintf(`{"error": "Failed to search chirps: %v"}`, err), http.StatusInternalServerError)
+			return
+		}
+		if hasMore {
+			setNextLink(w, r, encodeCursor(pageCursor{Offset: cursor.Offset + pageSize}))
+		}
 
-	// Find the user by user_id from the webhook data
-	userID := fmt.Sprintf("%d", webhookReq.Data.UserID)
-	user, exists := users[userID]
-	if !exists {
-		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusOK)
+		if err := json.NewEncoder(w).Encode(chirpsArray); err != nil {
+			http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+		}
 		return
 	}
 
-	// Update the user's IsChirpyRed field
-	user.IsChirpyRed = true
+	if cursor.Offset != 0 {
+		http.Error(w, `{"error": "Invalid cursor"}`, http.StatusBadRequest)
+		return
+	}
+	query.After = cursor.After
 
-	// Update the database with the modified user
-	users[userID] = user
-	database.Users = users
+	// Ask for one extra chirp to find out whether there is a next page
+	query.Limit = pageSize + 1
 
-	// Write the updated data back to the database
-	fileBytes, err = json.Marshal(database)
+	chirpsArray, err := cfg.Store.ListChirps(query)
 	if err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to serialize database: %v"}`, err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read chirps: %v"}`, err), http.StatusInternalServerError)
 		return
 	}
-	if err := os.WriteFile(filePath, fileBytes, 0644); err != nil {
-		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+
+	if len(chirpsArray) > pageSize {
+		chirpsArray = chirpsArray[:pageSize]
+		lastPosition := storage.PositionOf(chirpsArray[len(chirpsArray)-1])
+		setNextLink(w, r, encodeCursor(pageCursor{After: &lastPosition}))
+	}
+
+	// Set the response headers and write the JSON array of chirps
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	if err := json.NewEncoder(w).Encode(chirpsArray); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+func (cfg *ApiConfig) HandlerGetChirpsID(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Respond with a 204 status code
-	w.WriteHeader(http.StatusNoContent)
+	// Extract chirpID from the URL
+	path := r.URL.Path
+	if !strings.HasPrefix(path, "/api/chirps/") {
+		http.Error(w, `{"error": "Invalid URL format"}`, http.StatusBadRequest)
+		return
+	}
+
+	// Extract chirpID by splitting the path
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != 3 {
+		http.Error(w, `{"error": "Invalid URL format"}`, http.StatusBadRequest)
+		return
+	}
+
+	chirpID, err := strconv.Atoi(parts[2])
+	if err != nil {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
+		return
+	}
+
+	// Look up the chirp in the database
+	chirp, err := cfg.Store.GetChirp(chirpID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Chirp not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read chirps: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Set the content type and encode the chirp into the response
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(chirp); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Error encoding response: %v"}`, err), http.StatusInternalServerError)
+	}
+}
+
+func (cfg *ApiConfig) HandlerAddUser(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, `{"error": "Method not allowed"}`, http.StatusMethodNotAllowed)
+		return
+	}
+
+	var params handlers.User
+	decoder := json.NewDecoder(r.Body)
+	if err := decoder.Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
 
+	// Only the email and password come from the client; the rest of the
+	// account (Chirpy Red, two-factor authentication) is set by the server
+	user := handlers.User{Email: params.Email, Password: params.Password}
+
+	// Check the password against the password policy
+	passwordErrs, err := cfg.checkPassword(user.Password, user.Email)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to check password: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if len(passwordErrs) > 0 {
+		writePasswordErrors(w, passwordErrs)
+		return
+	}
+
+	// Hash the user's password using bcrypt
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(user.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to hash password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Update the user's password with the hashed password
+	user.Password = string(hashedPassword)
+
+	// The store assigns the user's ID
+	user, err = cfg.Store.CreateUser(user)
+	if err != nil {
+		if errors.Is(err, storage.ErrAlreadyExists) {
+			http.Error(w, `{"error": "user email already exists"}`, http.StatusBadRequest)
+			return
+		}
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to save user: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	cfg.sendEmailVerificationLogged(user)
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	// Include ID in the response explicitly
+	response := map[string]interface{}{
+		"id":             user.GetID(),
+		"email":          user.Email,
+		"is_chirpy_red":  user.IsChirpyRed,
+		"email_verified": user.EmailVerified,
+		"created_at":     user.CreatedAt,
+		"updated_at":     user.UpdatedAt,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
 }
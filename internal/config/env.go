@@ -0,0 +1,208 @@
+package config
+
+import (
+	"fmt"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/RichardHoa/go-server/internal/lockout"
+	"github.com/RichardHoa/go-server/internal/mail"
+	"github.com/RichardHoa/go-server/internal/oidc"
+	"github.com/RichardHoa/go-server/internal/password"
+	"github.com/RichardHoa/go-server/internal/signing"
+	"github.com/RichardHoa/go-server/internal/wordfilter"
+)
+
+// Port is where the server listens, and what PUBLIC_URL defaults to
+const Port = "8080"
+
+// FromEnv builds the server's settings from environment variables read with
+// getenv, usually os.Getenv. The store and search index are left for the
+// caller to set.
+func FromEnv(getenv func(string) string) (*ApiConfig, error) {
+	cfg := &ApiConfig{
+		JWTSecret:    getenv("JWT_SECRET"),
+		PolkaAPIKey:  getenv("POLKA_API_KEY"),
+		EmailLockout: lockout.New(DefaultEmailLockout),
+		IPLockout:    lockout.New(DefaultIPLockout),
+	}
+
+	// Behind a reverse proxy, TRUSTED_PROXIES lets lockouts and sessions see
+	// the client's address instead of the proxy's
+	if proxies := getenv("TRUSTED_PROXIES"); proxies != "" {
+		var err error
+		cfg.TrustedProxies, err = ParseTrustedProxies(proxies)
+		if err != nil {
+			return nil, fmt.Errorf("could not load trusted proxies: %v", err)
+		}
+	}
+
+	// Sign tokens with the PEM keys in JWT_KEYS_DIR instead of JWT_SECRET
+	if keysDir := getenv("JWT_KEYS_DIR"); keysDir != "" {
+		var err error
+		cfg.SigningKeys, err = signing.LoadDir(keysDir, getenv("JWT_SIGNING_KID"))
+		if err != nil {
+			return nil, fmt.Errorf("could not load signing keys: %v", err)
+		}
+	}
+
+	// Write emails to MAIL_DIR instead of the log
+	if mailDir := getenv("MAIL_DIR"); mailDir != "" {
+		cfg.Mailer = mail.DirMailer{Dir: mailDir}
+	}
+
+	// Links in emails point at PUBLIC_URL
+	cfg.PublicURL = getenv("PUBLIC_URL")
+	if cfg.PublicURL == "" {
+		cfg.PublicURL = "http://localhost:" + Port
+	}
+
+	// REQUIRE_VERIFIED_EMAIL=true keeps unverified users from posting chirps
+	if require := getenv("REQUIRE_VERIFIED_EMAIL"); require != "" {
+		var err error
+		cfg.RequireVerifiedEmail, err = strconv.ParseBool(require)
+		if err != nil {
+			return nil, fmt.Errorf("REQUIRE_VERIFIED_EMAIL must be true or false, got %q", require)
+		}
+	}
+
+	// DELETED_USER_CHIRPS=anonymize keeps a deleted user's chirps without an author
+	switch mode := getenv("DELETED_USER_CHIRPS"); mode {
+	case "", DeletedChirpsDelete:
+		cfg.DeletedUserChirps = DeletedChirpsDelete
+	case DeletedChirpsAnonymize:
+		cfg.DeletedUserChirps = DeletedChirpsAnonymize
+	default:
+		return nil, fmt.Errorf("DELETED_USER_CHIRPS must be %q or %q, got %q", DeletedChirpsDelete, DeletedChirpsAnonymize, mode)
+	}
+
+	// Rules for new passwords
+	if err := loadPasswordPolicy(cfg, getenv); err != nil {
+		return nil, fmt.Errorf("could not load password policy: %v", err)
+	}
+
+	// "Login with" providers
+	if err := loadOIDCProviders(cfg, getenv); err != nil {
+		return nil, fmt.Errorf("could not load login providers: %v", err)
+	}
+
+	// Rules for chirp bodies
+	if err := loadChirpRules(cfg, getenv); err != nil {
+		return nil, fmt.Errorf("could not load chirp rules: %v", err)
+	}
+	return cfg, nil
+}
+
+// loadChirpRules reads the chirp length limit and banned word settings
+func loadChirpRules(cfg *ApiConfig, getenv func(string) string) error {
+	cfg.ChirpMaxLength = DefaultChirpMaxLength
+	if maxLength := getenv("CHIRP_MAX_LENGTH"); maxLength != "" {
+		n, err := strconv.Atoi(maxLength)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("CHIRP_MAX_LENGTH must be a positive number, got %q", maxLength)
+		}
+		cfg.ChirpMaxLength = n
+	}
+
+	// Without a word list file the built-in list is used
+	cfg.WordFilter = wordfilter.New(wordfilter.DefaultWords)
+	if path := getenv("WORD_FILTER_FILE"); path != "" {
+		filter, err := wordfilter.Load(path)
+		if err != nil {
+			return err
+		}
+		cfg.WordFilter = filter
+	}
+
+	switch mode := getenv("WORD_FILTER_MODE"); mode {
+	case "", WordFilterMask:
+		cfg.WordFilterMode = WordFilterMask
+	case WordFilterReject:
+		cfg.WordFilterMode = WordFilterReject
+	default:
+		return fmt.Errorf("WORD_FILTER_MODE must be %q or %q, got %q", WordFilterMask, WordFilterReject, mode)
+	}
+	return nil
+}
+
+// loadPasswordPolicy reads the password rules and the breached password list
+func loadPasswordPolicy(cfg *ApiConfig, getenv func(string) string) error {
+	cfg.PasswordPolicy = password.DefaultPolicy
+	if minLength := getenv("PASSWORD_MIN_LENGTH"); minLength != "" {
+		n, err := strconv.Atoi(minLength)
+		if err != nil || n <= 0 {
+			return fmt.Errorf("PASSWORD_MIN_LENGTH must be a positive number, got %q", minLength)
+		}
+		cfg.PasswordPolicy.MinLength = n
+	}
+	if err := cfg.PasswordPolicy.ParseClasses(getenv("PASSWORD_REQUIRE")); err != nil {
+		return fmt.Errorf("PASSWORD_REQUIRE: %v", err)
+	}
+	if allowEmail := getenv("PASSWORD_ALLOW_EMAIL"); allowEmail != "" {
+		allow, err := strconv.ParseBool(allowEmail)
+		if err != nil {
+			return fmt.Errorf("PASSWORD_ALLOW_EMAIL must be true or false, got %q", allowEmail)
+		}
+		cfg.PasswordPolicy.AllowEmail = allow
+	}
+
+	// Without a list the bundled one is used; a directory is read one range
+	// file at a time
+	switch path := getenv("PASSWORD_BREACH_LIST"); path {
+	case "":
+		cfg.Breaches = password.Bundled()
+	case "off":
+		cfg.Breaches = nil
+	default:
+		info, err := os.Stat(path)
+		if err != nil {
+			return fmt.Errorf("could not open breach list: %v", err)
+		}
+		if info.IsDir() {
+			cfg.Breaches = password.BreachDir{Dir: path}
+			return nil
+		}
+		list, err := password.LoadBreachList(path)
+		if err != nil {
+			return err
+		}
+		cfg.Breaches = list
+	}
+	return nil
+}
+
+// providerName is what a login provider can be called; it ends up in URLs
+var providerName = regexp.MustCompile(`^[a-z0-9-]+$`)
+
+// loadOIDCProviders reads the "login with" providers listed in OIDC_PROVIDERS.
+// Each is configured with OIDC_<NAME>_ISSUER, _CLIENT_ID, _CLIENT_SECRET and
+// optionally _SCOPES.
+func loadOIDCProviders(cfg *ApiConfig, getenv func(string) string) error {
+	cfg.OIDCProviders = make(map[string]*oidc.Provider)
+	for _, name := range strings.Split(getenv("OIDC_PROVIDERS"), ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+		if !providerName.MatchString(name) {
+			return fmt.Errorf("provider name %q may only contain lowercase letters, digits and dashes", name)
+		}
+
+		prefix := "OIDC_" + strings.ToUpper(strings.ReplaceAll(name, "-", "_")) + "_"
+		provider := &oidc.Provider{
+			Name:         name,
+			Issuer:       getenv(prefix + "ISSUER"),
+			ClientID:     getenv(prefix + "CLIENT_ID"),
+			ClientSecret: getenv(prefix + "CLIENT_SECRET"),
+			RedirectURL:  strings.TrimSuffix(cfg.PublicURL, "/") + "/api/oidc/" + name + "/callback",
+			Scopes:       strings.Fields(getenv(prefix + "SCOPES")),
+		}
+		if provider.Issuer == "" || provider.ClientID == "" {
+			return fmt.Errorf("%sISSUER and %sCLIENT_ID are required", prefix, prefix)
+		}
+		cfg.OIDCProviders[name] = provider
+	}
+	return nil
+}
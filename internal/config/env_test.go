@@ -0,0 +1,111 @@
+package config
+
+import (
+	"slices"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/mail"
+	"github.com/RichardHoa/go-server/internal/password"
+)
+
+// env returns a getenv that reads from vars
+func env(vars map[string]string) func(string) string {
+	return func(key string) string {
+		return vars[key]
+	}
+}
+
+func TestFromEnvDefaults(t *testing.T) {
+	cfg, err := FromEnv(env(nil))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.PublicURL != "http://localhost:"+Port {
+		t.Errorf("PublicURL = %q", cfg.PublicURL)
+	}
+	if cfg.RequireVerifiedEmail || cfg.DeletedUserChirps != DeletedChirpsDelete {
+		t.Errorf("RequireVerifiedEmail = %v, DeletedUserChirps = %q", cfg.RequireVerifiedEmail, cfg.DeletedUserChirps)
+	}
+	if cfg.ChirpMaxLength != DefaultChirpMaxLength || cfg.WordFilterMode != WordFilterMask {
+		t.Errorf("ChirpMaxLength = %d, WordFilterMode = %q", cfg.ChirpMaxLength, cfg.WordFilterMode)
+	}
+	if cfg.PasswordPolicy != password.DefaultPolicy || cfg.Breaches == nil {
+		t.Errorf("PasswordPolicy = %+v, Breaches = %v", cfg.PasswordPolicy, cfg.Breaches)
+	}
+	if cfg.EmailLockout == nil || cfg.IPLockout == nil {
+		t.Error("lockouts are off")
+	}
+	if cfg.SigningKeys != nil || cfg.Mailer != nil || cfg.TrustedProxies != nil || len(cfg.OIDCProviders) != 0 {
+		t.Errorf("optional settings are on: %+v", cfg)
+	}
+}
+
+func TestFromEnv(t *testing.T) {
+	cfg, err := FromEnv(env(map[string]string{
+		"JWT_SECRET":             "secret",
+		"PUBLIC_URL":             "https://chirpy.example/",
+		"REQUIRE_VERIFIED_EMAIL": "true",
+		"DELETED_USER_CHIRPS":    DeletedChirpsAnonymize,
+		"MAIL_DIR":               "mail",
+		"TRUSTED_PROXIES":        "10.0.0.0/8",
+		"CHIRP_MAX_LENGTH":       "280",
+		"WORD_FILTER_MODE":       WordFilterReject,
+		"PASSWORD_MIN_LENGTH":    "12",
+		"PASSWORD_ALLOW_EMAIL":   "true",
+		"PASSWORD_BREACH_LIST":   "off",
+		"OIDC_PROVIDERS":         "acme-id, ",
+		"OIDC_ACME_ID_ISSUER":    "https://id.acme.example",
+		"OIDC_ACME_ID_CLIENT_ID": "chirpy",
+		"OIDC_ACME_ID_SCOPES":    "openid email",
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.JWTSecret != "secret" || !cfg.RequireVerifiedEmail || cfg.DeletedUserChirps != DeletedChirpsAnonymize {
+		t.Errorf("JWTSecret = %q, RequireVerifiedEmail = %v, DeletedUserChirps = %q", cfg.JWTSecret, cfg.RequireVerifiedEmail, cfg.DeletedUserChirps)
+	}
+	if cfg.Mailer != (mail.DirMailer{Dir: "mail"}) || len(cfg.TrustedProxies) != 1 {
+		t.Errorf("Mailer = %v, TrustedProxies = %v", cfg.Mailer, cfg.TrustedProxies)
+	}
+	if cfg.ChirpMaxLength != 280 || cfg.WordFilterMode != WordFilterReject {
+		t.Errorf("ChirpMaxLength = %d, WordFilterMode = %q", cfg.ChirpMaxLength, cfg.WordFilterMode)
+	}
+	if cfg.PasswordPolicy.MinLength != 12 || !cfg.PasswordPolicy.AllowEmail || cfg.Breaches != nil {
+		t.Errorf("PasswordPolicy = %+v, Breaches = %v", cfg.PasswordPolicy, cfg.Breaches)
+	}
+
+	provider := cfg.OIDCProviders["acme-id"]
+	if len(cfg.OIDCProviders) != 1 || provider == nil {
+		t.Fatalf("OIDCProviders = %v", cfg.OIDCProviders)
+	}
+	if provider.Issuer != "https://id.acme.example" || provider.ClientID != "chirpy" || !slices.Equal(provider.Scopes, []string{"openid", "email"}) {
+		t.Errorf("provider = %+v", provider)
+	}
+	if provider.RedirectURL != "https://chirpy.example/api/oidc/acme-id/callback" {
+		t.Errorf("RedirectURL = %q", provider.RedirectURL)
+	}
+}
+
+func TestFromEnvRejects(t *testing.T) {
+	tests := []map[string]string{
+		{"TRUSTED_PROXIES": "proxy.example"},
+		{"JWT_KEYS_DIR": "does-not-exist"},
+		{"REQUIRE_VERIFIED_EMAIL": "sometimes"},
+		{"DELETED_USER_CHIRPS": "keep"},
+		{"CHIRP_MAX_LENGTH": "0"},
+		{"WORD_FILTER_FILE": "does-not-exist"},
+		{"WORD_FILTER_MODE": "shout"},
+		{"PASSWORD_MIN_LENGTH": "-1"},
+		{"PASSWORD_REQUIRE": "emoji"},
+		{"PASSWORD_ALLOW_EMAIL": "maybe"},
+		{"PASSWORD_BREACH_LIST": "does-not-exist"},
+		{"OIDC_PROVIDERS": "Acme"},
+		{"OIDC_PROVIDERS": "acme", "OIDC_ACME_ISSUER": "https://id.acme.example"},
+	}
+
+	for _, vars := range tests {
+		if _, err := FromEnv(env(vars)); err == nil {
+			t.Errorf("FromEnv accepted %v", vars)
+		}
+	}
+}
@@ -0,0 +1,51 @@
+package config
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/lockout"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// Default lockouts for failed logins. An IP gets more attempts than an
+// account, as many users can share one address.
+var (
+	DefaultEmailLockout = lockout.Policy{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Forget: time.Hour}
+	DefaultIPLockout    = lockout.Policy{FreeAttempts: 20, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Forget: time.Hour}
+)
+
+// loginKeys are the lockout keys for a login attempt
+func (cfg *ApiConfig) loginKeys(email string, r *http.Request) (emailKey, ipKey string) {
+	return strings.ToLower(strings.TrimSpace(email)), cfg.clientIP(r)
+}
+
+// loginLocked writes a 429 response and returns true if the account or the
+// client is locked out
+func (cfg *ApiConfig) loginLocked(w http.ResponseWriter, emailKey, ipKey string) bool {
+	wait := max(cfg.EmailLockout.Locked(emailKey), cfg.IPLockout.Locked(ipKey))
+	if wait == 0 {
+		return false
+	}
+
+	w.Header().Set("Retry-After", fmt.Sprint(int(math.Ceil(wait.Seconds()))))
+	http.Error(w, `{"error": "Too many failed login attempts, try again later"}`, http.StatusTooManyRequests)
+	return true
+}
+
+// loginFailed counts a failed attempt against the account and the client and
+// writes the response, which is the same for an unknown email and a wrong
+// password
+func (cfg *ApiConfig) loginFailed(w http.ResponseWriter, emailKey, ipKey string) {
+	cfg.EmailLockout.Fail(emailKey)
+	cfg.IPLockout.Fail(ipKey)
+	http.Error(w, `{"error": "Incorrect email or password"}`, http.StatusUnauthorized)
+}
+
+// dummyPasswordHash is compared against when the email is unknown, so that a
+// login for an unknown user takes as long as one with a wrong password. It is
+// made at startup; making it on first use would slow down that one request.
+var dummyPasswordHash, _ = bcrypt.GenerateFromPassword([]byte("not a real password"), bcrypt.DefaultCost)
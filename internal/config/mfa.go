@@ -0,0 +1,398 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/base32"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+	"github.com/RichardHoa/go-server/internal/totp"
+)
+
+const (
+	// mfaChallengeLifetime is how long a client has to enter its code after
+	// the password step of a login
+	mfaChallengeLifetime = 5 * time.Minute
+
+	// totpIssuer is the account name's prefix in authenticator apps
+	totpIssuer = "Chirpy"
+
+	// recoveryCodeCount is how many recovery codes enabling 2FA hands out
+	recoveryCodeCount = 10
+)
+
+var (
+	errInvalidMFACode    = errors.New("invalid authentication code")
+	errTOTPEnabled       = errors.New("two-factor authentication is already enabled")
+	errTOTPNotEnabled    = errors.New("two-factor authentication is not enabled")
+	errTOTPNotEnrolled   = errors.New("two-factor authentication enrolment has not been started")
+	errMFAChallengeStale = errors.New("mfa challenge issued before a password change")
+)
+
+// mfaParams is the second factor a client sends: a code from the
+// authenticator app or one of the recovery codes
+type mfaParams struct {
+	Code         string `json:"code"`
+	RecoveryCode string `json:"recovery_code"`
+}
+
+// checkSecondFactor checks the code or recovery code against the user and
+// uses it up, so the same code cannot be used twice. It is called inside
+// UpdateUser so that two requests cannot both use one code.
+func checkSecondFactor(user *handlers.User, params mfaParams) error {
+	if !user.TOTPEnabled {
+		return errTOTPNotEnabled
+	}
+
+	if params.Code != "" {
+		step, ok := totp.Validate(user.TOTPSecret, params.Code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return errInvalidMFACode
+		}
+		user.TOTPLastStep = step
+		return nil
+	}
+
+	if params.RecoveryCode != "" {
+		hash := hashToken(normalizeRecoveryCode(params.RecoveryCode))
+		i := slices.Index(user.RecoveryCodes, hash)
+		if i < 0 {
+			return errInvalidMFACode
+		}
+		user.RecoveryCodes = slices.Delete(slices.Clone(user.RecoveryCodes), i, i+1)
+		return nil
+	}
+	return errInvalidMFACode
+}
+
+// newRecoveryCodes returns recovery codes to show the user once and the
+// hashes to store for them
+func newRecoveryCodes() (codes []string, hashes []string, err error) {
+	encoding := base32.StdEncoding.WithPadding(base32.NoPadding)
+	for range recoveryCodeCount {
+		codeBytes := make([]byte, 10)
+		if _, err := rand.Read(codeBytes); err != nil {
+			return nil, nil, fmt.Errorf("could not generate recovery code: %v", err)
+		}
+		code := strings.ToLower(encoding.EncodeToString(codeBytes))
+		codes = append(codes, code[0:4]+"-"+code[4:8]+"-"+code[8:12]+"-"+code[12:16])
+		hashes = append(hashes, hashToken(code))
+	}
+	return codes, hashes, nil
+}
+
+// normalizeRecoveryCode drops the dashes and spaces people type in between
+// groups and ignores case
+func normalizeRecoveryCode(code string) string {
+	code = strings.NewReplacer("-", "", " ", "").Replace(code)
+	return strings.ToLower(code)
+}
+
+// writeMFAChallenge answers a correct password for an account with 2FA: the
+// client gets a short-lived token to send with its code to /api/login/mfa
+func (cfg *ApiConfig) writeMFAChallenge(w http.ResponseWriter, user handlers.User) {
+	mfaToken, err := cfg.issueToken(tokenTypeMFA, user.GetID(), 0, mfaChallengeLifetime)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to sign token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"mfa_required":       true,
+		"mfa_token":          mfaToken,
+		"expires_in_seconds": int(mfaChallengeLifetime.Seconds()),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandlerLoginMFA completes a login for an account with 2FA, taking the
+// challenge from the password step and a code from the authenticator app or
+// a recovery code
+func (cfg *ApiConfig) HandlerLoginMFA(w http.ResponseWriter, r *http.Request) {
+	type loginMFAParams struct {
+		mfaParams
+		MFAToken         string `json:"mfa_token"`
+		ExpiresInSeconds int    `json:"expires_in_seconds"`
+	}
+
+	var params loginMFAParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := cfg.parseToken(params.MFAToken, tokenTypeMFA)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid or expired MFA token"}`, http.StatusUnauthorized)
+		return
+	}
+	userID, err := strconv.Atoi(claims.Subject)
+	if err != nil {
+		http.Error(w, `{"error": "Invalid or expired MFA token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	// Each challenge completes a single login
+	denied, err := cfg.Store.IsAccessTokenDenied(claims.ID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if denied {
+		http.Error(w, `{"error": "Invalid or expired MFA token"}`, http.StatusUnauthorized)
+		return
+	}
+
+	storedUser, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "Invalid or expired MFA token"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Codes count against the same lockout as passwords, so six digits
+	// cannot be guessed one challenge after another
+	emailKey, ipKey := cfg.loginKeys(storedUser.Email, r)
+	if cfg.loginLocked(w, emailKey, ipKey) {
+		return
+	}
+
+	storedUser, err = cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+		// A password change since the password step ends the challenge
+		if issuedBeforeWatermark(claims.IssuedAt.Time, *user) {
+			return errMFAChallengeStale
+		}
+		return checkSecondFactor(user, params.mfaParams)
+	})
+	if errors.Is(err, errInvalidMFACode) {
+		cfg.mfaFailed(w, emailKey, ipKey)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, errMFAChallengeStale) || errors.Is(err, errTOTPNotEnabled) {
+		http.Error(w, `{"error": "Invalid or expired MFA token"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if err := cfg.Store.DenyAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	cfg.EmailLockout.Reset(emailKey)
+
+	cfg.writeLogin(w, r, storedUser, params.ExpiresInSeconds)
+}
+
+// mfaFailed counts a wrong code like a wrong password and writes the response
+func (cfg *ApiConfig) mfaFailed(w http.ResponseWriter, emailKey, ipKey string) {
+	cfg.EmailLockout.Fail(emailKey)
+	cfg.IPLockout.Fail(ipKey)
+	http.Error(w, `{"error": "Invalid authentication code"}`, http.StatusUnauthorized)
+}
+
+// HandlerStartTOTP starts enrolling an authenticator app. The secret is only
+// used for logins once a code from the app has been confirmed.
+func (cfg *ApiConfig) HandlerStartTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate secret"}`, http.StatusInternalServerError)
+		return
+	}
+
+	// Starting again replaces a secret that was never confirmed
+	user, err := cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+		if user.TOTPEnabled {
+			return errTOTPEnabled
+		}
+		user.TOTPSecret = secret
+		user.TOTPLastStep = 0
+		return nil
+	})
+	if errors.Is(err, errTOTPEnabled) {
+		http.Error(w, `{"error": "Two-factor authentication is already enabled"}`, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// The URI is what the QR code for the authenticator app encodes
+	response := map[string]interface{}{
+		"secret":      secret,
+		"otpauth_uri": totp.URI(totpIssuer, user.Email, secret),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandlerConfirmTOTP enables 2FA once the user has entered a code from the
+// app and hands out the recovery codes. They are only shown this once.
+func (cfg *ApiConfig) HandlerConfirmTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	var params mfaParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Wrong codes count against the login lockout here too
+	emailKey, ipKey := cfg.loginKeys(user.Email, r)
+	if cfg.loginLocked(w, emailKey, ipKey) {
+		return
+	}
+
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		http.Error(w, `{"error": "Failed to generate recovery codes"}`, http.StatusInternalServerError)
+		return
+	}
+
+	_, err = cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+		if user.TOTPEnabled {
+			return errTOTPEnabled
+		}
+		if user.TOTPSecret == "" {
+			return errTOTPNotEnrolled
+		}
+		step, ok := totp.Validate(user.TOTPSecret, params.Code, time.Now(), user.TOTPLastStep)
+		if !ok {
+			return errInvalidMFACode
+		}
+		user.TOTPEnabled = true
+		user.TOTPLastStep = step
+		user.RecoveryCodes = hashes
+		return nil
+	})
+	if errors.Is(err, errTOTPEnabled) {
+		http.Error(w, `{"error": "Two-factor authentication is already enabled"}`, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errTOTPNotEnrolled) {
+		http.Error(w, `{"error": "Start two-factor authentication enrolment first"}`, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errInvalidMFACode) {
+		cfg.mfaFailed(w, emailKey, ipKey)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"totp_enabled":   true,
+		"recovery_codes": codes,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandlerDisableTOTP turns 2FA off. A stolen access token alone is not
+// enough: it takes a code from the app or a recovery code.
+func (cfg *ApiConfig) HandlerDisableTOTP(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	var params mfaParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Wrong codes count against the login lockout here too
+	emailKey, ipKey := cfg.loginKeys(user.Email, r)
+	if cfg.loginLocked(w, emailKey, ipKey) {
+		return
+	}
+
+	_, err = cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+		if err := checkSecondFactor(user, params); err != nil {
+			return err
+		}
+		user.TOTPEnabled = false
+		user.TOTPSecret = ""
+		user.TOTPLastStep = 0
+		user.RecoveryCodes = nil
+		return nil
+	})
+	if errors.Is(err, errTOTPNotEnabled) {
+		http.Error(w, `{"error": "Two-factor authentication is not enabled"}`, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errInvalidMFACode) {
+		cfg.mfaFailed(w, emailKey, ipKey)
+		return
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
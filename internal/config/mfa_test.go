@@ -0,0 +1,76 @@
+package config
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/totp"
+)
+
+func newTOTPUser(t *testing.T) (*handlers.User, []string) {
+	t.Helper()
+	secret, err := totp.GenerateSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	codes, hashes, err := newRecoveryCodes()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &handlers.User{TOTPSecret: secret, TOTPEnabled: true, RecoveryCodes: hashes}, codes
+}
+
+func TestCheckSecondFactorRejectsReplayedCode(t *testing.T) {
+	user, _ := newTOTPUser(t)
+	code, err := totp.Code(user.TOTPSecret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := checkSecondFactor(user, mfaParams{Code: code}); err != nil {
+		t.Fatalf("rejected a fresh code: %v", err)
+	}
+	if user.TOTPLastStep == 0 {
+		t.Error("the used step was not recorded")
+	}
+	if err := checkSecondFactor(user, mfaParams{Code: code}); !errors.Is(err, errInvalidMFACode) {
+		t.Errorf("replayed code got %v, want %v", err, errInvalidMFACode)
+	}
+}
+
+func TestCheckSecondFactorRecoveryCodeWorksOnce(t *testing.T) {
+	user, codes := newTOTPUser(t)
+
+	// People retype codes in capitals and without dashes
+	typed := strings.ToUpper(strings.ReplaceAll(codes[3], "-", ""))
+	if err := checkSecondFactor(user, mfaParams{RecoveryCode: typed}); err != nil {
+		t.Fatalf("rejected a recovery code: %v", err)
+	}
+	if len(user.RecoveryCodes) != len(codes)-1 {
+		t.Errorf("%d recovery codes left, want %d", len(user.RecoveryCodes), len(codes)-1)
+	}
+	if err := checkSecondFactor(user, mfaParams{RecoveryCode: codes[3]}); !errors.Is(err, errInvalidMFACode) {
+		t.Errorf("reused recovery code got %v, want %v", err, errInvalidMFACode)
+	}
+
+	// The others still work
+	if err := checkSecondFactor(user, mfaParams{RecoveryCode: codes[4]}); err != nil {
+		t.Errorf("rejected another recovery code: %v", err)
+	}
+}
+
+func TestCheckSecondFactorRequiresTOTP(t *testing.T) {
+	user, codes := newTOTPUser(t)
+	user.TOTPEnabled = false
+	if err := checkSecondFactor(user, mfaParams{RecoveryCode: codes[0]}); !errors.Is(err, errTOTPNotEnabled) {
+		t.Errorf("got %v, want %v", err, errTOTPNotEnabled)
+	}
+
+	user.TOTPEnabled = true
+	if err := checkSecondFactor(user, mfaParams{}); !errors.Is(err, errInvalidMFACode) {
+		t.Errorf("no code got %v, want %v", err, errInvalidMFACode)
+	}
+}
@@ -0,0 +1,354 @@
+package config
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/oidc"
+	"github.com/RichardHoa/go-server/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+const (
+	// oidcStateLifetime is how long a user has to log in at the provider
+	oidcStateLifetime = 10 * time.Minute
+
+	// oidcStateCookie carries the login from the redirect to the callback
+	oidcStateCookie = "chirpy_oidc_state"
+)
+
+// What a provider login started by a logged-in user is for
+const (
+	oidcPurposeLink   = "link"   // Link the provider account, see HandlerOIDCLink
+	oidcPurposeReauth = "reauth" // Prove it is still the user, see HandlerOIDCReauth
+)
+
+// oidcStateClaims tie the callback to the browser that started the login.
+// The subject is the user who started it with a purpose, or 0 for a login.
+type oidcStateClaims struct {
+	tokenClaims
+	Purpose  string `json:"purpose,omitempty"` // oidcPurposeLink or oidcPurposeReauth, empty for a login
+	Provider string `json:"provider"`
+	State    string `json:"state"`
+	Nonce    string `json:"nonce"`
+	Verifier string `json:"verifier"`
+}
+
+// oidcProvider finds the provider named in the path, writing a 404 if there
+// is none
+func (cfg *ApiConfig) oidcProvider(w http.ResponseWriter, r *http.Request) (*oidc.Provider, bool) {
+	provider, ok := cfg.OIDCProviders[r.PathValue("provider")]
+	if !ok {
+		http.Error(w, `{"error": "Unknown login provider"}`, http.StatusNotFound)
+		return nil, false
+	}
+	return provider, true
+}
+
+// startOIDC sets the state cookie and returns the provider URL to send the
+// user to. userID is the logged-in user who started it for purpose, or 0 for
+// a login.
+func (cfg *ApiConfig) startOIDC(w http.ResponseWriter, r *http.Request, provider *oidc.Provider, userID int, purpose string) (string, error) {
+	state, err := oidc.NewState()
+	if err != nil {
+		return "", err
+	}
+	nonce, err := oidc.NewState()
+	if err != nil {
+		return "", err
+	}
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		return "", err
+	}
+
+	authURL, err := provider.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		return "", err
+	}
+
+	claims, err := newTokenClaims(tokenTypeOIDC, userID, 0, oidcStateLifetime)
+	if err != nil {
+		return "", err
+	}
+	stateToken, err := cfg.signToken(oidcStateClaims{
+		tokenClaims: claims,
+		Purpose:     purpose,
+		Provider:    provider.Name,
+		State:       state,
+		Nonce:       nonce,
+		Verifier:    verifier,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	// Lax is needed for the cookie to come back with the provider's redirect
+	http.SetCookie(w, &http.Cookie{
+		Name:     oidcStateCookie,
+		Value:    stateToken,
+		Path:     "/api/oidc/" + provider.Name,
+		MaxAge:   int(oidcStateLifetime.Seconds()),
+		HttpOnly: true,
+		Secure:   strings.HasPrefix(cfg.PublicURL, "https://"),
+		SameSite: http.SameSiteLaxMode,
+	})
+	return authURL, nil
+}
+
+// HandlerOIDCLogin sends the user to the provider to log in
+func (cfg *ApiConfig) HandlerOIDCLogin(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	authURL, err := cfg.startOIDC(w, r, provider, 0, "")
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to start login: %v"}`, err), http.StatusBadGateway)
+		return
+	}
+	http.Redirect(w, r, authURL, http.StatusFound)
+}
+
+// HandlerOIDCLink starts linking the provider to the caller's account. The
+// client sends the user to authorization_url, and the callback links the
+// provider account they log in with.
+func (cfg *ApiConfig) HandlerOIDCLink(w http.ResponseWriter, r *http.Request) {
+	cfg.startUserOIDC(w, r, oidcPurposeLink)
+}
+
+// HandlerOIDCReauth starts a fresh login with a provider linked to the
+// caller's account. The callback answers with a reauth_token that stands in
+// for the password in DELETE /api/users, for users who never set one.
+func (cfg *ApiConfig) HandlerOIDCReauth(w http.ResponseWriter, r *http.Request) {
+	cfg.startUserOIDC(w, r, oidcPurposeReauth)
+}
+
+// startUserOIDC starts a provider login for the caller and responds with the
+// URL to send them to
+func (cfg *ApiConfig) startUserOIDC(w http.ResponseWriter, r *http.Request, purpose string) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	authURL, err := cfg.startOIDC(w, r, provider, userID, purpose)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to start login: %v"}`, err), http.StatusBadGateway)
+		return
+	}
+
+	response := map[string]interface{}{
+		"authorization_url": authURL,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandlerOIDCCallback is where the provider sends the user back to. It logs
+// in the user linked to the provider account, creating one on first login.
+// Logins started by HandlerOIDCLink link the account instead, and those
+// started by HandlerOIDCReauth hand out a reauth token.
+func (cfg *ApiConfig) HandlerOIDCCallback(w http.ResponseWriter, r *http.Request) {
+	provider, ok := cfg.oidcProvider(w, r)
+	if !ok {
+		return
+	}
+
+	// The state is only good for one try, whatever the outcome
+	cookie, err := r.Cookie(oidcStateCookie)
+	http.SetCookie(w, &http.Cookie{Name: oidcStateCookie, Path: "/api/oidc/" + provider.Name, MaxAge: -1})
+	if err != nil {
+		http.Error(w, `{"error": "Login expired, start again"}`, http.StatusBadRequest)
+		return
+	}
+	state := &oidcStateClaims{}
+	if err := cfg.parseClaims(cookie.Value, tokenTypeOIDC, state); err != nil || state.Provider != provider.Name {
+		http.Error(w, `{"error": "Login expired, start again"}`, http.StatusBadRequest)
+		return
+	}
+
+	// A state that does not match means the callback was not started by
+	// this browser (CSRF)
+	query := r.URL.Query()
+	if subtle.ConstantTimeCompare([]byte(query.Get("state")), []byte(state.State)) != 1 {
+		http.Error(w, `{"error": "Login state does not match, start again"}`, http.StatusBadRequest)
+		return
+	}
+	if providerErr := query.Get("error"); providerErr != "" {
+		http.Error(w, `{"error": "The login provider refused the login"}`, http.StatusUnauthorized)
+		return
+	}
+	if query.Get("code") == "" {
+		http.Error(w, `{"error": "code is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	claims, err := provider.Exchange(r.Context(), query.Get("code"), state.Verifier, state.Nonce)
+	if errors.Is(err, oidc.ErrInvalidIDToken) {
+		log.Printf("Rejected ID token from %s: %v", provider.Name, err)
+		http.Error(w, `{"error": "The login provider sent an invalid ID token"}`, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to complete login: %v"}`, err), http.StatusBadGateway)
+		return
+	}
+
+	identity := handlers.Identity{Provider: provider.Name, Subject: claims.Subject, Email: claims.Email}
+
+	if userID, _ := strconv.Atoi(state.Subject); userID != 0 {
+		if state.Purpose == oidcPurposeReauth {
+			cfg.reauthenticate(w, identity, userID)
+			return
+		}
+		cfg.linkIdentity(w, identity, userID)
+		return
+	}
+
+	user, err := cfg.oidcUser(identity, claims)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		// Taking over an existing account by email would let anyone who can
+		// register that address at the provider into it
+		http.Error(w, `{"error": "An account with this email already exists. Log in and link the provider from your account."}`, http.StatusConflict)
+		return
+	}
+	if errors.Is(err, errNoEmail) {
+		http.Error(w, `{"error": "The login provider did not share an email address"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Two-factor authentication applies whichever way the user logs in
+	if user.TOTPEnabled {
+		cfg.writeMFAChallenge(w, user)
+		return
+	}
+	cfg.writeLogin(w, r, user, 0)
+}
+
+// errNoEmail is returned when a new user logs in with a provider that does
+// not share their email address
+var errNoEmail = errors.New("no email address from provider")
+
+// oidcUser returns the user linked to the identity, creating one on first
+// login. New users cannot log in with a password until they reset it.
+func (cfg *ApiConfig) oidcUser(identity handlers.Identity, claims oidc.Claims) (handlers.User, error) {
+	linked, err := cfg.Store.GetIdentity(identity.Provider, identity.Subject)
+	if err == nil {
+		return cfg.Store.GetUser(linked.UserID)
+	}
+	if !errors.Is(err, storage.ErrNotFound) {
+		return handlers.User{}, err
+	}
+
+	if claims.Email == "" {
+		return handlers.User{}, errNoEmail
+	}
+
+	// No password hashes to this, so password logins fail until a reset
+	randomPassword, err := oidc.NewVerifier()
+	if err != nil {
+		return handlers.User{}, err
+	}
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(randomPassword), bcrypt.DefaultCost)
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not hash password: %v", err)
+	}
+
+	user := handlers.User{
+		Email:         claims.Email,
+		Password:      string(hashedPassword),
+		EmailVerified: claims.EmailVerified,
+	}
+	user, err = cfg.Store.CreateUserWithIdentity(user, identity)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	if !user.EmailVerified {
+		cfg.sendEmailVerificationLogged(user)
+	}
+	return user, nil
+}
+
+// linkIdentity links the identity to the user who started HandlerOIDCLink
+func (cfg *ApiConfig) linkIdentity(w http.ResponseWriter, identity handlers.Identity, userID int) {
+	identity.UserID = userID
+	err := cfg.Store.LinkIdentity(identity)
+	if errors.Is(err, storage.ErrAlreadyExists) {
+		// Linking the same account twice is not an error
+		linked, getErr := cfg.Store.GetIdentity(identity.Provider, identity.Subject)
+		if getErr != nil || linked.UserID != userID {
+			http.Error(w, `{"error": "This provider account is already linked to another user"}`, http.StatusConflict)
+			return
+		}
+		err = nil
+	}
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"provider": identity.Provider,
+		"user_id":  userID,
+		"linked":   true,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// reauthenticate answers a fresh provider login by the user who started
+// HandlerOIDCReauth with a short-lived reauth token
+func (cfg *ApiConfig) reauthenticate(w http.ResponseWriter, identity handlers.Identity, userID int) {
+	// Only a provider account already linked to the user proves anything
+	linked, err := cfg.Store.GetIdentity(identity.Provider, identity.Subject)
+	if errors.Is(err, storage.ErrNotFound) || (err == nil && linked.UserID != userID) {
+		http.Error(w, `{"error": "This provider account is not linked to your account"}`, http.StatusForbidden)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	reauthToken, err := cfg.issueToken(tokenTypeReauth, userID, 0, reauthTokenLifetime)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to sign token"}`, http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"provider":     identity.Provider,
+		"user_id":      userID,
+		"reauth_token": reauthToken,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
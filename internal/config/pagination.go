@@ -0,0 +1,105 @@
+package config
+
+import (
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+const (
+	defaultChirpsPageSize = 50  // Page size when the client does not pass limit
+	maxChirpsPageSize     = 100 // Largest page the server will return
+)
+
+// pageCursor is where the previous page ended: the last chirp's position
+// for chronological listings, or the number of results already returned for
+// relevance-ranked searches
+type pageCursor struct {
+	After  *storage.ChirpPosition
+	Offset int
+}
+
+// encodeCursor turns the page position into an opaque cursor so clients
+// don't come to depend on its format
+func encodeCursor(cursor pageCursor) string {
+	raw := "offset:" + strconv.Itoa(cursor.Offset)
+	if cursor.After != nil {
+		raw = fmt.Sprintf("at:%d:%d", cursor.After.CreatedAt.UnixMicro(), cursor.After.ID)
+	}
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// decodeCursor is the inverse of encodeCursor
+func decodeCursor(encoded string) (pageCursor, error) {
+	invalid := fmt.Errorf("invalid cursor")
+
+	raw, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return pageCursor{}, invalid
+	}
+
+	parts := strings.Split(string(raw), ":")
+	switch {
+	case len(parts) == 3 && parts[0] == "at":
+		micros, err := strconv.ParseInt(parts[1], 10, 64)
+		if err != nil {
+			return pageCursor{}, invalid
+		}
+		id, err := strconv.Atoi(parts[2])
+		if err != nil || id < 1 {
+			return pageCursor{}, invalid
+		}
+		return pageCursor{After: &storage.ChirpPosition{CreatedAt: time.UnixMicro(micros).UTC(), ID: id}}, nil
+	case len(parts) == 2 && parts[0] == "offset":
+		offset, err := strconv.Atoi(parts[1])
+		if err != nil || offset < 1 {
+			return pageCursor{}, invalid
+		}
+		return pageCursor{Offset: offset}, nil
+	default:
+		return pageCursor{}, invalid
+	}
+}
+
+// parseTimeParam reads an optional RFC 3339 timestamp from the query string
+func parseTimeParam(r *http.Request, name string) (time.Time, error) {
+	value := r.URL.Query().Get(name)
+	if value == "" {
+		return time.Time{}, nil
+	}
+	parsed, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid %s", name)
+	}
+	return parsed.UTC(), nil
+}
+
+// parsePageSize reads the limit query parameter, applying the default and
+// capping it at the maximum page size
+func parsePageSize(r *http.Request) (int, error) {
+	limitStr := r.URL.Query().Get("limit")
+	if limitStr == "" {
+		return defaultChirpsPageSize, nil
+	}
+
+	limit, err := strconv.Atoi(limitStr)
+	if err != nil || limit < 1 {
+		return 0, fmt.Errorf("invalid limit")
+	}
+	return min(limit, maxChirpsPageSize), nil
+}
+
+// setNextLink adds a Link header (RFC 8288) pointing at the next page, keeping
+// every other query parameter of the current request
+func setNextLink(w http.ResponseWriter, r *http.Request, cursor string) {
+	query := r.URL.Query()
+	query.Set("cursor", cursor)
+	next := url.URL{Path: r.URL.Path, RawQuery: query.Encode()}
+	w.Header().Set("Link", fmt.Sprintf(`<%s>; rel="next"`, next.String()))
+}
@@ -0,0 +1,147 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/mail"
+	"github.com/RichardHoa/go-server/internal/storage"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// passwordResetLifetime is how long a reset token can be redeemed
+const passwordResetLifetime = time.Hour
+
+// mailer returns the configured mailer, falling back to the log
+func (cfg *ApiConfig) mailer() mail.Mailer {
+	if cfg.Mailer == nil {
+		return mail.LogMailer{}
+	}
+	return cfg.Mailer
+}
+
+// HandlerRequestPasswordReset emails a reset token to the account's address.
+// The response is the same whether or not the account exists, so it cannot
+// be used to find out who has one.
+func (cfg *ApiConfig) HandlerRequestPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Email string `json:"email"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if request.Email == "" {
+		http.Error(w, `{"error": "email is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := cfg.Store.GetUserByEmail(request.Email)
+	if err != nil && !errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if err == nil {
+		if err := cfg.sendPasswordReset(user); err != nil {
+			// Failing here would tell the caller the account exists
+			log.Printf("Could not send password reset to user %d: %v", user.GetID(), err)
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusAccepted)
+	json.NewEncoder(w).Encode(map[string]string{
+		"message": "If an account uses this email, a password reset token has been sent to it",
+	})
+}
+
+// sendPasswordReset stores a new reset token for the user and emails it
+func (cfg *ApiConfig) sendPasswordReset(user handlers.User) error {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Store.CreateOneTimeToken(handlers.OneTimeToken{
+		Hash:      hash,
+		Purpose:   handlers.TokenPurposePasswordReset,
+		UserID:    user.GetID(),
+		ExpiresAt: time.Now().UTC().Add(passwordResetLifetime).Truncate(time.Microsecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	return cfg.mailer().Send(mail.Message{
+		To:      user.Email,
+		Subject: "Reset your Chirpy password",
+		Body: fmt.Sprintf("Somebody asked to reset the password of your Chirpy account.\n\n"+
+			"Your reset token is:\n\n%s\n\n"+
+			"Send it with your new password to POST /api/password-reset/confirm within %s. "+
+			"If you did not ask for this, you can ignore this email.\n", token, passwordResetLifetime),
+	})
+}
+
+// HandlerConfirmPasswordReset sets a new password with a reset token. Like a
+// password change it logs out every session and access token.
+func (cfg *ApiConfig) HandlerConfirmPasswordReset(w http.ResponseWriter, r *http.Request) {
+	var request struct {
+		Token    string `json:"token"`
+		Password string `json:"password"`
+	}
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if request.Token == "" || request.Password == "" {
+		http.Error(w, `{"error": "token and password are required"}`, http.StatusBadRequest)
+		return
+	}
+
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(request.Password), bcrypt.DefaultCost)
+	if err != nil {
+		http.Error(w, `{"error": "Failed to hash password"}`, http.StatusInternalServerError)
+		return
+	}
+
+	user, err := cfg.Store.RedeemOneTimeToken(hashToken(request.Token), handlers.TokenPurposePasswordReset, func(user *handlers.User) error {
+		// Failing the policy keeps the token, so the user can try another password
+		passwordErrs, err := cfg.checkPassword(request.Password, user.Email)
+		if err != nil {
+			return err
+		}
+		if len(passwordErrs) > 0 {
+			return &passwordPolicyError{errs: passwordErrs}
+		}
+
+		user.Password = string(hashedPassword)
+		user.TokensValidAfter = tokensValidAfterNow()
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrTokenReused) || errors.Is(err, storage.ErrTokenExpired) {
+		http.Error(w, `{"error": "Invalid or expired reset token"}`, http.StatusBadRequest)
+		return
+	}
+	var policyErr *passwordPolicyError
+	if errors.As(err, &policyErr) {
+		writePasswordErrors(w, policyErr.errs)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Whoever had the old password must not stay logged in with it
+	if _, err := cfg.Store.RevokeAllSessions(user.GetID(), 0); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
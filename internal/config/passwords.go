@@ -0,0 +1,50 @@
+package config
+
+import (
+	"net/http"
+
+	"github.com/RichardHoa/go-server/internal/password"
+)
+
+// passwordPolicyError aborts a store update whose new password failed the policy
+type passwordPolicyError struct {
+	errs []fieldError
+}
+
+func (e *passwordPolicyError) Error() string {
+	return "password does not meet the policy"
+}
+
+// checkPassword returns every password rule the password breaks for a user
+// with the given email, including having appeared in a data breach
+func (cfg *ApiConfig) checkPassword(newPassword, email string) ([]fieldError, error) {
+	policy := cfg.PasswordPolicy
+	if policy == (password.Policy{}) {
+		policy = password.DefaultPolicy
+	}
+
+	var errs []fieldError
+	for _, violation := range policy.Check(newPassword, email) {
+		errs = append(errs, fieldError{Field: "password", Code: violation.Rule, Message: violation.Message})
+	}
+
+	if cfg.Breaches != nil {
+		count, err := password.BreachCount(cfg.Breaches, newPassword)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			errs = append(errs, fieldError{
+				Field:   "password",
+				Code:    password.RuleBreached,
+				Message: "Password has appeared in a data breach, choose a different one",
+			})
+		}
+	}
+	return errs, nil
+}
+
+// writePasswordErrors responds with 422 and the list of failed password rules
+func writePasswordErrors(w http.ResponseWriter, errs []fieldError) {
+	writeFieldErrors(w, http.StatusUnprocessableEntity, "Password does not meet the password policy", errs)
+}
@@ -0,0 +1,105 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// errTokenRevoked is returned for a well-formed access token that has been
+// revoked before it expired
+var errTokenRevoked = errors.New("token revoked")
+
+// checkNotRevoked rejects access tokens that were revoked one by one (logout),
+// whose session was revoked (/api/revoke, the sessions API) or that were
+// issued before the user last changed their password
+func (cfg *ApiConfig) checkNotRevoked(claims *tokenClaims, userID int) error {
+	// Tokens without a jti predate revocation and could never be revoked
+	if claims.ID == "" || claims.IssuedAt == nil {
+		return errTokenRevoked
+	}
+
+	denied, err := cfg.Store.IsAccessTokenDenied(claims.ID)
+	if err != nil {
+		return err
+	}
+	if denied {
+		return errTokenRevoked
+	}
+
+	if claims.SessionID != 0 {
+		session, err := cfg.Store.GetSession(claims.SessionID)
+		if errors.Is(err, storage.ErrNotFound) {
+			return errTokenRevoked
+		}
+		if err != nil {
+			return err
+		}
+		if !session.RevokedAt.IsZero() || session.UserID != userID {
+			return errTokenRevoked
+		}
+	}
+
+	user, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		return errTokenRevoked
+	}
+	if err != nil {
+		return err
+	}
+	if issuedBeforeWatermark(claims.IssuedAt.Time, user) {
+		return errTokenRevoked
+	}
+	// A token from before a role change must not keep the old role; the
+	// client refreshes it for one with the new role
+	if claims.Role != "" && claims.Role != user.GetRole() {
+		return errTokenRevoked
+	}
+	return nil
+}
+
+// tokensValidAfterNow is the watermark that invalidates every access and
+// personal API token issued so far. It has the microsecond precision of the
+// stores, as does the iat of our tokens.
+func tokensValidAfterNow() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// issuedBeforeWatermark reports whether a token issued at issuedAt is revoked
+// by the user's watermark. A token from the same microsecond as the password
+// change counts as issued before it.
+func issuedBeforeWatermark(issuedAt time.Time, user handlers.User) bool {
+	return !issuedAt.After(user.TokensValidAfter)
+}
+
+// HandlerLogout revokes the access token the request was made with, along
+// with the session it belongs to
+func (cfg *ApiConfig) HandlerLogout(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	claims, hasClaims := r.Context().Value(claimsKey).(*tokenClaims)
+	if !ok || !hasClaims {
+		writeUnauthorized(w)
+		return
+	}
+
+	// Past its expiry the token is rejected anyway, so it only needs to be
+	// denied until then
+	if err := cfg.Store.DenyAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	if claims.SessionID != 0 {
+		err := cfg.Store.RevokeSession(userID, claims.SessionID)
+		if err != nil && !errors.Is(err, storage.ErrRevoked) && !errors.Is(err, storage.ErrNotFound) {
+			http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+			return
+		}
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
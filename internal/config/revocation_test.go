@@ -0,0 +1,194 @@
+package config
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// newStoreConfig returns a config with a JSON store holding one user
+func newStoreConfig(t *testing.T) (*ApiConfig, handlers.User) {
+	t.Helper()
+	cfg, _ := newRSAConfig(t)
+	store, err := storage.NewJSONStore(filepath.Join(t.TempDir(), "database.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { store.Close() })
+	cfg.Store = store
+
+	user, err := store.CreateUser(handlers.User{Email: "user@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cfg, user
+}
+
+// accessClaims issues an access token and parses it back, the way the auth
+// middleware sees it
+func accessClaims(t *testing.T, cfg *ApiConfig, user handlers.User, sessionID int) *tokenClaims {
+	t.Helper()
+	tokenString, err := cfg.issueAccessToken(user, sessionID, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := cfg.parseToken(tokenString, tokenTypeAccess)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return claims
+}
+
+func TestCheckNotRevokedDeniedToken(t *testing.T) {
+	cfg, user := newStoreConfig(t)
+	claims := accessClaims(t, cfg, user, 0)
+	other := accessClaims(t, cfg, user, 0)
+
+	if err := cfg.checkNotRevoked(claims, user.ID); err != nil {
+		t.Fatalf("rejected a fresh token: %v", err)
+	}
+	if err := cfg.Store.DenyAccessToken(claims.ID, claims.ExpiresAt.Time); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.checkNotRevoked(claims, user.ID); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("denied token got %v, want %v", err, errTokenRevoked)
+	}
+	if err := cfg.checkNotRevoked(other, user.ID); err != nil {
+		t.Errorf("denying one token rejected another: %v", err)
+	}
+
+	// Tokens without a jti could never be denied
+	claims.ID = ""
+	if err := cfg.checkNotRevoked(claims, user.ID); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("token without jti got %v, want %v", err, errTokenRevoked)
+	}
+}
+
+func TestCheckNotRevokedSession(t *testing.T) {
+	cfg, user := newStoreConfig(t)
+	_, refreshToken, err := newRefreshToken()
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := cfg.Store.CreateSession(handlers.Session{UserID: user.ID}, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := accessClaims(t, cfg, user, session.ID)
+	sessionless := accessClaims(t, cfg, user, 0)
+
+	if err := cfg.checkNotRevoked(claims, user.ID); err != nil {
+		t.Fatalf("rejected a token of an active session: %v", err)
+	}
+	// The session belongs to someone else
+	if err := cfg.checkNotRevoked(claims, user.ID+1); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("token for another user's session got %v, want %v", err, errTokenRevoked)
+	}
+
+	if err := cfg.Store.RevokeSession(user.ID, session.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.checkNotRevoked(claims, user.ID); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("token of a revoked session got %v, want %v", err, errTokenRevoked)
+	}
+	if err := cfg.checkNotRevoked(sessionless, user.ID); err != nil {
+		t.Errorf("revoking a session rejected a token without one: %v", err)
+	}
+
+	claims.SessionID = session.ID + 100
+	if err := cfg.checkNotRevoked(claims, user.ID); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("token of an unknown session got %v, want %v", err, errTokenRevoked)
+	}
+}
+
+func TestCheckNotRevokedWatermark(t *testing.T) {
+	cfg, user := newStoreConfig(t)
+	before := accessClaims(t, cfg, user, 0)
+
+	// The password changes within the same second as the token was issued
+	user, err := cfg.Store.UpdateUser(user.ID, func(user *handlers.User) error {
+		user.TokensValidAfter = tokensValidAfterNow()
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if before.IssuedAt.Unix() != user.TokensValidAfter.Unix() {
+		t.Skip("the second ticked over, nothing to test")
+	}
+	if err := cfg.checkNotRevoked(before, user.ID); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("token from before the password change got %v, want %v", err, errTokenRevoked)
+	}
+
+	// The token that comes with the new password still works
+	time.Sleep(time.Millisecond)
+	after := accessClaims(t, cfg, user, 0)
+	if err := cfg.checkNotRevoked(after, user.ID); err != nil {
+		t.Errorf("token from after the password change got %v", err)
+	}
+}
+
+func TestIssuedBeforeWatermark(t *testing.T) {
+	watermark := time.Date(2025, 1, 1, 12, 0, 0, 500000000, time.UTC)
+	user := handlers.User{TokensValidAfter: watermark}
+
+	tests := []struct {
+		issuedAt time.Time
+		want     bool
+	}{
+		{watermark.Add(-time.Hour), true},
+		{watermark.Add(-time.Millisecond), true},
+		{watermark, true},
+		{watermark.Add(time.Microsecond), false},
+		{watermark.Add(time.Hour), false},
+	}
+	for _, tt := range tests {
+		if got := issuedBeforeWatermark(tt.issuedAt, user); got != tt.want {
+			t.Errorf("issuedBeforeWatermark(%s) = %v, want %v", tt.issuedAt.Format(time.RFC3339Nano), got, tt.want)
+		}
+	}
+
+	// Users who never changed their password revoke nothing
+	if issuedBeforeWatermark(watermark, handlers.User{}) {
+		t.Error("the zero watermark revoked a token")
+	}
+}
+
+func TestCheckNotRevokedRole(t *testing.T) {
+	cfg, user := newStoreConfig(t)
+	user, err := cfg.Store.UpdateUser(user.ID, func(user *handlers.User) error {
+		user.Role = handlers.RoleModerator
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims := accessClaims(t, cfg, user, 0)
+	if claims.Role != handlers.RoleModerator {
+		t.Fatalf("token has role %q, want %q", claims.Role, handlers.RoleModerator)
+	}
+	if err := cfg.checkNotRevoked(claims, user.ID); err != nil {
+		t.Fatalf("rejected a token with the current role: %v", err)
+	}
+
+	// Demoted: the token must not keep the moderator role
+	if _, err := cfg.Store.UpdateUser(user.ID, func(user *handlers.User) error {
+		user.Role = handlers.RoleUser
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := cfg.checkNotRevoked(claims, user.ID); !errors.Is(err, errTokenRevoked) {
+		t.Errorf("token with the old role got %v, want %v", err, errTokenRevoked)
+	}
+
+	// Tokens from before roles existed have no role claim
+	claims.Role = ""
+	if err := cfg.checkNotRevoked(claims, user.ID); err != nil {
+		t.Errorf("rejected a token without a role claim: %v", err)
+	}
+}
@@ -0,0 +1,100 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// MiddlewareRole is MiddlewareAuth for routes that need the role, or a more
+// privileged one. Personal API tokens are never enough.
+func (cfg *ApiConfig) MiddlewareRole(role string, next http.Handler) http.Handler {
+	return cfg.MiddlewareAuth(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !handlers.RoleAtLeast(RoleFromContext(r.Context()), role) {
+			http.Error(w, fmt.Sprintf(`{"error": "Forbidden: requires the %s role"}`, role), http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(w, r)
+	}))
+}
+
+// HandlerSetUserRole lets an admin change another user's role. Access tokens
+// the user already has stop working, so their next refresh picks up the role.
+func (cfg *ApiConfig) HandlerSetUserRole(w http.ResponseWriter, r *http.Request) {
+	adminID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	userID, err := strconv.Atoi(r.PathValue("userID"))
+	if err != nil {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	// Admins cannot demote themselves and leave nobody to undo it
+	if userID == adminID {
+		http.Error(w, `{"error": "You cannot change your own role"}`, http.StatusBadRequest)
+		return
+	}
+
+	type roleParams struct {
+		Role string `json:"role"`
+	}
+
+	var params roleParams
+	if err := json.NewDecoder(r.Body).Decode(&params); err != nil {
+		http.Error(w, `{"error": "Invalid JSON"}`, http.StatusBadRequest)
+		return
+	}
+	if !handlers.ValidRole(params.Role) {
+		http.Error(w, fmt.Sprintf(`{"error": "role must be %s, %s or %s"}`, handlers.RoleUser, handlers.RoleModerator, handlers.RoleAdmin), http.StatusBadRequest)
+		return
+	}
+
+	var previousRole string
+	user, err := cfg.Store.UpdateUser(userID, func(user *handlers.User) error {
+		previousRole = user.GetRole()
+		user.Role = params.Role
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if previousRole != user.GetRole() {
+		log.Printf("User %d changed the role of user %d from %s to %s", adminID, userID, previousRole, user.GetRole())
+	}
+
+	response := map[string]interface{}{
+		"id":    user.GetID(),
+		"email": user.GetUniqueIdentifier(),
+		"role":  user.GetRole(),
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// MakeAdmin gives the user with the email the admin role. It bootstraps the
+// first admin, who can then hand out roles with HandlerSetUserRole.
+func MakeAdmin(store storage.Store, email string) (handlers.User, error) {
+	user, err := store.GetUserByEmail(email)
+	if err != nil {
+		return handlers.User{}, err
+	}
+	return store.UpdateUser(user.GetID(), func(user *handlers.User) error {
+		user.Role = handlers.RoleAdmin
+		return nil
+	})
+}
@@ -0,0 +1,33 @@
+package config
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+func TestMakeAdmin(t *testing.T) {
+	cfg, user := newStoreConfig(t)
+
+	admin, err := MakeAdmin(cfg.Store, user.Email)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if admin.ID != user.ID || admin.GetRole() != handlers.RoleAdmin {
+		t.Errorf("got user %d with role %q, want user %d with role %s", admin.ID, admin.GetRole(), user.ID, handlers.RoleAdmin)
+	}
+	stored, err := cfg.Store.GetUser(user.ID)
+	if err != nil || stored.GetRole() != handlers.RoleAdmin {
+		t.Errorf("stored role %q (%v), want %s", stored.GetRole(), err, handlers.RoleAdmin)
+	}
+
+	// Running it again is harmless
+	if _, err := MakeAdmin(cfg.Store, user.Email); err != nil {
+		t.Errorf("could not make an admin an admin again: %v", err)
+	}
+	if _, err := MakeAdmin(cfg.Store, "nobody@example.com"); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("unknown email got %v, want %v", err, storage.ErrNotFound)
+	}
+}
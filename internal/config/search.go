@@ -0,0 +1,55 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// BuildSearchIndex indexes every stored chirp. It is called once at startup;
+// afterwards the chirp handlers keep the index up to date.
+func (cfg *ApiConfig) BuildSearchIndex() error {
+	chirps, err := cfg.Store.ListChirps(storage.ChirpQuery{})
+	if err != nil {
+		return fmt.Errorf("could not load chirps: %v", err)
+	}
+
+	for _, chirp := range chirps {
+		cfg.Search.Add(chirp.GetID(), chirp.Body)
+	}
+	return nil
+}
+
+// searchChirps returns one page of chirps matching q and the query's
+// filters, best match first, skipping the first offset matches. hasMore
+// reports whether another page exists.
+func (cfg *ApiConfig) searchChirps(q string, filter storage.ChirpQuery, offset int, pageSize int) (chirps []handlers.Chirp, hasMore bool, err error) {
+	chirps = []handlers.Chirp{}
+
+	skipped := 0
+	for _, result := range cfg.Search.Search(q) {
+		chirp, err := cfg.Store.GetChirp(result.ID)
+		if errors.Is(err, storage.ErrNotFound) {
+			// Deleted between the search and now
+			continue
+		}
+		if err != nil {
+			return nil, false, err
+		}
+		if !filter.Matches(chirp) {
+			continue
+		}
+
+		if skipped < offset {
+			skipped++
+			continue
+		}
+		if len(chirps) == pageSize {
+			return chirps, true, nil
+		}
+		chirps = append(chirps, chirp)
+	}
+	return chirps, false, nil
+}
@@ -0,0 +1,222 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/netip"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// refreshTokenLifetime is how long a refresh token stays valid. Every refresh
+// hands out a new token, so an active session never expires.
+const refreshTokenLifetime = 60 * 24 * time.Hour
+
+// newOpaqueToken returns a random token and the hash to store for it
+func newOpaqueToken() (token string, hash string, err error) {
+	tokenBytes := make([]byte, 32)
+	if _, err := rand.Read(tokenBytes); err != nil {
+		return "", "", fmt.Errorf("could not generate token: %v", err)
+	}
+	token = hex.EncodeToString(tokenBytes)
+	return token, hashToken(token), nil
+}
+
+// hashToken is how opaque tokens are stored. The tokens are 256 random bits,
+// so a fast hash is enough: there is nothing to brute-force.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// newRefreshToken returns a new refresh token and its stored form
+func newRefreshToken() (string, handlers.RefreshToken, error) {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return "", handlers.RefreshToken{}, err
+	}
+	return token, handlers.RefreshToken{Hash: hash, ExpiresAt: time.Now().UTC().Add(refreshTokenLifetime).Truncate(time.Microsecond)}, nil
+}
+
+// clientIP is the address the request came from. A request from one of the
+// TrustedProxies is from the right-most X-Forwarded-For address that is not a
+// trusted proxy: everything to the left of it was sent by the client and
+// could be made up.
+func (cfg *ApiConfig) clientIP(r *http.Request) string {
+	ip, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		ip = r.RemoteAddr
+	}
+	if !cfg.trustedProxy(ip) {
+		return ip
+	}
+
+	var hops []string
+	for _, header := range r.Header.Values("X-Forwarded-For") {
+		hops = append(hops, strings.Split(header, ",")...)
+	}
+	for i := len(hops) - 1; i >= 0; i-- {
+		addr, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			// Not written by a proxy, so stop at the last hop we trust
+			break
+		}
+		ip = addr.Unmap().String()
+		if !cfg.trustedProxy(ip) {
+			break
+		}
+	}
+	return ip
+}
+
+// ParseTrustedProxies parses a comma-separated list of CIDR ranges and
+// single addresses, such as "10.0.0.0/8, 192.0.2.1"
+func ParseTrustedProxies(value string) ([]netip.Prefix, error) {
+	var prefixes []netip.Prefix
+	for _, field := range strings.Split(value, ",") {
+		field = strings.TrimSpace(field)
+		if field == "" {
+			continue
+		}
+		if addr, err := netip.ParseAddr(field); err == nil {
+			addr = addr.Unmap()
+			prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+			continue
+		}
+		prefix, err := netip.ParsePrefix(field)
+		if err != nil {
+			return nil, fmt.Errorf("invalid trusted proxy %q: %v", field, err)
+		}
+		prefixes = append(prefixes, prefix.Masked())
+	}
+	return prefixes, nil
+}
+
+// trustedProxy reports whether ip is in TrustedProxies
+func (cfg *ApiConfig) trustedProxy(ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range cfg.TrustedProxies {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// sessionResponse is how a session is shown to its owner
+type sessionResponse struct {
+	ID         int       `json:"id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"`
+	Current    bool      `json:"current"` // The session the request was made from
+}
+
+// HandlerGetSessions lists the caller's active logins
+func (cfg *ApiConfig) HandlerGetSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+	currentID := SessionIDFromContext(r.Context())
+
+	sessions, err := cfg.Store.ListSessions(userID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read sessions: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	// Revoked and expired sessions are history, not logins
+	now := time.Now().UTC()
+	response := []sessionResponse{}
+	for _, session := range sessions {
+		if !session.Active(now) {
+			continue
+		}
+		response = append(response, sessionResponse{
+			ID:         session.ID,
+			UserAgent:  session.UserAgent,
+			IP:         session.IP,
+			CreatedAt:  session.CreatedAt,
+			LastUsedAt: session.LastUsedAt,
+			ExpiresAt:  session.ExpiresAt,
+			Current:    session.ID == currentID,
+		})
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// HandlerDeleteSession logs one of the caller's sessions out
+func (cfg *ApiConfig) HandlerDeleteSession(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	sessionID, err := strconv.Atoi(r.PathValue("sessionID"))
+	if err != nil {
+		http.Error(w, `{"error": "Session not found"}`, http.StatusNotFound)
+		return
+	}
+
+	// Other users' sessions look the same as missing ones
+	err = cfg.Store.RevokeSession(userID, sessionID)
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrRevoked) {
+		http.Error(w, `{"error": "Session not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// HandlerRevokeAllSessions logs the caller out everywhere else. With
+// ?include_current=true the session the request came from is ended too.
+func (cfg *ApiConfig) HandlerRevokeAllSessions(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	keepID := SessionIDFromContext(r.Context())
+	if r.URL.Query().Get("include_current") == "true" {
+		keepID = 0
+	}
+
+	revoked, err := cfg.Store.RevokeAllSessions(userID, keepID)
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(map[string]int{"revoked": revoked}); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,59 @@
+package config
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestClientIP(t *testing.T) {
+	proxies, err := ParseTrustedProxies("10.0.0.0/8, 192.0.2.1, 2001:db8::/32")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name       string
+		proxies    bool
+		remoteAddr string
+		forwarded  []string
+		want       string
+	}{
+		{"no proxies", false, "203.0.113.7:4321", nil, "203.0.113.7"},
+		{"header ignored without proxies", false, "203.0.113.7:4321", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"header ignored from an untrusted address", true, "203.0.113.7:4321", []string{"198.51.100.1"}, "203.0.113.7"},
+		{"one proxy", true, "10.1.2.3:4321", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"chain of proxies", true, "10.1.2.3:4321", []string{"198.51.100.1, 192.0.2.1, 10.9.9.9"}, "198.51.100.1"},
+		{"spoofed hops on the left", true, "10.1.2.3:4321", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"several headers", true, "10.1.2.3:4321", []string{"1.1.1.1", "198.51.100.1, 10.9.9.9"}, "198.51.100.1"},
+		{"only proxies", true, "10.1.2.3:4321", []string{"10.4.4.4"}, "10.4.4.4"},
+		{"no header", true, "10.1.2.3:4321", nil, "10.1.2.3"},
+		{"garbage hop", true, "10.1.2.3:4321", []string{"198.51.100.1, not-an-ip, 10.9.9.9"}, "10.9.9.9"},
+		{"IPv6 proxy", true, "[2001:db8::1]:4321", []string{"2001:db8:ffff::1, 2600::1"}, "2600::1"},
+		{"IPv4-mapped proxy", true, "[::ffff:10.1.2.3]:4321", []string{"198.51.100.1"}, "198.51.100.1"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &ApiConfig{}
+			if tt.proxies {
+				cfg.TrustedProxies = proxies
+			}
+			r := httptest.NewRequest("GET", "/", nil)
+			r.RemoteAddr = tt.remoteAddr
+			for _, value := range tt.forwarded {
+				r.Header.Add("X-Forwarded-For", value)
+			}
+			if got := cfg.clientIP(r); got != tt.want {
+				t.Errorf("clientIP = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseTrustedProxiesRejectsGarbage(t *testing.T) {
+	for _, value := range []string{"10.0.0.0/33", "proxy.example", "10.0.0.0/8,nope"} {
+		if _, err := ParseTrustedProxies(value); err == nil {
+			t.Errorf("ParseTrustedProxies(%q) accepted", value)
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package config
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/signing"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Every JWT we issue is for us: issued by chirpy, for the chirpy API
+const (
+	tokenIssuer   = "chirpy"
+	tokenAudience = "chirpy-api"
+
+	// tokenLeeway absorbs small clock differences between servers
+	tokenLeeway = 30 * time.Second
+)
+
+func init() {
+	// iat is compared with TokensValidAfter, so it needs the same microsecond
+	// precision: a token issued just before a password change must not pass
+	// for one issued in the same second after it
+	jwt.TimePrecision = time.Microsecond
+}
+
+// Token types, so a token minted for one purpose cannot be used for another
+const (
+	tokenTypeAccess = "access"
+	tokenTypeMFA    = "mfa"        // Proves the password step of a two-factor login
+	tokenTypeOIDC   = "oidc_state" // Carries an OpenID Connect login from redirect to callback
+	tokenTypeReauth = "reauth"     // Proves a fresh provider login, for users without a password
+)
+
+// tokenClaims are the claims of every JWT we issue
+type tokenClaims struct {
+	jwt.RegisteredClaims
+	TokenType string `json:"token_type"`
+	SessionID int    `json:"sid,omitempty"`  // The login session the token belongs to
+	Role      string `json:"role,omitempty"` // The user's role when an access token was issued
+}
+
+// typedClaims are claims with our token_type, so parseClaims can check it
+type typedClaims interface {
+	jwt.Claims
+	tokenType() string
+}
+
+func (claims *tokenClaims) tokenType() string {
+	return claims.TokenType
+}
+
+// errWrongTokenType is returned when a valid token is used for the wrong purpose
+var errWrongTokenType = errors.New("wrong token type")
+
+// keySet returns the keys tokens are signed with, falling back to the
+// HS256 JWTSecret when no key files are configured
+func (cfg *ApiConfig) keySet() *signing.KeySet {
+	cfg.Mu.Lock()
+	defer cfg.Mu.Unlock()
+
+	if cfg.SigningKeys == nil {
+		cfg.SigningKeys = signing.NewHMAC([]byte(cfg.JWTSecret))
+	}
+	return cfg.SigningKeys
+}
+
+// HandlerJWKS serves the public signing keys so other services can verify
+// our tokens without sharing a secret
+func (cfg *ApiConfig) HandlerJWKS(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "public, max-age=300")
+	if err := json.NewEncoder(w).Encode(cfg.keySet().JWKS()); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
+
+// issueToken signs a token of the given type for the user. sessionID is 0 for
+// tokens that do not belong to a login session.
+func (cfg *ApiConfig) issueToken(tokenType string, userID, sessionID int, expiresIn time.Duration) (string, error) {
+	claims, err := newTokenClaims(tokenType, userID, sessionID, expiresIn)
+	if err != nil {
+		return "", err
+	}
+	return cfg.signToken(claims)
+}
+
+// issueAccessToken signs an access token carrying the user's role
+func (cfg *ApiConfig) issueAccessToken(user handlers.User, sessionID int, expiresIn time.Duration) (string, error) {
+	claims, err := newTokenClaims(tokenTypeAccess, user.GetID(), sessionID, expiresIn)
+	if err != nil {
+		return "", err
+	}
+	claims.Role = user.GetRole()
+	return cfg.signToken(claims)
+}
+
+// newTokenClaims fills in the claims every token we issue has
+func newTokenClaims(tokenType string, userID, sessionID int, expiresIn time.Duration) (tokenClaims, error) {
+	// The jti lets a single token be revoked before it expires
+	tokenID, err := newTokenID()
+	if err != nil {
+		return tokenClaims{}, err
+	}
+
+	now := time.Now().UTC()
+	claims := tokenClaims{
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenID,
+			Issuer:    tokenIssuer,
+			Audience:  jwt.ClaimStrings{tokenAudience},
+			Subject:   strconv.Itoa(userID),
+			IssuedAt:  jwt.NewNumericDate(now),
+			NotBefore: jwt.NewNumericDate(now),
+			ExpiresAt: jwt.NewNumericDate(now.Add(expiresIn)),
+		},
+		TokenType: tokenType,
+		SessionID: sessionID,
+	}
+	return claims, nil
+}
+
+// signToken signs the claims with the current signing key
+func (cfg *ApiConfig) signToken(claims jwt.Claims) (string, error) {
+	tokenString, err := cfg.keySet().Sign(claims)
+	if err != nil {
+		return "", fmt.Errorf("could not sign token: %v", err)
+	}
+	return tokenString, nil
+}
+
+// newTokenID returns a random ID for the jti claim
+func newTokenID() (string, error) {
+	idBytes := make([]byte, 16)
+	if _, err := rand.Read(idBytes); err != nil {
+		return "", fmt.Errorf("could not generate token ID: %v", err)
+	}
+	return hex.EncodeToString(idBytes), nil
+}
+
+// parseToken verifies the token's algorithm, signature, issuer, audience,
+// lifetime and type and returns its claims
+func (cfg *ApiConfig) parseToken(tokenString, tokenType string) (*tokenClaims, error) {
+	claims := &tokenClaims{}
+	if err := cfg.parseClaims(tokenString, tokenType, claims); err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
+
+// parseClaims is parseToken for tokens with claims of their own
+func (cfg *ApiConfig) parseClaims(tokenString, tokenType string, claims typedClaims) error {
+	keys := cfg.keySet()
+	_, err := jwt.ParseWithClaims(tokenString, claims, keys.Keyfunc,
+		jwt.WithValidMethods(keys.Methods()),
+		jwt.WithIssuer(tokenIssuer),
+		jwt.WithAudience(tokenAudience),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(tokenLeeway),
+	)
+	if err != nil {
+		return err
+	}
+
+	if claims.tokenType() != tokenType {
+		return errWrongTokenType
+	}
+	return nil
+}
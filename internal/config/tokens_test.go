@@ -0,0 +1,164 @@
+package config
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/signing"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// newRSAConfig returns a config that signs with a single RS256 key, kid "k1"
+func newRSAConfig(t *testing.T) (*ApiConfig, *rsa.PrivateKey) {
+	t.Helper()
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	keyPEM := pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der})
+	if err := os.WriteFile(filepath.Join(dir, "k1.pem"), keyPEM, 0600); err != nil {
+		t.Fatal(err)
+	}
+	keys, err := signing.LoadDir(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &ApiConfig{SigningKeys: keys}, private
+}
+
+// forge signs the claims with any method and key, the way an attacker would
+func forge(t *testing.T, method jwt.SigningMethod, kid string, claims jwt.Claims, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+func TestParseTokenAcceptsAccessToken(t *testing.T) {
+	cfg, _ := newRSAConfig(t)
+	tokenString, err := cfg.issueToken(tokenTypeAccess, 42, 7, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := cfg.parseToken(tokenString, tokenTypeAccess)
+	if err != nil {
+		t.Fatalf("rejected our own access token: %v", err)
+	}
+	if claims.Subject != "42" || claims.SessionID != 7 || claims.ID == "" {
+		t.Errorf("got claims %+v", claims)
+	}
+}
+
+func TestParseTokenRejects(t *testing.T) {
+	cfg, private := newRSAConfig(t)
+	publicDER, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+	otherKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// claims returns valid access token claims changed by edit
+	claims := func(edit func(claims *tokenClaims)) *tokenClaims {
+		claims, err := newTokenClaims(tokenTypeAccess, 42, 0, time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if edit != nil {
+			edit(&claims)
+		}
+		return &claims
+	}
+	sign := func(claims jwt.Claims) string {
+		tokenString, err := cfg.signToken(claims)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return tokenString
+	}
+	mfaToken, err := cfg.issueToken(tokenTypeMFA, 42, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	stateClaims, err := newTokenClaims(tokenTypeOIDC, 42, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		// HS256 with the public key as the secret: anyone could sign these
+		{"HS256 with the RSA public key", forge(t, jwt.SigningMethodHS256, "k1", claims(nil), publicPEM)},
+		{"alg none", forge(t, jwt.SigningMethodNone, "k1", claims(nil), jwt.UnsafeAllowNoneSignatureType)},
+		{"unknown kid", forge(t, jwt.SigningMethodRS256, "k2", claims(nil), otherKey)},
+		{"known kid, other key", forge(t, jwt.SigningMethodRS256, "k1", claims(nil), otherKey)},
+		{"no kid", forge(t, jwt.SigningMethodRS256, "", claims(nil), private)},
+		{"wrong issuer", sign(claims(func(c *tokenClaims) { c.Issuer = "someone-else" }))},
+		{"no issuer", sign(claims(func(c *tokenClaims) { c.Issuer = "" }))},
+		{"wrong audience", sign(claims(func(c *tokenClaims) { c.Audience = jwt.ClaimStrings{"other-api"} }))},
+		{"no audience", sign(claims(func(c *tokenClaims) { c.Audience = nil }))},
+		{"expired", sign(claims(func(c *tokenClaims) { c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Hour)) }))},
+		{"no expiry", sign(claims(func(c *tokenClaims) { c.ExpiresAt = nil }))},
+		{"issued in the future", sign(claims(func(c *tokenClaims) { c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Hour)) }))},
+		{"MFA challenge", mfaToken},
+		{"OIDC state", sign(oidcStateClaims{tokenClaims: stateClaims, Provider: "test", State: "state"})},
+		{"no token type", sign(claims(func(c *tokenClaims) { c.TokenType = "" }))},
+		{"garbage", "not.a.token"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if claims, err := cfg.parseToken(tt.token, tokenTypeAccess); err == nil {
+				t.Errorf("accepted as an access token: %+v", claims)
+			}
+		})
+	}
+}
+
+func TestParseTokenChecksType(t *testing.T) {
+	cfg := &ApiConfig{JWTSecret: "test-secret"}
+	accessToken, err := cfg.issueToken(tokenTypeAccess, 42, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Each type only works where it is meant to
+	for _, tokenType := range []string{tokenTypeMFA, tokenTypeOIDC} {
+		if _, err := cfg.parseToken(accessToken, tokenType); !errors.Is(err, errWrongTokenType) {
+			t.Errorf("access token as %s: got %v, want %v", tokenType, err, errWrongTokenType)
+		}
+	}
+	mfaToken, err := cfg.issueToken(tokenTypeMFA, 42, 0, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := cfg.parseToken(mfaToken, tokenTypeMFA); err != nil {
+		t.Errorf("rejected an MFA token as one: %v", err)
+	}
+	if _, err := cfg.parseToken(mfaToken, tokenTypeAccess); !errors.Is(err, errWrongTokenType) {
+		t.Errorf("MFA token as access token: got %v, want %v", err, errWrongTokenType)
+	}
+}
@@ -0,0 +1,79 @@
+package config
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"unicode/utf8"
+)
+
+// Defaults for the chirp body rules
+const (
+	DefaultChirpMaxLength = 140
+	WordFilterMask        = "mask"   // Replace banned words with ****
+	WordFilterReject      = "reject" // Refuse chirps containing banned words
+)
+
+// fieldError describes one failed validation rule
+type fieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// validateChirpBody checks a chirp body against the configured rules and
+// returns the body to store, with banned words masked if that is the mode
+func (cfg *ApiConfig) validateChirpBody(body string) (string, []fieldError) {
+	var errs []fieldError
+
+	if strings.TrimSpace(body) == "" {
+		errs = append(errs, fieldError{Field: "body", Code: "required", Message: "Chirp body cannot be empty"})
+	}
+
+	// Length is counted in characters, not bytes
+	maxLength := cfg.ChirpMaxLength
+	if maxLength <= 0 {
+		maxLength = DefaultChirpMaxLength
+	}
+	if length := utf8.RuneCountInString(body); length > maxLength {
+		errs = append(errs, fieldError{
+			Field:   "body",
+			Code:    "too_long",
+			Message: fmt.Sprintf("Chirp is %d characters long, the limit is %d", length, maxLength),
+		})
+	}
+
+	if cfg.WordFilterMode == WordFilterReject {
+		if words := cfg.WordFilter.Find(body); len(words) > 0 {
+			errs = append(errs, fieldError{
+				Field:   "body",
+				Code:    "banned_words",
+				Message: fmt.Sprintf("Chirp contains %d banned word(s)", len(words)),
+			})
+		}
+	} else {
+		body = cfg.WordFilter.Replace(body)
+	}
+
+	return body, errs
+}
+
+// writeValidationErrors responds with 400 and the list of failed rules
+func writeValidationErrors(w http.ResponseWriter, errs []fieldError) {
+	writeFieldErrors(w, http.StatusBadRequest, "Invalid chirp", errs)
+}
+
+// writeFieldErrors responds with the status, a summary and the list of
+// failed rules
+func writeFieldErrors(w http.ResponseWriter, status int, message string, errs []fieldError) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	response := map[string]interface{}{
+		"error":  message,
+		"errors": errs,
+	}
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, `{"error": "Failed to encode response"}`, http.StatusInternalServerError)
+	}
+}
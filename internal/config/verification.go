@@ -0,0 +1,140 @@
+package config
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/mail"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// emailVerificationLifetime is how long a verification link works
+const emailVerificationLifetime = 24 * time.Hour
+
+// sendEmailVerification stores a new verification token for the user's
+// current address and emails the link to it
+func (cfg *ApiConfig) sendEmailVerification(user handlers.User) error {
+	token, hash, err := newOpaqueToken()
+	if err != nil {
+		return err
+	}
+
+	err = cfg.Store.CreateOneTimeToken(handlers.OneTimeToken{
+		Hash:      hash,
+		Purpose:   handlers.TokenPurposeEmailVerification,
+		UserID:    user.GetID(),
+		ExpiresAt: time.Now().UTC().Add(emailVerificationLifetime).Truncate(time.Microsecond),
+	})
+	if err != nil {
+		return err
+	}
+
+	link := strings.TrimSuffix(cfg.PublicURL, "/") + "/api/users/verify?" + url.Values{"token": {token}}.Encode()
+	return cfg.mailer().Send(mail.Message{
+		To:      user.Email,
+		Subject: "Verify your Chirpy email address",
+		Body: fmt.Sprintf("Open this link to confirm that %s is your email address:\n\n%s\n\n"+
+			"The link works for %s. If you did not sign up for Chirpy, you can ignore this email.\n",
+			user.Email, link, emailVerificationLifetime),
+	})
+}
+
+// sendEmailVerificationLogged sends a verification email where failing to
+// send it should not fail the request; a new link can always be requested
+func (cfg *ApiConfig) sendEmailVerificationLogged(user handlers.User) {
+	if err := cfg.sendEmailVerification(user); err != nil {
+		log.Printf("Could not send email verification to user %d: %v", user.GetID(), err)
+	}
+}
+
+// HandlerVerifyEmail marks the address the token was sent to as verified
+func (cfg *ApiConfig) HandlerVerifyEmail(w http.ResponseWriter, r *http.Request) {
+	token := r.URL.Query().Get("token")
+	if token == "" {
+		http.Error(w, `{"error": "token is required"}`, http.StatusBadRequest)
+		return
+	}
+
+	user, err := cfg.Store.RedeemOneTimeToken(hashToken(token), handlers.TokenPurposeEmailVerification, func(user *handlers.User) error {
+		user.EmailVerified = true
+		return nil
+	})
+	if errors.Is(err, storage.ErrNotFound) || errors.Is(err, storage.ErrTokenReused) || errors.Is(err, storage.ErrTokenExpired) {
+		http.Error(w, `{"error": "Invalid or expired verification token"}`, http.StatusBadRequest)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to write database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	response := map[string]interface{}{
+		"id":             user.GetID(),
+		"email":          user.Email,
+		"email_verified": user.EmailVerified,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(response)
+}
+
+// HandlerResendVerification emails a new verification link to the caller.
+// Links sent earlier stop working.
+func (cfg *ApiConfig) HandlerResendVerification(w http.ResponseWriter, r *http.Request) {
+	userID, ok := UserIDFromContext(r.Context())
+	if !ok {
+		writeUnauthorized(w)
+		return
+	}
+
+	user, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		http.Error(w, `{"error": "User not found"}`, http.StatusNotFound)
+		return
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+	if user.EmailVerified {
+		http.Error(w, `{"error": "Email is already verified"}`, http.StatusConflict)
+		return
+	}
+
+	if err := cfg.sendEmailVerification(user); err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to send verification email: %v"}`, err), http.StatusInternalServerError)
+		return
+	}
+
+	w.WriteHeader(http.StatusAccepted)
+}
+
+// requireVerifiedEmail enforces the RequireVerifiedEmail policy. It writes
+// the error response and returns false if the user may not continue.
+func (cfg *ApiConfig) requireVerifiedEmail(w http.ResponseWriter, userID int) bool {
+	if !cfg.RequireVerifiedEmail {
+		return true
+	}
+
+	user, err := cfg.Store.GetUser(userID)
+	if errors.Is(err, storage.ErrNotFound) {
+		writeUnauthorized(w)
+		return false
+	}
+	if err != nil {
+		http.Error(w, fmt.Sprintf(`{"error": "Failed to read database: %v"}`, err), http.StatusInternalServerError)
+		return false
+	}
+	if !user.EmailVerified {
+		http.Error(w, `{"error": "Verify your email address before posting chirps"}`, http.StatusForbidden)
+		return false
+	}
+	return true
+}
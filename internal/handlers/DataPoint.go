@@ -1,11 +1,32 @@
 package handlers
 
+import (
+	"time"
+)
+
 type DataPoint interface {
 	GetID() int
 	GetUniqueIdentifier() string
 }
 
 type Database struct {
-	Chirps map[string]Chirp `json:"chirps"`
-	Users  map[string]User  `json:"users"`
+	Chirps        map[string]Chirp           `json:"chirps"`
+	Users         map[string]User            `json:"users"`
+	Revisions     map[string][]ChirpRevision `json:"revisions"`       // Keyed by chirp ID, oldest first
+	Sessions      map[string]Session         `json:"sessions"`        // Keyed by session ID
+	RefreshTokens map[string]RefreshToken    `json:"refresh_tokens"`  // Keyed by token hash
+	DeniedTokens  map[string]time.Time       `json:"denied_tokens"`   // Access token IDs mapped to when the token expires
+	OneTimeTokens map[string]OneTimeToken    `json:"one_time_tokens"` // Keyed by token hash
+	Identities    map[string]Identity        `json:"identities"`      // Keyed by Identity.Key
+	APITokens     map[string]APIToken        `json:"api_tokens"`      // Keyed by token ID
+	Sequences     Sequences                  `json:"sequences"`
+}
+
+// Sequences holds the last ID handed out for each collection, so an ID is
+// never reused even after its record is deleted
+type Sequences struct {
+	Chirps    int `json:"chirps"`
+	Users     int `json:"users"`
+	Sessions  int `json:"sessions"`
+	APITokens int `json:"api_tokens"`
 }
@@ -0,0 +1,34 @@
+package handlers
+
+import (
+	"slices"
+	"time"
+)
+
+// APIToken is a long-lived personal access token for bots and integrations.
+// It can only do what its scopes allow. Only a hash of the token is stored.
+type APIToken struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	Name       string    `json:"name"`
+	Hash       string    `json:"hash"`
+	Scopes     []string  `json:"scopes"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"` // Zero until the token is used
+	ExpiresAt  time.Time `json:"expires_at"`   // Zero for a token that never expires
+	RevokedAt  time.Time `json:"revoked_at"`   // Zero while the token is active
+}
+
+func (token APIToken) GetID() (ID int) {
+	return token.ID
+}
+
+// Active reports whether the token can be used at now
+func (token APIToken) Active(now time.Time) bool {
+	return token.RevokedAt.IsZero() && (token.ExpiresAt.IsZero() || now.Before(token.ExpiresAt))
+}
+
+// HasScope reports whether the token was granted the scope
+func (token APIToken) HasScope(scope string) bool {
+	return slices.Contains(token.Scopes, scope)
+}
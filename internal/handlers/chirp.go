@@ -2,12 +2,24 @@ package handlers
 
 import (
 	"strconv"
+	"time"
 )
 
 type Chirp struct {
-	ID       int    `json:"id"`
-	Body     string `json:"body"`
-	AuthorID int    `json:"author_id"`
+	ID        int       `json:"id"`
+	Body      string    `json:"body"`
+	AuthorID  int       `json:"author_id"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+	Edited    bool      `json:"edited"`
+}
+
+// ChirpRevision is an earlier body of an edited chirp
+type ChirpRevision struct {
+	ChirpID   int       `json:"chirp_id"`
+	Revision  int       `json:"revision"`   // 1 is the original body
+	Body      string    `json:"body"`       // The body as it was before the edit
+	CreatedAt time.Time `json:"created_at"` // When this body was published
 }
 
 // Getter for ID
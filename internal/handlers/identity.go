@@ -0,0 +1,21 @@
+package handlers
+
+import (
+	"time"
+)
+
+// Identity links a user to their account at an OpenID Connect provider. The
+// provider's subject identifies the account; the email can change.
+type Identity struct {
+	Provider  string    `json:"provider"`
+	Subject   string    `json:"subject"`
+	UserID    int       `json:"user_id"`
+	Email     string    `json:"email"` // The email the provider reported when the identity was linked
+	CreatedAt time.Time `json:"created_at"`
+}
+
+// Key identifies the identity across providers. Provider names cannot
+// contain a colon, so keys of different providers never collide.
+func (identity Identity) Key() string {
+	return identity.Provider + ":" + identity.Subject
+}
@@ -0,0 +1,23 @@
+package handlers
+
+import (
+	"time"
+)
+
+// One-time token purposes, so a token sent for one purpose cannot be
+// redeemed for another
+const (
+	TokenPurposePasswordReset     = "password_reset"
+	TokenPurposeEmailVerification = "email_verification"
+)
+
+// OneTimeToken is a single-use token sent to a user by email. Only a hash of
+// the token is stored, like refresh tokens.
+type OneTimeToken struct {
+	Hash      string    `json:"hash"`
+	Purpose   string    `json:"purpose"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"` // Zero until the token is redeemed
+}
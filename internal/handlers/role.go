@@ -0,0 +1,38 @@
+package handlers
+
+// Roles, from least to most privileged. Each role can do everything the
+// roles before it can.
+const (
+	RoleUser      = "user"
+	RoleModerator = "moderator" // Can delete any chirp
+	RoleAdmin     = "admin"     // Can reset metrics and change other users' roles
+)
+
+// roleRanks orders the roles. Users stored before roles existed have none
+// and rank as RoleUser.
+var roleRanks = map[string]int{
+	"":            0,
+	RoleUser:      0,
+	RoleModerator: 1,
+	RoleAdmin:     2,
+}
+
+// ValidRole reports whether role is one of the roles a user can be given
+func ValidRole(role string) bool {
+	return role == RoleUser || role == RoleModerator || role == RoleAdmin
+}
+
+// RoleAtLeast reports whether role is minimum or a more privileged role.
+// Unknown roles have no privileges at all.
+func RoleAtLeast(role, minimum string) bool {
+	rank, ok := roleRanks[role]
+	return ok && rank >= roleRanks[minimum]
+}
+
+// GetRole returns the user's role, RoleUser if none was set
+func (user User) GetRole() string {
+	if user.Role == "" {
+		return RoleUser
+	}
+	return user.Role
+}
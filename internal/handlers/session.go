@@ -0,0 +1,38 @@
+package handlers
+
+import (
+	"time"
+)
+
+// Session is one login on one device. Each refresh swaps its refresh token
+// for a new one, so all the tokens handed out for a session form a family.
+type Session struct {
+	ID         int       `json:"id"`
+	UserID     int       `json:"user_id"`
+	UserAgent  string    `json:"user_agent"`
+	IP         string    `json:"ip"`
+	CreatedAt  time.Time `json:"created_at"`
+	LastUsedAt time.Time `json:"last_used_at"`
+	ExpiresAt  time.Time `json:"expires_at"` // When the current refresh token expires
+	RevokedAt  time.Time `json:"revoked_at"` // Zero while the session is active
+}
+
+func (session Session) GetID() (ID int) {
+	return session.ID
+}
+
+// Active reports whether the session can still be refreshed at now
+func (session Session) Active(now time.Time) bool {
+	return session.RevokedAt.IsZero() && now.Before(session.ExpiresAt)
+}
+
+// RefreshToken is one refresh token of a session. Only a hash of the token
+// is stored, so a leaked database cannot be used to log in.
+type RefreshToken struct {
+	Hash      string    `json:"hash"`
+	SessionID int       `json:"session_id"`
+	UserID    int       `json:"user_id"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at"`
+	UsedAt    time.Time `json:"used_at"` // Set once the token is swapped; using it again means it was stolen
+}
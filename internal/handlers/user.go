@@ -5,13 +5,23 @@ import (
 )
 
 type User struct {
-	ID                    int       `json:"id"`
-	Email                 string    `json:"email"`
-	Password              string    `json:"password"`
-	ExpiresInSeconds      int       `json:"expires_in_seconds"`
-	RefreshToken          string    `json:"refresh_token"`
-	RefreshTokenExpiresAt time.Time `json:"refresh_token_expires_at"`
-	IsChirpyRed           bool      `json:"is_chirpy_red"`
+	ID               int       `json:"id"`
+	Email            string    `json:"email"`
+	Password         string    `json:"password"`
+	ExpiresInSeconds int       `json:"expires_in_seconds"`
+	IsChirpyRed      bool      `json:"is_chirpy_red"`
+	EmailVerified    bool      `json:"email_verified"`
+	CreatedAt        time.Time `json:"created_at"`
+	UpdatedAt        time.Time `json:"updated_at"`
+	TokensValidAfter time.Time `json:"tokens_valid_after"` // Access tokens issued before this are rejected
+	Role             string    `json:"role,omitempty"`     // RoleUser, RoleModerator or RoleAdmin; empty is RoleUser
+
+	// Two-factor authentication. The secret is set when enrolment starts and
+	// only used for logins once TOTPEnabled is set.
+	TOTPSecret    string   `json:"totp_secret,omitempty"`
+	TOTPEnabled   bool     `json:"totp_enabled"`
+	TOTPLastStep  int64    `json:"totp_last_step,omitempty"` // Time step of the last code used, so it cannot be used again
+	RecoveryCodes []string `json:"recovery_codes,omitempty"` // Hashes of the unused recovery codes
 }
 
 func (user User) GetID() (ID int) {
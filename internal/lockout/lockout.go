@@ -0,0 +1,119 @@
+// Package lockout slows down password guessing. Every failed attempt is
+// counted per key (an email address, a client IP); after a few free attempts
+// the key is locked for a delay that doubles with each further failure.
+package lockout
+
+import (
+	"sync"
+	"time"
+)
+
+// Policy decides how quickly a key is locked
+type Policy struct {
+	FreeAttempts int           // Failures allowed before the first lock
+	BaseDelay    time.Duration // Lock after the first failure past FreeAttempts; doubles with every further failure
+	MaxDelay     time.Duration // Longest lock
+	Forget       time.Duration // A key's failures are forgotten after this long without a new one
+}
+
+// sweepEvery is how many failures are recorded between removals of forgotten keys
+const sweepEvery = 1000
+
+type entry struct {
+	failures    int
+	lastFailure time.Time
+	lockedUntil time.Time
+}
+
+// Tracker counts failures per key. It is safe for concurrent use, and a nil
+// *Tracker never locks anything.
+type Tracker struct {
+	policy Policy
+	now    func() time.Time
+
+	mu      sync.Mutex // Guards entries and sinceSweep
+	entries map[string]*entry
+	// Failures recorded since forgotten keys were last removed
+	sinceSweep int
+}
+
+// New returns an empty tracker for the policy
+func New(policy Policy) *Tracker {
+	return &Tracker{policy: policy, now: time.Now, entries: make(map[string]*entry)}
+}
+
+// Locked returns how much longer the key is locked, 0 if it is not
+func (t *Tracker) Locked(key string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	e, exists := t.entries[key]
+	if !exists {
+		return 0
+	}
+	return max(e.lockedUntil.Sub(t.now()), 0)
+}
+
+// Fail records a failed attempt and returns how long the key is now locked
+func (t *Tracker) Fail(key string) time.Duration {
+	if t == nil {
+		return 0
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	now := t.now()
+	t.sinceSweep++
+	if t.sinceSweep >= sweepEvery {
+		t.sweep(now)
+	}
+
+	e, exists := t.entries[key]
+	if !exists || t.forgotten(e, now) {
+		e = &entry{}
+		t.entries[key] = e
+	}
+	e.failures++
+	e.lastFailure = now
+
+	if over := e.failures - t.policy.FreeAttempts; over > 0 {
+		delay := t.policy.BaseDelay
+		for i := 1; i < over && delay < t.policy.MaxDelay; i++ {
+			delay *= 2
+		}
+		delay = min(delay, t.policy.MaxDelay)
+		e.lockedUntil = now.Add(delay)
+	}
+	return max(e.lockedUntil.Sub(now), 0)
+}
+
+// Reset forgets the key's failures, after a successful attempt
+func (t *Tracker) Reset(key string) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	delete(t.entries, key)
+}
+
+// forgotten reports whether the entry's failures no longer count.
+// The caller must hold t.mu.
+func (t *Tracker) forgotten(e *entry, now time.Time) bool {
+	return now.After(e.lockedUntil) && now.Sub(e.lastFailure) > t.policy.Forget
+}
+
+// sweep removes forgotten keys so the map does not grow without bound.
+// The caller must hold t.mu.
+func (t *Tracker) sweep(now time.Time) {
+	for key, e := range t.entries {
+		if t.forgotten(e, now) {
+			delete(t.entries, key)
+		}
+	}
+	t.sinceSweep = 0
+}
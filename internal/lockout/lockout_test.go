@@ -0,0 +1,103 @@
+package lockout
+
+import (
+	"testing"
+	"time"
+)
+
+// newTestTracker returns a tracker with the login policy and a clock the
+// test moves by hand
+func newTestTracker() (*Tracker, *time.Time) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	tracker := New(Policy{FreeAttempts: 5, BaseDelay: 30 * time.Second, MaxDelay: 15 * time.Minute, Forget: time.Hour})
+	tracker.now = func() time.Time { return now }
+	return tracker, &now
+}
+
+func TestBackoff(t *testing.T) {
+	tracker, _ := newTestTracker()
+
+	want := []time.Duration{
+		0, 0, 0, 0, 0, // Free attempts
+		30 * time.Second,
+		time.Minute,
+		2 * time.Minute,
+		4 * time.Minute,
+		8 * time.Minute,
+		15 * time.Minute, // Capped
+		15 * time.Minute,
+	}
+	for i, wantDelay := range want {
+		if got := tracker.Fail("key"); got != wantDelay {
+			t.Errorf("failure %d: locked for %v, want %v", i+1, got, wantDelay)
+		}
+		if got := tracker.Locked("key"); got != wantDelay {
+			t.Errorf("failure %d: Locked says %v, want %v", i+1, got, wantDelay)
+		}
+	}
+	if got := tracker.Locked("other"); got != 0 {
+		t.Errorf("another key is locked for %v", got)
+	}
+}
+
+func TestLockExpires(t *testing.T) {
+	tracker, now := newTestTracker()
+	for range 6 {
+		tracker.Fail("key")
+	}
+
+	*now = now.Add(10 * time.Second)
+	if got := tracker.Locked("key"); got != 20*time.Second {
+		t.Errorf("locked for %v after 10s, want 20s", got)
+	}
+	*now = now.Add(20 * time.Second)
+	if got := tracker.Locked("key"); got != 0 {
+		t.Errorf("still locked for %v after the delay", got)
+	}
+
+	// The failures still count until they are forgotten
+	if got := tracker.Fail("key"); got != time.Minute {
+		t.Errorf("next failure locked for %v, want 1m", got)
+	}
+}
+
+func TestFailuresForgotten(t *testing.T) {
+	tracker, now := newTestTracker()
+	for range 6 {
+		tracker.Fail("key")
+	}
+
+	// Just under an hour after the last failure they still count
+	*now = now.Add(59 * time.Minute)
+	if got := tracker.Fail("key"); got != time.Minute {
+		t.Errorf("failure after 59m locked for %v, want 1m", got)
+	}
+
+	// An hour and a bit later the key starts over with its free attempts
+	*now = now.Add(time.Hour + time.Second)
+	if got := tracker.Fail("key"); got != 0 {
+		t.Errorf("failure after being forgotten locked for %v, want 0", got)
+	}
+}
+
+func TestReset(t *testing.T) {
+	tracker, _ := newTestTracker()
+	for range 6 {
+		tracker.Fail("key")
+	}
+	tracker.Reset("key")
+	if got := tracker.Locked("key"); got != 0 {
+		t.Errorf("locked for %v after a reset", got)
+	}
+	if got := tracker.Fail("key"); got != 0 {
+		t.Errorf("failure after a reset locked for %v, want 0", got)
+	}
+}
+
+func TestNilTracker(t *testing.T) {
+	var tracker *Tracker
+	if tracker.Fail("key") != 0 || tracker.Locked("key") != 0 {
+		t.Error("a nil tracker locked a key")
+	}
+	tracker.Reset("key")
+}
@@ -0,0 +1,82 @@
+// Package mail sends the emails the server needs, such as password reset
+// links. The default mailers never leave the machine, so everything works
+// offline; a real provider only has to implement Mailer.
+package mail
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// Message is a plain text email
+type Message struct {
+	To      string
+	Subject string
+	Body    string
+}
+
+// Mailer delivers messages
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// LogMailer writes every message to the log
+type LogMailer struct {
+	Logger *log.Logger // log.Default() if nil
+}
+
+func (m LogMailer) Send(msg Message) error {
+	logger := m.Logger
+	if logger == nil {
+		logger = log.Default()
+	}
+	logger.Printf("mail to %s: %s\n%s", msg.To, msg.Subject, msg.Body)
+	return nil
+}
+
+// DirMailer writes every message to its own .eml file in Dir, which most
+// mail clients can open
+type DirMailer struct {
+	Dir string
+}
+
+func (m DirMailer) Send(msg Message) error {
+	if err := os.MkdirAll(m.Dir, 0700); err != nil {
+		return fmt.Errorf("could not create mail directory: %v", err)
+	}
+
+	// The time keeps the files in order, the random suffix keeps them apart
+	suffix := make([]byte, 4)
+	if _, err := rand.Read(suffix); err != nil {
+		return fmt.Errorf("could not name mail file: %v", err)
+	}
+	now := time.Now().UTC()
+	name := now.Format("20060102T150405.000000Z") + "-" + hex.EncodeToString(suffix) + ".eml"
+
+	file, err := os.OpenFile(filepath.Join(m.Dir, name), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("could not create mail file: %v", err)
+	}
+	defer file.Close()
+
+	_, err = fmt.Fprintf(file, "To: %s\r\nSubject: %s\r\nDate: %s\r\nContent-Type: text/plain; charset=utf-8\r\n\r\n%s",
+		headerValue(msg.To), headerValue(msg.Subject), now.Format(time.RFC1123Z), msg.Body)
+	if err != nil {
+		return fmt.Errorf("could not write mail file: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return fmt.Errorf("could not write mail file: %v", err)
+	}
+	return nil
+}
+
+// headerValue keeps user input such as an email address from adding headers
+func headerValue(value string) string {
+	return strings.NewReplacer("\r", "", "\n", "").Replace(value)
+}
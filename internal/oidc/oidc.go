@@ -0,0 +1,354 @@
+// Package oidc logs users in with an external OpenID Connect provider using
+// the authorization code flow with PKCE (RFC 7636).
+//
+// Everything the package needs from the provider is found through its
+// discovery document, so a test can stand in for the provider with an
+// httptest.Server serving /.well-known/openid-configuration, a JWKS and a
+// token endpoint, and point Issuer and HTTPClient at it.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// DefaultScopes are requested when a provider has no Scopes
+var DefaultScopes = []string{"openid", "email", "profile"}
+
+const (
+	// keysRefreshInterval limits how often an unknown key ID makes us fetch
+	// the provider's keys again, so forged tokens cannot hammer the provider
+	keysRefreshInterval = time.Minute
+
+	// leeway absorbs small clock differences with the provider
+	leeway = 30 * time.Second
+)
+
+// ErrInvalidIDToken is returned when the ID token from the provider fails
+// verification
+var ErrInvalidIDToken = errors.New("invalid ID token")
+
+// Provider is one OpenID Connect provider users can log in with
+type Provider struct {
+	Name         string // Used in our URLs and to tell identities of different providers apart
+	Issuer       string // Exactly as it appears in the provider's ID tokens
+	ClientID     string
+	ClientSecret string // Empty for public clients, which rely on PKCE alone
+	RedirectURL  string
+	Scopes       []string
+	HTTPClient   *http.Client // Defaults to a client with a 10 second timeout
+
+	mu          sync.Mutex // Guards the fields below
+	discovery   *discovery
+	keys        map[string]interface{}
+	keysFetched time.Time
+}
+
+// discovery is the part of the provider's discovery document we use
+type discovery struct {
+	Issuer                string   `json:"issuer"`
+	AuthorizationEndpoint string   `json:"authorization_endpoint"`
+	TokenEndpoint         string   `json:"token_endpoint"`
+	JWKSURI               string   `json:"jwks_uri"`
+	TokenAuthMethods      []string `json:"token_endpoint_auth_methods_supported"`
+}
+
+// Claims is what we learn about the user from the ID token
+type Claims struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+	Name          string
+}
+
+// idTokenClaims are the claims of an ID token
+type idTokenClaims struct {
+	jwt.RegisteredClaims
+	Nonce           string      `json:"nonce"`
+	AuthorizedParty string      `json:"azp"`
+	Email           string      `json:"email"`
+	EmailVerified   interface{} `json:"email_verified"` // Some providers send "true" instead of true
+	Name            string      `json:"name"`
+}
+
+// NewVerifier returns a random PKCE code verifier
+func NewVerifier() (string, error) {
+	return randomString(32)
+}
+
+// NewState returns a random value for the state or nonce parameter
+func NewState() (string, error) {
+	return randomString(16)
+}
+
+// Challenge returns the S256 code challenge for a verifier
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func randomString(n int) (string, error) {
+	randomBytes := make([]byte, n)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("could not generate random value: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
+
+// AuthCodeURL returns the provider URL to send the user to
+func (p *Provider) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	scopes := p.Scopes
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	query := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {p.ClientID},
+		"redirect_uri":          {p.RedirectURL},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	separator := "?"
+	if strings.Contains(doc.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return doc.AuthorizationEndpoint + separator + query.Encode(), nil
+}
+
+// Exchange swaps the authorization code for an ID token and returns its
+// verified claims. nonce is the value sent with AuthCodeURL.
+func (p *Provider) Exchange(ctx context.Context, code, verifier, nonce string) (Claims, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return Claims{}, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {p.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	// client_secret_basic is the default when the provider does not say
+	useBasic := p.ClientSecret != "" &&
+		(len(doc.TokenAuthMethods) == 0 || slices.Contains(doc.TokenAuthMethods, "client_secret_basic"))
+	if !useBasic {
+		form.Set("client_id", p.ClientID)
+		if p.ClientSecret != "" {
+			form.Set("client_secret", p.ClientSecret)
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, doc.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return Claims{}, fmt.Errorf("could not build token request: %v", err)
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if useBasic {
+		req.SetBasicAuth(url.QueryEscape(p.ClientID), url.QueryEscape(p.ClientSecret))
+	}
+
+	var tokenResponse struct {
+		IDToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	status, err := p.doJSON(req, &tokenResponse)
+	if err != nil {
+		return Claims{}, fmt.Errorf("could not exchange code: %v", err)
+	}
+	if status != http.StatusOK || tokenResponse.Error != "" {
+		return Claims{}, fmt.Errorf("could not exchange code: %d %s %s", status, tokenResponse.Error, tokenResponse.ErrorDescription)
+	}
+	if tokenResponse.IDToken == "" {
+		return Claims{}, fmt.Errorf("could not exchange code: no id_token in response")
+	}
+
+	return p.verify(ctx, tokenResponse.IDToken, nonce)
+}
+
+// verify checks the ID token's signature, issuer, audience, lifetime and nonce
+func (p *Provider) verify(ctx context.Context, rawIDToken, nonce string) (Claims, error) {
+	claims := &idTokenClaims{}
+	_, err := jwt.ParseWithClaims(rawIDToken, claims,
+		func(token *jwt.Token) (interface{}, error) {
+			kid, _ := token.Header["kid"].(string)
+			return p.key(ctx, kid)
+		},
+		jwt.WithValidMethods([]string{"RS256", "RS384", "RS512", "ES256", "ES384", "EdDSA"}),
+		jwt.WithIssuer(p.Issuer),
+		jwt.WithAudience(p.ClientID),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+		jwt.WithLeeway(leeway),
+	)
+	if err != nil {
+		return Claims{}, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	// A token for several audiences must name us as the party it was issued to
+	if len(claims.Audience) > 1 && claims.AuthorizedParty != p.ClientID {
+		return Claims{}, fmt.Errorf("%w: azp is not our client ID", ErrInvalidIDToken)
+	}
+	if claims.Nonce == "" || claims.Nonce != nonce {
+		return Claims{}, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	if claims.Subject == "" {
+		return Claims{}, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	}
+
+	return Claims{
+		Subject:       claims.Subject,
+		Email:         claims.Email,
+		EmailVerified: claims.EmailVerified == true || claims.EmailVerified == "true",
+		Name:          claims.Name,
+	}, nil
+}
+
+// discover fetches the discovery document once and keeps it
+func (p *Provider) discover(ctx context.Context) (*discovery, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.discovery != nil {
+		return p.discovery, nil
+	}
+
+	wellKnown := strings.TrimSuffix(p.Issuer, "/") + "/.well-known/openid-configuration"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, wellKnown, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build discovery request: %v", err)
+	}
+
+	doc := &discovery{}
+	status, err := p.doJSON(req, doc)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch discovery document: %v", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch discovery document: status %d", status)
+	}
+
+	// The issuer must match exactly, or tokens from one provider could pass
+	// for another's (OpenID Connect Discovery 4.3)
+	if doc.Issuer != p.Issuer {
+		return nil, fmt.Errorf("discovery document is for issuer %q, not %q", doc.Issuer, p.Issuer)
+	}
+	if doc.AuthorizationEndpoint == "" || doc.TokenEndpoint == "" || doc.JWKSURI == "" {
+		return nil, fmt.Errorf("discovery document is missing an endpoint")
+	}
+
+	p.discovery = doc
+	return doc, nil
+}
+
+// key returns the provider's public key with the key ID. Providers rotate
+// their keys, so an unknown key ID fetches the keys again.
+func (p *Provider) key(ctx context.Context, kid string) (interface{}, error) {
+	doc, err := p.discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	if time.Since(p.keysFetched) < keysRefreshInterval {
+		return nil, fmt.Errorf("unknown key ID %q", kid)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, doc.JWKSURI, nil)
+	if err != nil {
+		return nil, fmt.Errorf("could not build JWKS request: %v", err)
+	}
+	var jwks struct {
+		Keys []jwk `json:"keys"`
+	}
+	status, err := p.doJSON(req, &jwks)
+	if err != nil {
+		return nil, fmt.Errorf("could not fetch JWKS: %v", err)
+	}
+	if status != http.StatusOK {
+		return nil, fmt.Errorf("could not fetch JWKS: status %d", status)
+	}
+
+	// Keys we cannot use (encryption keys, unknown types) are skipped
+	p.keys = make(map[string]interface{})
+	for _, k := range jwks.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+		if public, err := k.publicKey(); err == nil {
+			p.keys[k.KeyID] = public
+		}
+	}
+	p.keysFetched = time.Now()
+
+	if key, ok := p.lookupKey(kid); ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown key ID %q", kid)
+}
+
+// lookupKey finds a key by ID. A token without a key ID is accepted when the
+// provider has a single key. The caller must hold p.mu.
+func (p *Provider) lookupKey(kid string) (interface{}, bool) {
+	if kid == "" && len(p.keys) == 1 {
+		for _, key := range p.keys {
+			return key, true
+		}
+	}
+	key, ok := p.keys[kid]
+	return key, ok
+}
+
+// doJSON sends the request and decodes the JSON response body into v
+func (p *Provider) doJSON(req *http.Request, v interface{}) (int, error) {
+	client := p.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: 10 * time.Second}
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return 0, err
+	}
+	defer resp.Body.Close()
+
+	// Nothing we read from a provider comes close to a megabyte
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return resp.StatusCode, err
+	}
+	if err := json.Unmarshal(body, v); err != nil && resp.StatusCode == http.StatusOK {
+		return resp.StatusCode, fmt.Errorf("could not decode response: %v", err)
+	}
+	return resp.StatusCode, nil
+}
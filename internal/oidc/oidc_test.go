@@ -0,0 +1,121 @@
+package oidc_test
+
+import (
+	"context"
+	"errors"
+	"net/url"
+	"slices"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/oidc"
+	"github.com/RichardHoa/go-server/internal/oidc/oidctest"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const testRedirectURL = "https://chirpy.example/api/oidc/test/callback"
+
+var testUser = oidctest.User{Subject: "user-1", Email: "user@example.com", EmailVerified: true}
+
+func newProvider(t *testing.T) (*oidctest.Server, *oidc.Provider) {
+	t.Helper()
+	server, err := oidctest.NewServer("chirpy", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Close)
+
+	provider := &oidc.Provider{
+		Name:         "test",
+		Issuer:       server.URL,
+		ClientID:     server.ClientID,
+		ClientSecret: server.ClientSecret,
+		RedirectURL:  testRedirectURL,
+		HTTPClient:   server.Client(),
+	}
+	return server, provider
+}
+
+// authorize logs testUser in at the provider and returns the code
+func authorize(t *testing.T, server *oidctest.Server, provider *oidc.Provider, state, nonce, verifier string) string {
+	t.Helper()
+	authURL, err := provider.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatalf("could not build authorization URL: %v", err)
+	}
+	redirect, err := server.Authorize(authURL, testUser)
+	if err != nil {
+		t.Fatalf("provider rejected authorization URL: %v", err)
+	}
+	parsed, err := url.Parse(redirect)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := parsed.Query().Get("state"); got != state {
+		t.Fatalf("provider returned state %q, want %q", got, state)
+	}
+	return parsed.Query().Get("code")
+}
+
+func TestExchangeSendsVerifier(t *testing.T) {
+	server, provider := newProvider(t)
+	ctx := context.Background()
+
+	verifier, err := oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	code := authorize(t, server, provider, "state", "nonce", verifier)
+	claims, err := provider.Exchange(ctx, code, verifier, "nonce")
+	if err != nil {
+		t.Fatalf("could not exchange code: %v", err)
+	}
+	if !slices.Contains(server.Verifiers(), verifier) {
+		t.Errorf("provider got verifiers %q, want %q", server.Verifiers(), verifier)
+	}
+	want := oidc.Claims{Subject: testUser.Subject, Email: testUser.Email, EmailVerified: true}
+	if claims != want {
+		t.Errorf("got claims %+v, want %+v", claims, want)
+	}
+
+	// A code stolen without the verifier is useless
+	code = authorize(t, server, provider, "state", "nonce", verifier)
+	otherVerifier, err := oidc.NewVerifier()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := provider.Exchange(ctx, code, otherVerifier, "nonce"); err == nil {
+		t.Error("exchanged a code with the wrong verifier")
+	}
+}
+
+func TestExchangeRejectsInvalidIDToken(t *testing.T) {
+	tests := []struct {
+		name       string
+		editClaims func(claims jwt.MapClaims)
+	}{
+		{"nonce mismatch", func(claims jwt.MapClaims) { claims["nonce"] = "other-nonce" }},
+		{"no nonce", func(claims jwt.MapClaims) { delete(claims, "nonce") }},
+		{"issuer mismatch", func(claims jwt.MapClaims) { claims["iss"] = "https://evil.example" }},
+		{"audience mismatch", func(claims jwt.MapClaims) { claims["aud"] = "someone-else" }},
+		{"several audiences without azp", func(claims jwt.MapClaims) { claims["aud"] = []string{"chirpy", "someone-else"} }},
+		{"expired", func(claims jwt.MapClaims) { claims["exp"] = int64(1) }},
+		{"no subject", func(claims jwt.MapClaims) { delete(claims, "sub") }},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			server, provider := newProvider(t)
+			server.EditClaims = tt.editClaims
+
+			verifier, err := oidc.NewVerifier()
+			if err != nil {
+				t.Fatal(err)
+			}
+			code := authorize(t, server, provider, "state", "nonce", verifier)
+			_, err = provider.Exchange(context.Background(), code, verifier, "nonce")
+			if !errors.Is(err, oidc.ErrInvalidIDToken) {
+				t.Errorf("got error %v, want %v", err, oidc.ErrInvalidIDToken)
+			}
+		})
+	}
+}
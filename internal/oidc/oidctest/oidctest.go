@@ -0,0 +1,257 @@
+// Package oidctest provides a stand-in OpenID Connect provider for tests. It
+// serves a discovery document, a JWKS and a token endpoint that checks the
+// PKCE code verifier, and signs ID tokens with a fresh RSA key.
+package oidctest
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// keyID is the kid of the provider's only signing key
+const keyID = "test-key"
+
+// User is the account the user logs in with at the provider
+type User struct {
+	Subject       string
+	Email         string
+	EmailVerified bool
+}
+
+// Server is a running stand-in provider. Its URL is the issuer.
+type Server struct {
+	*httptest.Server
+	ClientID     string
+	ClientSecret string
+
+	// EditClaims, if set, changes the claims of every ID token before it is
+	// signed, to test tokens a real provider should never send
+	EditClaims func(claims jwt.MapClaims)
+
+	key *rsa.PrivateKey
+
+	mu        sync.Mutex // Guards the fields below
+	codes     map[string]authorization
+	verifiers []string
+}
+
+// authorization is what the provider remembers about an issued code
+type authorization struct {
+	clientID    string
+	redirectURI string
+	challenge   string
+	nonce       string
+	user        User
+}
+
+// NewServer starts a provider for one client. Close it when done.
+func NewServer(clientID, clientSecret string) (*Server, error) {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		return nil, fmt.Errorf("could not generate key: %v", err)
+	}
+
+	s := &Server{
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		key:          key,
+		codes:        make(map[string]authorization),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", s.handleDiscovery)
+	mux.HandleFunc("GET /jwks", s.handleJWKS)
+	mux.HandleFunc("POST /token", s.handleToken)
+	s.Server = httptest.NewServer(mux)
+	return s, nil
+}
+
+// Authorize plays the user logging in at the authorization URL from
+// Provider.AuthCodeURL. It returns the URL the provider redirects the browser
+// to, with the code and state.
+func (s *Server) Authorize(authURL string, user User) (string, error) {
+	parsed, err := url.Parse(authURL)
+	if err != nil {
+		return "", fmt.Errorf("could not parse authorization URL: %v", err)
+	}
+	if parsed.Path != "/authorize" {
+		return "", fmt.Errorf("authorization URL has path %q", parsed.Path)
+	}
+
+	query := parsed.Query()
+	switch {
+	case query.Get("response_type") != "code":
+		return "", fmt.Errorf("response_type is %q", query.Get("response_type"))
+	case query.Get("client_id") != s.ClientID:
+		return "", fmt.Errorf("client_id is %q", query.Get("client_id"))
+	case query.Get("code_challenge_method") != "S256" || query.Get("code_challenge") == "":
+		return "", fmt.Errorf("no S256 code challenge")
+	case query.Get("redirect_uri") == "":
+		return "", fmt.Errorf("no redirect_uri")
+	}
+
+	code, err := randomString()
+	if err != nil {
+		return "", err
+	}
+	s.mu.Lock()
+	s.codes[code] = authorization{
+		clientID:    query.Get("client_id"),
+		redirectURI: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+		user:        user,
+	}
+	s.mu.Unlock()
+
+	redirect, err := url.Parse(query.Get("redirect_uri"))
+	if err != nil {
+		return "", fmt.Errorf("could not parse redirect_uri: %v", err)
+	}
+	callbackQuery := redirect.Query()
+	callbackQuery.Set("code", code)
+	callbackQuery.Set("state", query.Get("state"))
+	redirect.RawQuery = callbackQuery.Encode()
+	return redirect.String(), nil
+}
+
+// Verifiers returns the code_verifier of every token request so far
+func (s *Server) Verifiers() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.verifiers...)
+}
+
+func (s *Server) handleDiscovery(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"issuer":                                s.URL,
+		"authorization_endpoint":                s.URL + "/authorize",
+		"token_endpoint":                        s.URL + "/token",
+		"jwks_uri":                              s.URL + "/jwks",
+		"token_endpoint_auth_methods_supported": []string{"client_secret_basic"},
+	})
+}
+
+func (s *Server) handleJWKS(w http.ResponseWriter, r *http.Request) {
+	public := s.key.PublicKey
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"keys": []map[string]string{{
+			"kty": "RSA",
+			"kid": keyID,
+			"use": "sig",
+			"alg": "RS256",
+			"n":   base64.RawURLEncoding.EncodeToString(public.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(public.E)).Bytes()),
+		}},
+	})
+}
+
+// handleToken swaps a code for an ID token, checking the client and that the
+// code verifier matches the challenge the code was issued for
+func (s *Server) handleToken(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil {
+		writeTokenError(w, "invalid_request", "could not parse form")
+		return
+	}
+
+	clientID, clientSecret, ok := r.BasicAuth()
+	if ok {
+		clientID, _ = url.QueryUnescape(clientID)
+		clientSecret, _ = url.QueryUnescape(clientSecret)
+	} else {
+		clientID, clientSecret = r.PostForm.Get("client_id"), r.PostForm.Get("client_secret")
+	}
+	if clientID != s.ClientID || clientSecret != s.ClientSecret {
+		writeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostForm.Get("grant_type") != "authorization_code" {
+		writeTokenError(w, "unsupported_grant_type", "")
+		return
+	}
+
+	verifier := r.PostForm.Get("code_verifier")
+	s.mu.Lock()
+	s.verifiers = append(s.verifiers, verifier)
+	// Codes are single use, whatever the outcome
+	auth, ok := s.codes[r.PostForm.Get("code")]
+	delete(s.codes, r.PostForm.Get("code"))
+	s.mu.Unlock()
+
+	switch {
+	case !ok:
+		writeTokenError(w, "invalid_grant", "unknown code")
+		return
+	case auth.clientID != clientID:
+		writeTokenError(w, "invalid_grant", "code was issued to another client")
+		return
+	case auth.redirectURI != r.PostForm.Get("redirect_uri"):
+		writeTokenError(w, "invalid_grant", "redirect_uri does not match")
+		return
+	}
+	sum := sha256.Sum256([]byte(verifier))
+	if base64.RawURLEncoding.EncodeToString(sum[:]) != auth.challenge {
+		writeTokenError(w, "invalid_grant", "code_verifier does not match")
+		return
+	}
+
+	now := time.Now()
+	claims := jwt.MapClaims{
+		"iss":            s.URL,
+		"sub":            auth.user.Subject,
+		"aud":            s.ClientID,
+		"iat":            now.Unix(),
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"nonce":          auth.nonce,
+		"email":          auth.user.Email,
+		"email_verified": auth.user.EmailVerified,
+	}
+	if s.EditClaims != nil {
+		s.EditClaims(claims)
+	}
+
+	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
+	token.Header["kid"] = keyID
+	idToken, err := token.SignedString(s.key)
+	if err != nil {
+		writeJSON(w, http.StatusInternalServerError, map[string]string{"error": "server_error"})
+		return
+	}
+
+	writeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token": "unused",
+		"token_type":   "Bearer",
+		"expires_in":   300,
+		"id_token":     idToken,
+	})
+}
+
+func writeTokenError(w http.ResponseWriter, code, description string) {
+	writeJSON(w, http.StatusBadRequest, map[string]string{"error": code, "error_description": description})
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func randomString() (string, error) {
+	randomBytes := make([]byte, 16)
+	if _, err := rand.Read(randomBytes); err != nil {
+		return "", fmt.Errorf("could not generate random value: %v", err)
+	}
+	return base64.RawURLEncoding.EncodeToString(randomBytes), nil
+}
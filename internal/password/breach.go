@@ -0,0 +1,155 @@
+package password
+
+import (
+	"bufio"
+	"crypto/sha1"
+	_ "embed"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// prefixLength is how many hex characters of a SHA-1 hash select a range,
+// the same split the Pwned Passwords range API uses
+const prefixLength = 5
+
+// Breaches is a set of passwords known from data breaches, stored as SHA-1
+// hashes. Lookups follow the k-anonymity model: only the first five hex
+// characters of a hash are used to fetch a range, and the rest is compared
+// by the caller, so a remote implementation never sees the password or its
+// full hash.
+type Breaches interface {
+	// Range returns the uppercase hash suffixes starting with prefix,
+	// mapped to how often each was seen
+	Range(prefix string) (map[string]int, error)
+}
+
+// BreachCount returns how often the password was seen in breaches, 0 if never
+func BreachCount(breaches Breaches, password string) (int, error) {
+	sum := sha1.Sum([]byte(password))
+	hash := strings.ToUpper(hex.EncodeToString(sum[:]))
+
+	suffixes, err := breaches.Range(hash[:prefixLength])
+	if err != nil {
+		return 0, err
+	}
+	return suffixes[hash[prefixLength:]], nil
+}
+
+//go:embed breached_passwords.txt
+var bundledList string
+
+// Bundled returns the breach list that ships with the server
+func Bundled() *BreachList {
+	list, err := ParseBreachList(strings.NewReader(bundledList))
+	if err != nil {
+		panic(fmt.Sprintf("bundled breach list: %v", err))
+	}
+	return list
+}
+
+// BreachList keeps a whole breach list in memory, grouped by range
+type BreachList struct {
+	ranges map[string]map[string]int
+}
+
+// LoadBreachList reads a breach list file, see ParseBreachList
+func LoadBreachList(path string) (*BreachList, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open breach list: %v", err)
+	}
+	defer file.Close()
+	return ParseBreachList(file)
+}
+
+// ParseBreachList reads one full SHA-1 hash per line, optionally followed by
+// ":count" as in the Pwned Passwords downloads. Blank lines and lines
+// starting with # are ignored.
+func ParseBreachList(r io.Reader) (*BreachList, error) {
+	list := &BreachList{ranges: make(map[string]map[string]int)}
+	err := scanHashes(r, sha1.Size*2, func(hash string, count int) {
+		prefix, suffix := hash[:prefixLength], hash[prefixLength:]
+		if list.ranges[prefix] == nil {
+			list.ranges[prefix] = make(map[string]int)
+		}
+		list.ranges[prefix][suffix] += count
+	})
+	if err != nil {
+		return nil, err
+	}
+	return list, nil
+}
+
+func (list *BreachList) Range(prefix string) (map[string]int, error) {
+	return list.ranges[strings.ToUpper(prefix)], nil
+}
+
+// BreachDir reads ranges on demand from a directory holding one file per
+// prefix (00000.txt to FFFFF.txt) with "suffix:count" lines, the layout the
+// Pwned Passwords downloader writes. The full dataset never has to fit in
+// memory.
+type BreachDir struct {
+	Dir string
+}
+
+func (d BreachDir) Range(prefix string) (map[string]int, error) {
+	prefix = strings.ToUpper(prefix)
+	if len(prefix) != prefixLength || strings.Trim(prefix, "0123456789ABCDEF") != "" {
+		return nil, fmt.Errorf("invalid range prefix %q", prefix)
+	}
+
+	file, err := os.Open(filepath.Join(d.Dir, prefix+".txt"))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not open breach range: %v", err)
+	}
+	defer file.Close()
+
+	suffixes := make(map[string]int)
+	err = scanHashes(file, sha1.Size*2-prefixLength, func(suffix string, count int) {
+		suffixes[suffix] += count
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", file.Name(), err)
+	}
+	return suffixes, nil
+}
+
+// scanHashes calls fn for every "hash[:count]" line whose hash has the given
+// length. A missing count is taken as 1.
+func scanHashes(r io.Reader, hashLength int, fn func(hash string, count int)) error {
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+
+		hash, countText, hasCount := strings.Cut(text, ":")
+		hash = strings.ToUpper(hash)
+		if len(hash) != hashLength || strings.Trim(hash, "0123456789ABCDEF") != "" {
+			return fmt.Errorf("line %d: %q is not a SHA-1 hash", line, hash)
+		}
+
+		count := 1
+		if hasCount {
+			n, err := strconv.Atoi(countText)
+			if err != nil || n < 1 {
+				return fmt.Errorf("line %d: invalid count %q", line, countText)
+			}
+			count = n
+		}
+		fn(hash, count)
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("could not read breach list: %v", err)
+	}
+	return nil
+}
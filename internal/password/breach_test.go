@@ -0,0 +1,105 @@
+package password
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// Hashes used below: SHA-1 of "password" and "hunter2"
+const (
+	passwordHash = "5BAA61E4C9B93F3F0682250B6CF8331B7EE68FD8"
+	hunter2Hash  = "F3BBBD66A63D4BF1747940578EC3D0103530E21D"
+)
+
+func TestBundledList(t *testing.T) {
+	list := Bundled()
+	for _, breached := range []string{"password", "123456", "qwerty"} {
+		count, err := BreachCount(list, breached)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if count == 0 {
+			t.Errorf("%q is not in the bundled list", breached)
+		}
+	}
+
+	count, err := BreachCount(list, "correct horse battery staple 4471")
+	if err != nil || count != 0 {
+		t.Errorf("a made-up password was seen %d times (%v)", count, err)
+	}
+	// The hash is of the exact password
+	if count, _ := BreachCount(list, "Password "); count != 0 {
+		t.Errorf("a variation of a breached password was seen %d times", count)
+	}
+}
+
+func TestLoadBreachList(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "breaches.txt")
+	content := "# comment\n\n" + passwordHash + ":42\n" + strings.ToLower(hunter2Hash) + "\n" + passwordHash + ":8\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	list, err := LoadBreachList(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]int{"password": 50, "hunter2": 1, "123456": 0}
+	for breached, want := range tests {
+		if got, err := BreachCount(list, breached); err != nil || got != want {
+			t.Errorf("BreachCount(%q) = %d (%v), want %d", breached, got, err, want)
+		}
+	}
+
+	if _, err := LoadBreachList(filepath.Join(t.TempDir(), "missing.txt")); err == nil {
+		t.Error("loaded a missing file")
+	}
+}
+
+func TestParseBreachListRejectsGarbage(t *testing.T) {
+	for _, content := range []string{
+		"not a hash\n",
+		passwordHash[:39] + "\n",
+		strings.Replace(passwordHash, "5", "G", 1) + "\n",
+		passwordHash + ":many\n",
+		passwordHash + ":0\n",
+	} {
+		if _, err := ParseBreachList(strings.NewReader(content)); err == nil {
+			t.Errorf("parsed %q", content)
+		}
+	}
+}
+
+func TestBreachDir(t *testing.T) {
+	dir := t.TempDir()
+	// Range files hold only the part of the hash after the prefix
+	content := passwordHash[prefixLength:] + ":3\n" + "0000000000000000000000000000000000A:1\n"
+	if err := os.WriteFile(filepath.Join(dir, passwordHash[:prefixLength]+".txt"), []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	breaches := BreachDir{Dir: dir}
+
+	if count, err := BreachCount(breaches, "password"); err != nil || count != 3 {
+		t.Errorf("BreachCount(password) = %d (%v), want 3", count, err)
+	}
+	// No file for the range means no breaches in it
+	if count, err := BreachCount(breaches, "hunter2"); err != nil || count != 0 {
+		t.Errorf("BreachCount(hunter2) = %d (%v), want 0", count, err)
+	}
+
+	for _, prefix := range []string{"../00", "5BAA", "5BAA6Z"} {
+		if _, err := breaches.Range(prefix); err == nil {
+			t.Errorf("Range(%q) accepted", prefix)
+		}
+	}
+
+	// A broken range file is an error, not a miss
+	if err := os.WriteFile(filepath.Join(dir, passwordHash[:prefixLength]+".txt"), []byte(passwordHash+"\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := BreachCount(breaches, "password"); err == nil {
+		t.Error("read a range file with full hashes")
+	}
+}
@@ -0,0 +1,120 @@
+// Package password decides which passwords users may choose: a configurable
+// strength policy plus a check against passwords known from data breaches.
+package password
+
+import (
+	"fmt"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// MaxBytes is the longest password bcrypt can hash; anything after it would
+// be silently ignored
+const MaxBytes = 72
+
+// Rules a password can fail, as reported in Violation.Rule
+const (
+	RuleTooShort         = "too_short"
+	RuleTooLong          = "too_long"
+	RuleMissingUppercase = "missing_uppercase"
+	RuleMissingLowercase = "missing_lowercase"
+	RuleMissingDigit     = "missing_digit"
+	RuleMissingSymbol    = "missing_symbol"
+	RuleMatchesEmail     = "matches_email"
+	RuleBreached         = "breached"
+)
+
+// Policy is what a password must look like
+type Policy struct {
+	MinLength     int  // In characters
+	RequireUpper  bool // At least one uppercase letter
+	RequireLower  bool // At least one lowercase letter
+	RequireDigit  bool // At least one digit
+	RequireSymbol bool // At least one character that is not a letter or digit
+	AllowEmail    bool // Allow the email address, or the part before the @, as password
+}
+
+// DefaultPolicy follows current advice: length matters, composition rules
+// mostly produce "Password1!"
+var DefaultPolicy = Policy{MinLength: 8}
+
+// Violation is one rule a password failed
+type Violation struct {
+	Rule    string
+	Message string
+}
+
+// Check returns every rule the password breaks for a user with the given email
+func (p Policy) Check(password, email string) []Violation {
+	var violations []Violation
+
+	if length := utf8.RuneCountInString(password); length < p.MinLength {
+		violations = append(violations, Violation{RuleTooShort, fmt.Sprintf("Password must be at least %d characters long", p.MinLength)})
+	}
+	if len(password) > MaxBytes {
+		violations = append(violations, Violation{RuleTooLong, fmt.Sprintf("Password must be at most %d bytes long", MaxBytes)})
+	}
+
+	var hasUpper, hasLower, hasDigit, hasSymbol bool
+	for _, r := range password {
+		switch {
+		case unicode.IsUpper(r):
+			hasUpper = true
+		case unicode.IsLower(r):
+			hasLower = true
+		case unicode.IsDigit(r):
+			hasDigit = true
+		case !unicode.IsLetter(r) && !unicode.IsSpace(r):
+			hasSymbol = true
+		}
+	}
+	if p.RequireUpper && !hasUpper {
+		violations = append(violations, Violation{RuleMissingUppercase, "Password must contain an uppercase letter"})
+	}
+	if p.RequireLower && !hasLower {
+		violations = append(violations, Violation{RuleMissingLowercase, "Password must contain a lowercase letter"})
+	}
+	if p.RequireDigit && !hasDigit {
+		violations = append(violations, Violation{RuleMissingDigit, "Password must contain a digit"})
+	}
+	if p.RequireSymbol && !hasSymbol {
+		violations = append(violations, Violation{RuleMissingSymbol, "Password must contain a symbol"})
+	}
+
+	if !p.AllowEmail && matchesEmail(password, email) {
+		violations = append(violations, Violation{RuleMatchesEmail, "Password must not be your email address"})
+	}
+	return violations
+}
+
+// matchesEmail reports whether the password is the email address or its
+// local part, ignoring case
+func matchesEmail(password, email string) bool {
+	if email == "" {
+		return false
+	}
+	localPart, _, _ := strings.Cut(email, "@")
+	return strings.EqualFold(password, email) || (localPart != "" && strings.EqualFold(password, localPart))
+}
+
+// ParseClasses turns a comma separated list of character classes (upper,
+// lower, digit, symbol) into the policy's Require fields
+func (p *Policy) ParseClasses(list string) error {
+	for _, class := range strings.Split(list, ",") {
+		switch strings.TrimSpace(class) {
+		case "":
+		case "upper":
+			p.RequireUpper = true
+		case "lower":
+			p.RequireLower = true
+		case "digit":
+			p.RequireDigit = true
+		case "symbol":
+			p.RequireSymbol = true
+		default:
+			return fmt.Errorf("unknown character class %q, use upper, lower, digit or symbol", class)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,85 @@
+package password
+
+import (
+	"slices"
+	"strings"
+	"testing"
+)
+
+// rules returns the rules the violations are for
+func rules(violations []Violation) []string {
+	found := []string{}
+	for _, violation := range violations {
+		found = append(found, violation.Rule)
+	}
+	return found
+}
+
+func TestPolicyCheck(t *testing.T) {
+	strict := Policy{MinLength: 10, RequireUpper: true, RequireLower: true, RequireDigit: true, RequireSymbol: true}
+
+	tests := []struct {
+		name     string
+		policy   Policy
+		password string
+		email    string
+		want     []string
+	}{
+		{"long enough", DefaultPolicy, "correct horse", "", []string{}},
+		{"too short", DefaultPolicy, "short", "", []string{RuleTooShort}},
+		// Length is counted in characters, not bytes
+		{"multibyte characters", DefaultPolicy, "pässwörd", "", []string{}},
+		{"multibyte too short", DefaultPolicy, "ééééééé", "", []string{RuleTooShort}},
+		{"too long for bcrypt", DefaultPolicy, strings.Repeat("a", MaxBytes+1), "", []string{RuleTooLong}},
+		{"just fits bcrypt", DefaultPolicy, strings.Repeat("a", MaxBytes), "", []string{}},
+		{"every class", strict, "Tr0ub4dor&3", "", []string{}},
+		{"no classes", strict, "          ", "", []string{RuleMissingUppercase, RuleMissingLowercase, RuleMissingDigit, RuleMissingSymbol}},
+		{"no uppercase", strict, "tr0ub4dor&3", "", []string{RuleMissingUppercase}},
+		{"no lowercase", strict, "TR0UB4DOR&3", "", []string{RuleMissingLowercase}},
+		{"no digit", strict, "Troubadour&", "", []string{RuleMissingDigit}},
+		// Spaces are not symbols
+		{"no symbol", strict, "Tr0ub4dor 3", "", []string{RuleMissingSymbol}},
+		{"unicode letters count", strict, "Ärger-ölig-9", "", []string{}},
+		{"email", DefaultPolicy, "User@Example.com", "user@example.com", []string{RuleMatchesEmail}},
+		{"email local part", DefaultPolicy, "USERNAME", "username@example.com", []string{RuleMatchesEmail}},
+		{"contains email", DefaultPolicy, "username123", "username@example.com", []string{}},
+		{"email allowed", Policy{MinLength: 8, AllowEmail: true}, "username@example.com", "username@example.com", []string{}},
+		{"no email", DefaultPolicy, "username", "", []string{}},
+		{"several rules", strict, "user", "user@example.com", []string{RuleTooShort, RuleMissingUppercase, RuleMissingDigit, RuleMissingSymbol, RuleMatchesEmail}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			violations := tt.policy.Check(tt.password, tt.email)
+			if got := rules(violations); !slices.Equal(got, tt.want) {
+				t.Errorf("Check = %v, want %v", got, tt.want)
+			}
+			for _, violation := range violations {
+				if violation.Message == "" {
+					t.Errorf("%s has no message", violation.Rule)
+				}
+			}
+		})
+	}
+}
+
+func TestParseClasses(t *testing.T) {
+	var policy Policy
+	if err := policy.ParseClasses(" upper, digit ,,symbol"); err != nil {
+		t.Fatal(err)
+	}
+	want := Policy{RequireUpper: true, RequireDigit: true, RequireSymbol: true}
+	if policy != want {
+		t.Errorf("got %+v, want %+v", policy, want)
+	}
+
+	if err := policy.ParseClasses(""); err != nil || policy != want {
+		t.Errorf("an empty list changed the policy to %+v (%v)", policy, err)
+	}
+
+	for _, list := range []string{"uppercase", "upper,emoji", "UPPER"} {
+		if err := (&Policy{}).ParseClasses(list); err == nil {
+			t.Errorf("ParseClasses(%q) accepted", list)
+		}
+	}
+}
@@ -0,0 +1,239 @@
+package route
+
+import (
+	"archive/zip"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/config"
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/oidc/oidctest"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// postChirp posts a chirp as the user and returns it
+func postChirp(t *testing.T, server *httptest.Server, accessToken, body string) handlers.Chirp {
+	t.Helper()
+	var chirp handlers.Chirp
+	doJSON(t, server, http.MethodPost, "/api/chirps", accessToken, map[string]string{"body": body}, http.StatusCreated, &chirp)
+	return chirp
+}
+
+// searchChirps returns the IDs of the chirps matching the keyword search
+func searchChirps(t *testing.T, server *httptest.Server, q string) []int {
+	t.Helper()
+	var chirps []handlers.Chirp
+	doJSON(t, server, http.MethodGet, "/api/chirps?q="+url.QueryEscape(q), "", nil, http.StatusOK, &chirps)
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+func TestDeleteAccountChirps(t *testing.T) {
+	tests := []struct {
+		mode       string
+		keepChirps bool
+	}{
+		{"", false},
+		{config.DeletedChirpsDelete, false},
+		{config.DeletedChirpsAnonymize, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(fmt.Sprintf("mode %q", tt.mode), func(t *testing.T) {
+			apiCfg := newTestConfig(openTestStore(t))
+			apiCfg.DeletedUserChirps = tt.mode
+			server := serveConfig(t, apiCfg)
+
+			_, leaverToken := createUser(t, server, "leaver@example.com")
+			_, stayerToken := createUser(t, server, "stayer@example.com")
+			leaving := postChirp(t, server, leaverToken, "goodbye kumquat")
+			staying := postChirp(t, server, stayerToken, "hello kumquat")
+
+			doJSON(t, server, http.MethodDelete, "/api/users", leaverToken, map[string]string{}, http.StatusBadRequest, nil)
+			doJSON(t, server, http.MethodDelete, "/api/users", leaverToken, map[string]string{"password": "wrong password"}, http.StatusUnauthorized, nil)
+			doJSON(t, server, http.MethodDelete, "/api/users", leaverToken, map[string]string{"password": testPassword}, http.StatusNoContent, nil)
+
+			// The account and its tokens are gone
+			doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "leaver@example.com", "password": testPassword}, http.StatusUnauthorized, nil)
+			doJSON(t, server, http.MethodPost, "/api/chirps", leaverToken, map[string]string{"body": "still here?"}, http.StatusUnauthorized, nil)
+
+			path := fmt.Sprintf("/api/chirps/%d", leaving.ID)
+			want := []int{leaving.ID, staying.ID}
+			if tt.keepChirps {
+				var kept handlers.Chirp
+				doJSON(t, server, http.MethodGet, path, "", nil, http.StatusOK, &kept)
+				if kept.AuthorID != 0 || kept.Body != leaving.Body {
+					t.Errorf("kept chirp %+v, want the same body with author_id 0", kept)
+				}
+			} else {
+				doJSON(t, server, http.MethodGet, path, "", nil, http.StatusNotFound, nil)
+				want = []int{staying.ID}
+			}
+
+			// Deleted chirps leave the search index too
+			if got := searchChirps(t, server, "kumquat"); !slices.Equal(got, want) {
+				t.Errorf("search found chirps %v, want %v", got, want)
+			}
+			doJSON(t, server, http.MethodGet, fmt.Sprintf("/api/chirps/%d", staying.ID), "", nil, http.StatusOK, nil)
+		})
+	}
+}
+
+func TestDeleteAccountWithProviderLogin(t *testing.T) {
+	ot := newOIDCTest(t)
+	user := oidctest.User{Subject: "no-password", Email: "nopassword@example.com", EmailVerified: true}
+	status, body := ot.login(t, user)
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	accessToken := body["token"].(string)
+	userID := int(body["id"].(float64))
+
+	// The user never chose a password, so there is none to send
+	doJSON(t, ot.server, http.MethodDelete, "/api/users", accessToken, map[string]string{"password": testPassword}, http.StatusUnauthorized, nil)
+
+	// Logging in with a provider account that is not theirs proves nothing
+	authURL, cookie := ot.startReauth(t, accessToken)
+	callbackURL, err := ot.idp.Authorize(authURL, oidctest.User{Subject: "stranger", Email: "stranger@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status, body := ot.callback(t, callbackURL, cookie); status != http.StatusForbidden {
+		t.Errorf("stranger's callback: status %d, want %d: %v", status, http.StatusForbidden, body)
+	}
+	if _, err := ot.store.GetIdentity("test", "stranger"); err == nil {
+		t.Error("re-authenticating linked the stranger's provider account")
+	}
+
+	authURL, cookie = ot.startReauth(t, accessToken)
+	callbackURL, err = ot.idp.Authorize(authURL, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body = ot.callback(t, callbackURL, cookie)
+	if status != http.StatusOK {
+		t.Fatalf("callback: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	reauthToken, _ := body["reauth_token"].(string)
+	if reauthToken == "" || int(body["user_id"].(float64)) != userID {
+		t.Fatalf("unexpected reauth response %v", body)
+	}
+	if _, ok := body["token"]; ok {
+		t.Error("re-authenticating issued an access token")
+	}
+
+	// A reauth token is not an access token, and an access token is not a reauth token
+	doJSON(t, ot.server, http.MethodPost, "/api/chirps", reauthToken, map[string]string{"body": "sneaky"}, http.StatusUnauthorized, nil)
+	doJSON(t, ot.server, http.MethodDelete, "/api/users", accessToken, map[string]string{"reauth_token": accessToken}, http.StatusUnauthorized, nil)
+
+	doJSON(t, ot.server, http.MethodDelete, "/api/users", accessToken, map[string]string{"reauth_token": reauthToken}, http.StatusNoContent, nil)
+	if _, err := ot.store.GetUser(userID); !errors.Is(err, storage.ErrNotFound) {
+		t.Errorf("user lookup after deletion got %v, want %v", err, storage.ErrNotFound)
+	}
+	if _, err := ot.store.GetIdentity("test", user.Subject); err == nil {
+		t.Error("the provider account is still linked")
+	}
+}
+
+func TestReauthTokenIsForOneUser(t *testing.T) {
+	ot := newOIDCTest(t)
+	_, body := ot.login(t, oidctest.User{Subject: "first", Email: "first@example.com", EmailVerified: true})
+	firstToken := body["token"].(string)
+	_, body = ot.login(t, oidctest.User{Subject: "second", Email: "second@example.com", EmailVerified: true})
+	secondToken := body["token"].(string)
+
+	authURL, cookie := ot.startReauth(t, firstToken)
+	callbackURL, err := ot.idp.Authorize(authURL, oidctest.User{Subject: "first", Email: "first@example.com", EmailVerified: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, body = ot.callback(t, callbackURL, cookie)
+	reauthToken, _ := body["reauth_token"].(string)
+
+	doJSON(t, ot.server, http.MethodDelete, "/api/users", secondToken, map[string]string{"reauth_token": reauthToken}, http.StatusUnauthorized, nil)
+	doJSON(t, ot.server, http.MethodDelete, "/api/users", firstToken, map[string]string{"reauth_token": reauthToken}, http.StatusNoContent, nil)
+}
+
+func TestExportUser(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	userID, accessToken := createUser(t, server, "exporter@example.com")
+	createUser(t, server, "someone-else@example.com")
+	chirp := postChirp(t, server, accessToken, "first draft")
+	doJSON(t, server, http.MethodPut, fmt.Sprintf("/api/chirps/%d", chirp.ID), accessToken, map[string]string{"body": "second draft"}, http.StatusOK, nil)
+	apiToken := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite)
+	tokens := login(t, server, "exporter@example.com")
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+"/api/users/me/export", nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	data, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK || resp.Header.Get("Content-Type") != "application/zip" {
+		t.Fatalf("export: status %d, type %q: %s", resp.StatusCode, resp.Header.Get("Content-Type"), data)
+	}
+
+	archive, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		t.Fatalf("not a zip archive: %v", err)
+	}
+	files := map[string][]byte{}
+	for _, f := range archive.File {
+		r, err := f.Open()
+		if err != nil {
+			t.Fatal(err)
+		}
+		files[f.Name], _ = io.ReadAll(r)
+		r.Close()
+	}
+	for _, name := range []string{"profile.json", "chirps.json", "sessions.json", "api_tokens.json", "identities.json"} {
+		if _, ok := files[name]; !ok {
+			t.Errorf("the archive has no %s", name)
+		}
+	}
+
+	var profile map[string]interface{}
+	json.Unmarshal(files["profile.json"], &profile)
+	if int(profile["id"].(float64)) != userID || profile["email"] != "exporter@example.com" {
+		t.Errorf("unexpected profile %v", profile)
+	}
+
+	var chirps []struct {
+		handlers.Chirp
+		Revisions []handlers.ChirpRevision `json:"revisions"`
+	}
+	json.Unmarshal(files["chirps.json"], &chirps)
+	if len(chirps) != 1 || chirps[0].Body != "second draft" || len(chirps[0].Revisions) != 1 || chirps[0].Revisions[0].Body != "first draft" {
+		t.Errorf("unexpected chirps %+v, want the edited chirp with its first draft", chirps)
+	}
+
+	var sessions []handlers.Session
+	json.Unmarshal(files["sessions.json"], &sessions)
+	if len(sessions) != 2 {
+		t.Errorf("got %d sessions, want 2", len(sessions))
+	}
+
+	// No secrets, and nothing about the other user
+	for name, content := range files {
+		for _, secret := range []string{"password", "hash", "totp_secret", apiToken.Token, tokens.RefreshToken, testPassword, "someone-else"} {
+			if strings.Contains(strings.ToLower(string(content)), strings.ToLower(secret)) {
+				t.Errorf("%s contains %q:\n%s", name, secret, content)
+			}
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/config"
+)
+
+// apiToken is a personal API token as POST /api/tokens returns it
+type apiToken struct {
+	ID    int    `json:"id"`
+	Token string `json:"token"`
+}
+
+func createAPIToken(t *testing.T, server *httptest.Server, accessToken string, scopes ...string) apiToken {
+	t.Helper()
+	var token apiToken
+	body := map[string]interface{}{"name": "bot", "scopes": scopes}
+	doJSON(t, server, http.MethodPost, "/api/tokens", accessToken, body, http.StatusCreated, &token)
+	if !strings.HasPrefix(token.Token, "chirpy_pat_") {
+		t.Fatalf("got token %q, want a chirpy_pat_ token", token.Token)
+	}
+	return token
+}
+
+func TestAPITokenScopes(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	_, accessToken := createUser(t, server, "user@example.com")
+	writer := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite)
+	deleter := createAPIToken(t, server, accessToken, config.ScopeChirpsDelete)
+
+	var chirp struct {
+		ID int `json:"id"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/chirps", writer.Token, map[string]string{"body": "from a bot"}, http.StatusCreated, &chirp)
+	doJSON(t, server, http.MethodPost, "/api/chirps", deleter.Token, map[string]string{"body": "from a bot"}, http.StatusForbidden, nil)
+
+	path := fmt.Sprintf("/api/chirps/%d", chirp.ID)
+	doJSON(t, server, http.MethodPut, path, writer.Token, map[string]string{"body": "edited by a bot"}, http.StatusOK, nil)
+	doJSON(t, server, http.MethodPut, path, deleter.Token, map[string]string{"body": "edited by a bot"}, http.StatusForbidden, nil)
+	doJSON(t, server, http.MethodDelete, path, writer.Token, nil, http.StatusForbidden, nil)
+	doJSON(t, server, http.MethodDelete, path, deleter.Token, nil, http.StatusNoContent, nil)
+}
+
+func TestAPITokenRejectedOnLoginOnlyRoutes(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	_, accessToken := createUser(t, server, "user@example.com")
+	token := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite, config.ScopeChirpsDelete)
+
+	routes := []struct {
+		method, path string
+		body         interface{}
+	}{
+		{http.MethodGet, "/api/sessions", nil},
+		{http.MethodPost, "/api/sessions/revoke-all", nil},
+		{http.MethodGet, "/api/tokens", nil},
+		{http.MethodPost, "/api/tokens", map[string]interface{}{"name": "another", "scopes": []string{config.ScopeChirpsWrite}}},
+		{http.MethodDelete, fmt.Sprintf("/api/tokens/%d", token.ID), nil},
+		{http.MethodPut, "/api/users", map[string]string{"email": "bot@example.com", "password": "a new password"}},
+		{http.MethodGet, "/api/users/me/export", nil},
+		{http.MethodPost, "/api/logout", nil},
+	}
+	for _, route := range routes {
+		doJSON(t, server, route.method, route.path, token.Token, route.body, http.StatusForbidden, nil)
+	}
+
+	// None of the above went through
+	doJSON(t, server, http.MethodPost, "/api/chirps", token.Token, map[string]string{"body": "still works"}, http.StatusCreated, nil)
+}
+
+func TestAPITokenRevoked(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	_, accessToken := createUser(t, server, "user@example.com")
+	revoked := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite)
+	kept := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite)
+	chirp := map[string]string{"body": "from a bot"}
+
+	doJSON(t, server, http.MethodDelete, fmt.Sprintf("/api/tokens/%d", revoked.ID), accessToken, nil, http.StatusNoContent, nil)
+	doJSON(t, server, http.MethodDelete, fmt.Sprintf("/api/tokens/%d", revoked.ID), accessToken, nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodPost, "/api/chirps", revoked.Token, chirp, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/chirps", kept.Token, chirp, http.StatusCreated, nil)
+
+	var listed []apiToken
+	doJSON(t, server, http.MethodGet, "/api/tokens", accessToken, nil, http.StatusOK, &listed)
+	if len(listed) != 1 || listed[0].ID != kept.ID || listed[0].Token != "" {
+		t.Errorf("GET /api/tokens = %+v, want only token %d without its secret", listed, kept.ID)
+	}
+
+	// Other users cannot revoke the token
+	_, otherToken := createUser(t, server, "other@example.com")
+	doJSON(t, server, http.MethodDelete, fmt.Sprintf("/api/tokens/%d", kept.ID), otherToken, nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodPost, "/api/chirps", kept.Token, chirp, http.StatusCreated, nil)
+}
+
+func TestAPITokenRevokedByPasswordChange(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	_, accessToken := createUser(t, server, "user@example.com")
+	token := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite)
+	chirp := map[string]string{"body": "from a bot"}
+
+	var updated struct {
+		Token string `json:"token"`
+	}
+	doJSON(t, server, http.MethodPut, "/api/users", accessToken, map[string]string{"email": "user@example.com", "password": "a new password"}, http.StatusOK, &updated)
+	doJSON(t, server, http.MethodPost, "/api/chirps", token.Token, chirp, http.StatusUnauthorized, nil)
+
+	// Tokens created after the change work
+	token = createAPIToken(t, server, updated.Token, config.ScopeChirpsWrite)
+	doJSON(t, server, http.MethodPost, "/api/chirps", token.Token, chirp, http.StatusCreated, nil)
+}
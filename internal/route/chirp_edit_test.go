@@ -0,0 +1,120 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/config"
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/wordfilter"
+)
+
+// revisions returns the chirp's earlier bodies, oldest first
+func revisions(t *testing.T, server *httptest.Server, chirpID int) []handlers.ChirpRevision {
+	t.Helper()
+	var revisions []handlers.ChirpRevision
+	doJSON(t, server, http.MethodGet, fmt.Sprintf("/api/chirps/%d/revisions", chirpID), "", nil, http.StatusOK, &revisions)
+	return revisions
+}
+
+func TestEditChirp(t *testing.T) {
+	apiCfg := newTestConfig(openTestStore(t))
+	apiCfg.WordFilter = wordfilter.New(wordfilter.DefaultWords)
+	server := serveConfig(t, apiCfg)
+	_, authorToken := createUser(t, server, "author@example.com")
+	chirp := postChirp(t, server, authorToken, "first draft")
+	path := fmt.Sprintf("/api/chirps/%d", chirp.ID)
+
+	if got := revisions(t, server, chirp.ID); got == nil || len(got) != 0 {
+		t.Errorf("unedited chirp has revisions %v, want []", got)
+	}
+
+	var edited handlers.Chirp
+	doJSON(t, server, http.MethodPut, path, authorToken, map[string]string{"body": "second kerfuffle draft"}, http.StatusOK, &edited)
+	// Edits go through the word filter like new chirps
+	if edited.Body != "second **** draft" || !edited.Edited || !edited.CreatedAt.Equal(chirp.CreatedAt) || !edited.UpdatedAt.After(chirp.UpdatedAt) {
+		t.Errorf("edited chirp %+v, want the masked body, edited and a later updated_at", edited)
+	}
+	doJSON(t, server, http.MethodPatch, path, authorToken, map[string]string{"body": "third draft"}, http.StatusOK, nil)
+
+	var got handlers.Chirp
+	doJSON(t, server, http.MethodGet, path, "", nil, http.StatusOK, &got)
+	if got.Body != "third draft" || !got.Edited {
+		t.Errorf("stored chirp %+v, want the third draft marked edited", got)
+	}
+	history := revisions(t, server, chirp.ID)
+	if len(history) != 2 ||
+		history[0].Revision != 1 || history[0].Body != "first draft" || !history[0].CreatedAt.Equal(chirp.CreatedAt) ||
+		history[1].Revision != 2 || history[1].Body != "second **** draft" || !history[1].CreatedAt.Equal(edited.UpdatedAt) {
+		t.Errorf("revisions %+v, want the first and second drafts with when each was published", history)
+	}
+
+	// Search finds the chirp by its current body only
+	if ids := searchChirps(t, server, "third"); len(ids) != 1 || ids[0] != chirp.ID {
+		t.Errorf("search for the new body found %v, want [%d]", ids, chirp.ID)
+	}
+	if ids := searchChirps(t, server, "first"); len(ids) != 0 {
+		t.Errorf("search for the old body found %v", ids)
+	}
+
+	doJSON(t, server, http.MethodGet, "/api/chirps/9999/revisions", "", nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodGet, "/api/chirps/abc/revisions", "", nil, http.StatusNotFound, nil)
+}
+
+func TestEditChirpRejected(t *testing.T) {
+	apiCfg := newTestConfig(openTestStore(t))
+	apiCfg.WordFilter = wordfilter.New(wordfilter.DefaultWords)
+	apiCfg.WordFilterMode = config.WordFilterReject
+	server := serveConfig(t, apiCfg)
+	authorID, authorToken := createUser(t, server, "author@example.com")
+	_, otherToken := createUser(t, server, "other@example.com")
+	chirp := postChirp(t, server, authorToken, "the original")
+	path := fmt.Sprintf("/api/chirps/%d", chirp.ID)
+
+	// Only the author can edit, whatever the method
+	for _, method := range []string{http.MethodPut, http.MethodPatch} {
+		doJSON(t, server, method, path, otherToken, map[string]string{"body": "hijacked"}, http.StatusForbidden, nil)
+		doJSON(t, server, method, path, "", map[string]string{"body": "hijacked"}, http.StatusUnauthorized, nil)
+	}
+	doJSON(t, server, http.MethodPut, "/api/chirps/9999", authorToken, map[string]string{"body": "missing"}, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodPut, "/api/chirps/abc", authorToken, map[string]string{"body": "missing"}, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodPut, path, authorToken, map[string]string{}, http.StatusBadRequest, nil)
+
+	// Edits follow the rules for new chirps
+	tests := []struct {
+		body string
+		code string
+	}{
+		{"   ", "required"},
+		{strings.Repeat("é", config.DefaultChirpMaxLength+1), "too_long"},
+		{"what a kerfuffle", "banned_words"},
+	}
+	for _, tt := range tests {
+		var resp struct {
+			Errors []struct {
+				Field string `json:"field"`
+				Code  string `json:"code"`
+			} `json:"errors"`
+		}
+		doJSON(t, server, http.MethodPut, path, authorToken, map[string]string{"body": tt.body}, http.StatusBadRequest, &resp)
+		if len(resp.Errors) != 1 || resp.Errors[0].Field != "body" || resp.Errors[0].Code != tt.code {
+			t.Errorf("editing to %q: got errors %+v, want body %s", tt.body, resp.Errors, tt.code)
+		}
+	}
+	doJSON(t, server, http.MethodPut, path, authorToken, map[string]string{"body": strings.Repeat("é", config.DefaultChirpMaxLength)}, http.StatusOK, nil)
+
+	// Unverified authors cannot edit once verification is required
+	apiCfg.RequireVerifiedEmail = true
+	doJSON(t, server, http.MethodPatch, path, authorToken, map[string]string{"body": "unverified"}, http.StatusForbidden, nil)
+
+	// Only the one accepted edit made it into the history
+	if history := revisions(t, server, chirp.ID); len(history) != 1 || history[0].Body != "the original" {
+		t.Errorf("revisions %+v, want just the original", history)
+	}
+	if got, err := apiCfg.Store.GetChirp(chirp.ID); err != nil || got.AuthorID != authorID || got.Body != strings.Repeat("é", config.DefaultChirpMaxLength) {
+		t.Errorf("stored chirp %+v (%v), want the accepted edit", got, err)
+	}
+}
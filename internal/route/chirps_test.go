@@ -0,0 +1,312 @@
+package route
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// nextLinkPattern reads the next page out of a Link header
+var nextLinkPattern = regexp.MustCompile(`^<([^>]+)>; rel="next"$`)
+
+// getChirps fetches one page of GET /api/chirps and returns it with the path
+// of the next page, or "" on the last one
+func getChirps(t *testing.T, server *httptest.Server, path string) ([]handlers.Chirp, string) {
+	t.Helper()
+	resp, err := server.Client().Get(server.URL + path)
+	if err != nil {
+		t.Fatalf("GET %s: %v", path, err)
+	}
+	defer resp.Body.Close()
+	body, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("GET %s: status %d, want %d: %s", path, resp.StatusCode, http.StatusOK, body)
+	}
+
+	var chirps []handlers.Chirp
+	if err := json.Unmarshal(body, &chirps); err != nil {
+		t.Fatalf("GET %s: could not decode response: %v", path, err)
+	}
+	next := ""
+	if link := resp.Header.Get("Link"); link != "" {
+		match := nextLinkPattern.FindStringSubmatch(link)
+		if match == nil {
+			t.Fatalf("GET %s: malformed Link header %q", path, link)
+		}
+		next = match[1]
+	}
+	return chirps, next
+}
+
+// chirpIDs returns the IDs of the chirps in order
+func chirpIDs(chirps []handlers.Chirp) []int {
+	ids := []int{}
+	for _, chirp := range chirps {
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+// fillChirps stores count chirps by the author straight in the store
+func fillChirps(t *testing.T, store storage.Store, authorID, count int) []int {
+	t.Helper()
+	ids := []int{}
+	for i := 0; i < count; i++ {
+		chirp, err := store.CreateChirp(handlers.Chirp{Body: fmt.Sprintf("chirp %d", i), AuthorID: authorID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, chirp.ID)
+	}
+	return ids
+}
+
+func TestChirpsPagination(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	authorID, _ := createUser(t, server, "author@example.com")
+	otherID, _ := createUser(t, server, "other@example.com")
+	fillChirps(t, store, otherID, 2)
+	want := fillChirps(t, store, authorID, 7)
+	reversed := slices.Clone(want)
+	slices.Reverse(reversed)
+
+	tests := []struct {
+		name  string
+		path  string
+		want  []int
+		pages []int
+	}{
+		{"oldest first", "/api/chirps?author_id=%d&limit=3", want, []int{3, 3, 1}},
+		{"newest first", "/api/chirps?author_id=%d&limit=3&sort=desc", reversed, []int{3, 3, 1}},
+		{"exact pages", "/api/chirps?author_id=%d&limit=7", want, []int{7}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := []int{}
+			pages := []int{}
+			// The next link keeps the filter, the order and the limit
+			for path := fmt.Sprintf(tt.path, authorID); path != ""; {
+				var chirps []handlers.Chirp
+				chirps, path = getChirps(t, server, path)
+				got = append(got, chirpIDs(chirps)...)
+				pages = append(pages, len(chirps))
+			}
+			if !slices.Equal(got, tt.want) || !slices.Equal(pages, tt.pages) {
+				t.Errorf("got chirps %v in pages %v, want %v in pages %v", got, pages, tt.want, tt.pages)
+			}
+		})
+	}
+}
+
+func TestChirpsPaginationStable(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	authorID, _ := createUser(t, server, "author@example.com")
+	first := fillChirps(t, store, authorID, 4)
+
+	chirps, next := getChirps(t, server, "/api/chirps?limit=2&sort=desc")
+	if got := chirpIDs(chirps); !slices.Equal(got, []int{first[3], first[2]}) {
+		t.Fatalf("first page %v, want %v", got, []int{first[3], first[2]})
+	}
+
+	// New chirps and deleted ones do not shift the pages that follow
+	fillChirps(t, store, authorID, 3)
+	if err := store.DeleteChirp(first[2]); err != nil {
+		t.Fatal(err)
+	}
+	chirps, next = getChirps(t, server, next)
+	if got := chirpIDs(chirps); !slices.Equal(got, []int{first[1], first[0]}) {
+		t.Errorf("second page %v, want %v", got, []int{first[1], first[0]})
+	}
+	if next != "" {
+		t.Errorf("got a next page %q after the oldest chirp", next)
+	}
+}
+
+func TestChirpsPageSize(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	authorID, _ := createUser(t, server, "author@example.com")
+	fillChirps(t, store, authorID, 120)
+
+	tests := []struct {
+		path     string
+		want     int
+		wantNext bool
+	}{
+		{"/api/chirps", 50, true},
+		{"/api/chirps?limit=1", 1, true},
+		{"/api/chirps?limit=100", 100, true},
+		// Larger pages are cut down to the maximum
+		{"/api/chirps?limit=1000", 100, true},
+		{"/api/chirps?limit=120", 100, true},
+		{"/api/chirps?author_id=9999", 0, false},
+	}
+	for _, tt := range tests {
+		chirps, next := getChirps(t, server, tt.path)
+		if len(chirps) != tt.want || (next != "") != tt.wantNext {
+			t.Errorf("GET %s: got %d chirps and next page %q, want %d chirps and a next page: %v", tt.path, len(chirps), next, tt.want, tt.wantNext)
+		}
+	}
+
+	for _, limit := range []string{"0", "-1", "ten", "1.5"} {
+		doJSON(t, server, http.MethodGet, "/api/chirps?limit="+limit, "", nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestChirpsInvalidCursor(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	authorID, _ := createUser(t, server, "author@example.com")
+	fillChirps(t, store, authorID, 3)
+
+	encode := func(raw string) string { return base64.RawURLEncoding.EncodeToString([]byte(raw)) }
+	for _, cursor := range []string{
+		"not base64!",
+		encode("garbage"),
+		encode("at:yesterday:1"),
+		encode("at:1725284504000000:0"),
+		encode("at:1725284504000000"),
+		encode("offset:0"),
+		encode("offset:-3"),
+		// A search cursor for a listing
+		encode("offset:2"),
+	} {
+		doJSON(t, server, http.MethodGet, "/api/chirps?cursor="+cursor, "", nil, http.StatusBadRequest, nil)
+	}
+
+	// And a listing cursor for a search
+	_, next := getChirps(t, server, "/api/chirps?limit=1")
+	cursor := next[strings.Index(next, "cursor="):]
+	doJSON(t, server, http.MethodGet, "/api/chirps?q=chirp&"+cursor, "", nil, http.StatusBadRequest, nil)
+}
+
+func TestChirpsSearchPagination(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	_, accessToken := createUser(t, server, "author@example.com")
+	want := []int{}
+	for i := 0; i < 5; i++ {
+		want = append(want, postChirp(t, server, accessToken, fmt.Sprintf("kumquat number %d", i)).ID)
+	}
+	postChirp(t, server, accessToken, "nothing to see")
+
+	got := []int{}
+	for path := "/api/chirps?q=kumquat&limit=2"; path != ""; {
+		var chirps []handlers.Chirp
+		chirps, path = getChirps(t, server, path)
+		if len(chirps) > 2 {
+			t.Errorf("got a page of %d, want at most 2", len(chirps))
+		}
+		got = append(got, chirpIDs(chirps)...)
+	}
+	slices.Sort(got)
+	if !slices.Equal(got, want) {
+		t.Errorf("search found %v, want each of %v once", got, want)
+	}
+}
+
+func TestChirpsTimeRange(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	authorID, _ := createUser(t, server, "author@example.com")
+	chirps := []handlers.Chirp{}
+	for i := 0; i < 5; i++ {
+		chirp, err := store.CreateChirp(handlers.Chirp{Body: fmt.Sprintf("chirp %d", i), AuthorID: authorID})
+		if err != nil {
+			t.Fatal(err)
+		}
+		chirps = append(chirps, chirp)
+		// Keep the timestamps apart
+		time.Sleep(time.Millisecond)
+	}
+	at := func(i int) string { return chirps[i].CreatedAt.Format(time.RFC3339Nano) }
+	ids := func(indexes ...int) []int {
+		ids := []int{}
+		for _, i := range indexes {
+			ids = append(ids, chirps[i].ID)
+		}
+		return ids
+	}
+
+	// Since is inclusive and until exclusive
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		{"since=" + at(1), ids(1, 2, 3, 4)},
+		{"until=" + at(3), ids(0, 1, 2)},
+		{"since=" + at(1) + "&until=" + at(3), ids(1, 2)},
+		{"since=" + at(1) + "&until=" + at(3) + "&sort=desc", ids(2, 1)},
+		{"since=" + at(2) + "&until=" + at(2), ids()},
+		{"since=" + at(3) + "&until=" + at(1), ids()},
+		// Any offset is fine
+		{"since=" + chirps[1].CreatedAt.In(time.FixedZone("", 2*60*60)).Format(time.RFC3339Nano), ids(1, 2, 3, 4)},
+		{"since=2000-01-01T00:00:00Z&until=2000-01-02T00:00:00Z", ids()},
+	}
+	for _, tt := range tests {
+		path := "/api/chirps?" + strings.ReplaceAll(tt.query, "+", "%2B")
+		got, _ := getChirps(t, server, path)
+		if !slices.Equal(chirpIDs(got), tt.want) {
+			t.Errorf("GET %s: got %v, want %v", path, chirpIDs(got), tt.want)
+		}
+	}
+
+	// Pages keep to the range
+	got := []int{}
+	for path := "/api/chirps?limit=1&sort=desc&since=" + at(1) + "&until=" + at(4); path != ""; {
+		var page []handlers.Chirp
+		page, path = getChirps(t, server, path)
+		got = append(got, chirpIDs(page)...)
+	}
+	if !slices.Equal(got, ids(3, 2, 1)) {
+		t.Errorf("paging through the range got %v, want %v", got, ids(3, 2, 1))
+	}
+
+	for _, query := range []string{"since=yesterday", "until=2024-09-01", "since=1725284504", "until=2024-09-01T00:00:00"} {
+		doJSON(t, server, http.MethodGet, "/api/chirps?"+query, "", nil, http.StatusBadRequest, nil)
+	}
+}
+
+func TestChirpTimestamps(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	before := time.Now().UTC().Add(-time.Second)
+
+	var user struct {
+		CreatedAt time.Time `json:"created_at"`
+		UpdatedAt time.Time `json:"updated_at"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/users", "", map[string]string{"email": "author@example.com", "password": testPassword}, http.StatusCreated, &user)
+	if user.CreatedAt.Before(before) || !user.UpdatedAt.Equal(user.CreatedAt) {
+		t.Errorf("new user created at %v and updated at %v, want now for both", user.CreatedAt, user.UpdatedAt)
+	}
+
+	accessToken := login(t, server, "author@example.com").Token
+	chirp := postChirp(t, server, accessToken, "hello")
+	if chirp.CreatedAt.Before(before) || !chirp.UpdatedAt.Equal(chirp.CreatedAt) {
+		t.Errorf("new chirp created at %v and updated at %v, want now for both", chirp.CreatedAt, chirp.UpdatedAt)
+	}
+
+	// The timestamps are the server's to set
+	var got handlers.Chirp
+	doJSON(t, server, http.MethodGet, fmt.Sprintf("/api/chirps/%d", chirp.ID), "", nil, http.StatusOK, &got)
+	if !got.CreatedAt.Equal(chirp.CreatedAt) || !got.UpdatedAt.Equal(chirp.UpdatedAt) {
+		t.Errorf("stored chirp has %v and %v, want %v and %v", got.CreatedAt, got.UpdatedAt, chirp.CreatedAt, chirp.UpdatedAt)
+	}
+	doJSON(t, server, http.MethodPost, "/api/chirps", accessToken, map[string]string{"body": "from the past", "created_at": "2000-01-01T00:00:00Z"}, http.StatusCreated, &got)
+	if got.CreatedAt.Before(before) {
+		t.Errorf("the client set created_at to %v", got.CreatedAt)
+	}
+}
@@ -0,0 +1,46 @@
+package route
+
+import (
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/lockout"
+	"github.com/RichardHoa/go-server/internal/storage"
+	"github.com/RichardHoa/go-server/internal/totp"
+)
+
+func TestConfirmTOTPCountsFailures(t *testing.T) {
+	store, err := storage.Open("json", filepath.Join(t.TempDir(), "database"))
+	if err != nil {
+		t.Fatalf("could not open store: %v", err)
+	}
+	defer closeStore(t, store)
+
+	apiCfg := newTestConfig(store)
+	apiCfg.EmailLockout = lockout.New(lockout.Policy{FreeAttempts: 2, BaseDelay: time.Minute, MaxDelay: time.Hour, Forget: time.Hour})
+	server := serveConfig(t, apiCfg)
+	_, accessToken := createUser(t, server, "user@example.com")
+
+	var enrolment struct {
+		Secret string `json:"secret"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/users/mfa/totp", accessToken, nil, http.StatusOK, &enrolment)
+
+	// A code from an hour ago is never valid now
+	wrongCode, err := totp.Code(enrolment.Secret, time.Now().Add(-time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for range 3 {
+		doJSON(t, server, http.MethodPost, "/api/users/mfa/totp/confirm", accessToken, map[string]string{"code": wrongCode}, http.StatusUnauthorized, nil)
+	}
+
+	// Once locked, even the right code has to wait
+	code, err := totp.Code(enrolment.Secret, time.Now())
+	if err != nil {
+		t.Fatal(err)
+	}
+	doJSON(t, server, http.MethodPost, "/api/users/mfa/totp/confirm", accessToken, map[string]string{"code": code}, http.StatusTooManyRequests, nil)
+}
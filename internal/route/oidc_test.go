@@ -0,0 +1,302 @@
+package route
+
+import (
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"path/filepath"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/oidc"
+	"github.com/RichardHoa/go-server/internal/oidc/oidctest"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// oidcTest is a server with one login provider, "test", played by idp
+type oidcTest struct {
+	server *httptest.Server
+	idp    *oidctest.Server
+	store  storage.Store
+}
+
+func newOIDCTest(t *testing.T) *oidcTest {
+	t.Helper()
+	store, err := storage.Open("json", filepath.Join(t.TempDir(), "database"))
+	if err != nil {
+		t.Fatalf("could not open store: %v", err)
+	}
+	t.Cleanup(func() { closeStore(t, store) })
+
+	idp, err := oidctest.NewServer("chirpy", "client-secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(idp.Close)
+
+	apiCfg := newTestConfig(store)
+	server := serveConfig(t, apiCfg)
+	apiCfg.OIDCProviders = map[string]*oidc.Provider{
+		"test": {
+			Name:         "test",
+			Issuer:       idp.URL,
+			ClientID:     idp.ClientID,
+			ClientSecret: idp.ClientSecret,
+			RedirectURL:  server.URL + "/api/oidc/test/callback",
+			HTTPClient:   idp.Client(),
+		},
+	}
+	return &oidcTest{server: server, idp: idp, store: store}
+}
+
+// do sends the request without following redirects
+func (ot *oidcTest) do(t *testing.T, req *http.Request) (*http.Response, map[string]interface{}) {
+	t.Helper()
+	client := &http.Client{
+		CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse },
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		t.Fatalf("%s %s: %v", req.Method, req.URL.Path, err)
+	}
+	defer resp.Body.Close()
+
+	body := map[string]interface{}{}
+	respBody, _ := io.ReadAll(resp.Body)
+	json.Unmarshal(respBody, &body)
+	return resp, body
+}
+
+// startLogin starts a login and returns the provider URL and state cookie
+func (ot *oidcTest) startLogin(t *testing.T) (string, *http.Cookie) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, ot.server.URL+"/api/oidc/test/login", nil)
+	resp, _ := ot.do(t, req)
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("login: status %d, want %d", resp.StatusCode, http.StatusFound)
+	}
+	return resp.Header.Get("Location"), stateCookie(t, resp)
+}
+
+// startLink starts linking the provider to the user with the access token
+func (ot *oidcTest) startLink(t *testing.T, accessToken string) (string, *http.Cookie) {
+	t.Helper()
+	return ot.startUserFlow(t, "link", accessToken)
+}
+
+// startReauth starts a fresh provider login for the user with the access token
+func (ot *oidcTest) startReauth(t *testing.T, accessToken string) (string, *http.Cookie) {
+	t.Helper()
+	return ot.startUserFlow(t, "reauth", accessToken)
+}
+
+// startUserFlow starts a flow for a logged-in user, link or reauth
+func (ot *oidcTest) startUserFlow(t *testing.T, purpose, accessToken string) (string, *http.Cookie) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodPost, ot.server.URL+"/api/oidc/test/"+purpose, nil)
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, body := ot.do(t, req)
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("%s: status %d, want %d: %v", purpose, resp.StatusCode, http.StatusOK, body)
+	}
+	authURL, _ := body["authorization_url"].(string)
+	return authURL, stateCookie(t, resp)
+}
+
+// callback plays the browser coming back from the provider
+func (ot *oidcTest) callback(t *testing.T, callbackURL string, cookie *http.Cookie) (int, map[string]interface{}) {
+	t.Helper()
+	req, _ := http.NewRequest(http.MethodGet, callbackURL, nil)
+	if cookie != nil {
+		req.AddCookie(cookie)
+	}
+	resp, body := ot.do(t, req)
+	return resp.StatusCode, body
+}
+
+// login logs in with the provider account from start to finish
+func (ot *oidcTest) login(t *testing.T, user oidctest.User) (int, map[string]interface{}) {
+	t.Helper()
+	authURL, cookie := ot.startLogin(t)
+	callbackURL, err := ot.idp.Authorize(authURL, user)
+	if err != nil {
+		t.Fatalf("provider rejected authorization URL: %v", err)
+	}
+	return ot.callback(t, callbackURL, cookie)
+}
+
+func stateCookie(t *testing.T, resp *http.Response) *http.Cookie {
+	t.Helper()
+	for _, cookie := range resp.Cookies() {
+		if cookie.Name == "chirpy_oidc_state" {
+			return cookie
+		}
+	}
+	t.Fatal("no state cookie set")
+	return nil
+}
+
+// createUser signs up with a password and returns the user ID and access token
+func createUser(t *testing.T, server *httptest.Server, email string) (int, string) {
+	t.Helper()
+	var created struct {
+		ID int `json:"id"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/users", "", map[string]string{"email": email, "password": testPassword}, http.StatusCreated, &created)
+	var login struct {
+		Token string `json:"token"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": email, "password": testPassword}, http.StatusOK, &login)
+	return created.ID, login.Token
+}
+
+func TestOIDCLoginCreatesUser(t *testing.T) {
+	ot := newOIDCTest(t)
+	user := oidctest.User{Subject: "new-user", Email: "new@example.com", EmailVerified: true}
+
+	status, body := ot.login(t, user)
+	if status != http.StatusOK {
+		t.Fatalf("callback: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if body["email"] != user.Email || body["email_verified"] != true || body["token"] == nil {
+		t.Errorf("unexpected login response %v", body)
+	}
+	userID := int(body["id"].(float64))
+
+	identity, err := ot.store.GetIdentity("test", user.Subject)
+	if err != nil {
+		t.Fatalf("identity not stored: %v", err)
+	}
+	if identity.UserID != userID {
+		t.Errorf("identity linked to user %d, want %d", identity.UserID, userID)
+	}
+
+	// The next login finds the same user
+	status, body = ot.login(t, user)
+	if status != http.StatusOK {
+		t.Fatalf("second callback: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if got := int(body["id"].(float64)); got != userID {
+		t.Errorf("second login got user %d, want %d", got, userID)
+	}
+}
+
+func TestOIDCCallbackChecksState(t *testing.T) {
+	ot := newOIDCTest(t)
+	user := oidctest.User{Subject: "someone", Email: "someone@example.com", EmailVerified: true}
+
+	t.Run("state does not match", func(t *testing.T) {
+		authURL, cookie := ot.startLogin(t)
+		callbackURL, err := ot.idp.Authorize(authURL, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		parsed, _ := url.Parse(callbackURL)
+		query := parsed.Query()
+		query.Set("state", "forged")
+		parsed.RawQuery = query.Encode()
+
+		if status, body := ot.callback(t, parsed.String(), cookie); status != http.StatusBadRequest {
+			t.Errorf("status %d, want %d: %v", status, http.StatusBadRequest, body)
+		}
+	})
+
+	t.Run("cookie from another login", func(t *testing.T) {
+		authURL, _ := ot.startLogin(t)
+		_, otherCookie := ot.startLogin(t)
+		callbackURL, err := ot.idp.Authorize(authURL, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, body := ot.callback(t, callbackURL, otherCookie); status != http.StatusBadRequest {
+			t.Errorf("status %d, want %d: %v", status, http.StatusBadRequest, body)
+		}
+	})
+
+	t.Run("no cookie", func(t *testing.T) {
+		authURL, _ := ot.startLogin(t)
+		callbackURL, err := ot.idp.Authorize(authURL, user)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status, body := ot.callback(t, callbackURL, nil); status != http.StatusBadRequest {
+			t.Errorf("status %d, want %d: %v", status, http.StatusBadRequest, body)
+		}
+	})
+
+	if _, err := ot.store.GetIdentity("test", user.Subject); err == nil {
+		t.Error("a rejected callback linked the identity")
+	}
+}
+
+func TestOIDCLink(t *testing.T) {
+	ot := newOIDCTest(t)
+	userID, accessToken := createUser(t, ot.server, "linker@example.com")
+	// The provider account may use a different email
+	user := oidctest.User{Subject: "linker", Email: "linker@provider.example", EmailVerified: true}
+
+	authURL, cookie := ot.startLink(t, accessToken)
+	callbackURL, err := ot.idp.Authorize(authURL, user)
+	if err != nil {
+		t.Fatal(err)
+	}
+	status, body := ot.callback(t, callbackURL, cookie)
+	if status != http.StatusOK {
+		t.Fatalf("callback: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if body["linked"] != true || int(body["user_id"].(float64)) != userID {
+		t.Errorf("unexpected link response %v", body)
+	}
+
+	// Logging in with the provider now logs in the linked user
+	status, body = ot.login(t, user)
+	if status != http.StatusOK {
+		t.Fatalf("login: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if got := int(body["id"].(float64)); got != userID {
+		t.Errorf("login got user %d, want %d", got, userID)
+	}
+}
+
+func TestOIDCLoginExistingEmail(t *testing.T) {
+	ot := newOIDCTest(t)
+	createUser(t, ot.server, "taken@example.com")
+
+	status, body := ot.login(t, oidctest.User{Subject: "intruder", Email: "taken@example.com", EmailVerified: true})
+	if status != http.StatusConflict {
+		t.Errorf("status %d, want %d: %v", status, http.StatusConflict, body)
+	}
+	if _, err := ot.store.GetIdentity("test", "intruder"); err == nil {
+		t.Error("the identity was linked to the existing account")
+	}
+}
+
+func TestOIDCLoginMFAChallenge(t *testing.T) {
+	ot := newOIDCTest(t)
+	userID, _ := createUser(t, ot.server, "careful@example.com")
+	_, err := ot.store.UpdateUser(userID, func(user *handlers.User) error {
+		user.TOTPSecret = "JBSWY3DPEHPK3PXP"
+		user.TOTPEnabled = true
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = ot.store.LinkIdentity(handlers.Identity{Provider: "test", Subject: "careful", Email: "careful@example.com", UserID: userID})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	status, body := ot.login(t, oidctest.User{Subject: "careful", Email: "careful@example.com", EmailVerified: true})
+	if status != http.StatusOK {
+		t.Fatalf("callback: status %d, want %d: %v", status, http.StatusOK, body)
+	}
+	if body["mfa_required"] != true || body["mfa_token"] == nil {
+		t.Errorf("expected an MFA challenge, got %v", body)
+	}
+	if _, ok := body["token"]; ok {
+		t.Error("callback issued an access token before the second factor")
+	}
+}
@@ -0,0 +1,162 @@
+package route
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"net/http/httptest"
+	"regexp"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/config"
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/mail"
+)
+
+// captureMailer keeps every message instead of sending it
+type captureMailer struct {
+	mu       sync.Mutex
+	messages []mail.Message
+}
+
+func (m *captureMailer) Send(msg mail.Message) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.messages = append(m.messages, msg)
+	return nil
+}
+
+// resets returns the password reset emails sent so far. Signing up sends a
+// verification email too.
+func (m *captureMailer) resets() []mail.Message {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	var resets []mail.Message
+	for _, msg := range m.messages {
+		if msg.Subject == "Reset your Chirpy password" {
+			resets = append(resets, msg)
+		}
+	}
+	return resets
+}
+
+// resetTokenPattern finds the token in a password reset email
+var resetTokenPattern = regexp.MustCompile(`\b[0-9a-f]{64}\b`)
+
+// requestResetToken asks for a password reset and returns the emailed token
+func requestResetToken(t *testing.T, server *httptest.Server, mailer *captureMailer, email string) string {
+	t.Helper()
+	requestReset(t, server, email)
+	resets := mailer.resets()
+	if len(resets) == 0 || resets[len(resets)-1].To != email {
+		t.Fatalf("sent %+v, want a reset to %s", resets, email)
+	}
+	resetToken := resetTokenPattern.FindString(resets[len(resets)-1].Body)
+	if resetToken == "" {
+		t.Fatalf("no reset token in %q", resets[len(resets)-1].Body)
+	}
+	return resetToken
+}
+
+// requestReset asks for a password reset and returns the response body
+func requestReset(t *testing.T, server *httptest.Server, email string) map[string]interface{} {
+	t.Helper()
+	var resp map[string]interface{}
+	doJSON(t, server, http.MethodPost, "/api/password-reset/request", "", map[string]string{"email": email}, http.StatusAccepted, &resp)
+	return resp
+}
+
+// newResetServer serves the routes with a mailer the test can read
+func newResetServer(t *testing.T) (*config.ApiConfig, *httptest.Server, *captureMailer) {
+	t.Helper()
+	mailer := &captureMailer{}
+	apiCfg := newTestConfig(openTestStore(t))
+	apiCfg.Mailer = mailer
+	return apiCfg, serveConfig(t, apiCfg), mailer
+}
+
+func TestPasswordReset(t *testing.T) {
+	_, server, mailer := newResetServer(t)
+	_, accessToken := createUser(t, server, "user@example.com")
+	tokens := login(t, server, "user@example.com")
+	apiToken := createAPIToken(t, server, accessToken, config.ScopeChirpsWrite)
+
+	resetToken := requestResetToken(t, server, mailer, "user@example.com")
+
+	newPassword := map[string]string{"token": resetToken, "password": "a brand new password"}
+	doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", map[string]string{"token": "not-a-token", "password": "a brand new password"}, http.StatusBadRequest, nil)
+	doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", map[string]string{"token": resetToken}, http.StatusBadRequest, nil)
+	doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", newPassword, http.StatusNoContent, nil)
+
+	// Everything issued before the reset stops working
+	doJSON(t, server, http.MethodGet, "/api/sessions", accessToken, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodGet, "/api/sessions", tokens.Token, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/refresh", tokens.RefreshToken, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/chirps", apiToken.Token, map[string]string{"body": "still mine?"}, http.StatusUnauthorized, nil)
+
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "user@example.com", "password": testPassword}, http.StatusUnauthorized, nil)
+	var loggedIn loginTokens
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "user@example.com", "password": "a brand new password"}, http.StatusOK, &loggedIn)
+	doJSON(t, server, http.MethodGet, "/api/sessions", loggedIn.Token, nil, http.StatusOK, nil)
+
+	// A token works once
+	newPassword["password"] = "yet another password"
+	doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", newPassword, http.StatusBadRequest, nil)
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "user@example.com", "password": "a brand new password"}, http.StatusOK, nil)
+}
+
+func TestPasswordResetRequestHidesAccounts(t *testing.T) {
+	_, server, mailer := newResetServer(t)
+	createUser(t, server, "user@example.com")
+
+	known := requestReset(t, server, "user@example.com")
+	unknown := requestReset(t, server, "nobody@example.com")
+	if known["message"] == nil || known["message"] != unknown["message"] || len(known) != len(unknown) {
+		t.Errorf("responses differ: %v for an account, %v for no account", known, unknown)
+	}
+	if resets := mailer.resets(); len(resets) != 1 {
+		t.Errorf("sent %d resets, want 1", len(resets))
+	}
+
+	doJSON(t, server, http.MethodPost, "/api/password-reset/request", "", map[string]string{}, http.StatusBadRequest, nil)
+}
+
+func TestPasswordResetTokenRejected(t *testing.T) {
+	apiCfg, server, _ := newResetServer(t)
+	userID, _ := createUser(t, server, "user@example.com")
+
+	// Tokens are stored hashed, so the test can plant its own
+	plant := func(token, purpose string, expiresIn time.Duration) {
+		t.Helper()
+		sum := sha256.Sum256([]byte(token))
+		err := apiCfg.Store.CreateOneTimeToken(handlers.OneTimeToken{
+			Hash:      hex.EncodeToString(sum[:]),
+			Purpose:   purpose,
+			UserID:    userID,
+			ExpiresAt: time.Now().UTC().Add(expiresIn).Truncate(time.Microsecond),
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	plant("expired", handlers.TokenPurposePasswordReset, -time.Minute)
+	plant("verification", handlers.TokenPurposeEmailVerification, time.Hour)
+
+	for _, token := range []string{"expired", "verification"} {
+		doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", map[string]string{"token": token, "password": "a brand new password"}, http.StatusBadRequest, nil)
+	}
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "user@example.com", "password": testPassword}, http.StatusOK, nil)
+}
+
+func TestPasswordResetWeakPasswordKeepsToken(t *testing.T) {
+	_, server, mailer := newResetServer(t)
+	createUser(t, server, "user@example.com")
+	resetToken := requestResetToken(t, server, mailer, "user@example.com")
+
+	// The user can try again with a better password
+	doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", map[string]string{"token": resetToken, "password": "short"}, http.StatusUnprocessableEntity, nil)
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "user@example.com", "password": testPassword}, http.StatusOK, nil)
+	doJSON(t, server, http.MethodPost, "/api/password-reset/confirm", "", map[string]string{"token": resetToken, "password": "a brand new password"}, http.StatusNoContent, nil)
+}
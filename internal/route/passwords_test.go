@@ -0,0 +1,68 @@
+package route
+
+import (
+	"net/http"
+	"slices"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/password"
+)
+
+// passwordErrors is the 422 response for a password that breaks the policy
+type passwordErrors struct {
+	Errors []struct {
+		Field string `json:"field"`
+		Code  string `json:"code"`
+	} `json:"errors"`
+}
+
+func (resp passwordErrors) codes() []string {
+	codes := []string{}
+	for _, err := range resp.Errors {
+		if err.Field == "password" {
+			codes = append(codes, err.Code)
+		}
+	}
+	return codes
+}
+
+func TestWeakPasswordsRejected(t *testing.T) {
+	apiCfg := newTestConfig(openTestStore(t))
+	apiCfg.PasswordPolicy = password.Policy{MinLength: 10, RequireLower: true}
+	apiCfg.Breaches = password.Bundled()
+	server := serveConfig(t, apiCfg)
+
+	tests := []struct {
+		password string
+		want     []string
+	}{
+		{"short", []string{password.RuleTooShort}},
+		{"NO LOWERCASE AT ALL", []string{password.RuleMissingLowercase}},
+		{"newuser123", []string{password.RuleMatchesEmail}},
+		{"password123", []string{password.RuleBreached}},
+		{"123456", []string{password.RuleTooShort, password.RuleMissingLowercase, password.RuleBreached}},
+	}
+
+	// Signing up
+	for _, tt := range tests {
+		var resp passwordErrors
+		doJSON(t, server, http.MethodPost, "/api/users", "", map[string]string{"email": "newuser123@example.com", "password": tt.password}, http.StatusUnprocessableEntity, &resp)
+		if got := resp.codes(); !slices.Equal(got, tt.want) {
+			t.Errorf("signing up with %q: got %v, want %v", tt.password, got, tt.want)
+		}
+	}
+
+	// Changing the password
+	_, accessToken := createUser(t, server, "newuser123@example.com")
+	for _, tt := range tests {
+		var resp passwordErrors
+		doJSON(t, server, http.MethodPut, "/api/users", accessToken, map[string]string{"email": "newuser123@example.com", "password": tt.password}, http.StatusUnprocessableEntity, &resp)
+		if got := resp.codes(); !slices.Equal(got, tt.want) {
+			t.Errorf("changing the password to %q: got %v, want %v", tt.password, got, tt.want)
+		}
+	}
+
+	// The old password still works, and keeping it is not a change
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": "newuser123@example.com", "password": testPassword}, http.StatusOK, nil)
+	doJSON(t, server, http.MethodPut, "/api/users", accessToken, map[string]string{"email": "newuser123@example.com", "password": testPassword}, http.StatusOK, nil)
+}
@@ -0,0 +1,106 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/config"
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+// roleResponse is PUT /api/admin/users/{id}/role's response
+type roleResponse struct {
+	ID   int    `json:"id"`
+	Role string `json:"role"`
+}
+
+// createAdmin signs up a user, makes them an admin the way the make-admin
+// command does and logs them in again to get the role into the token
+func createAdmin(t *testing.T, server *httptest.Server, store storage.Store, email string) (int, string) {
+	t.Helper()
+	userID, _ := createUser(t, server, email)
+	if _, err := config.MakeAdmin(store, email); err != nil {
+		t.Fatal(err)
+	}
+	return userID, login(t, server, email).Token
+}
+
+// setRole changes the user's role as the admin
+func setRole(t *testing.T, server *httptest.Server, adminToken string, userID int, role string) {
+	t.Helper()
+	var resp roleResponse
+	doJSON(t, server, http.MethodPut, fmt.Sprintf("/api/admin/users/%d/role", userID), adminToken, map[string]string{"role": role}, http.StatusOK, &resp)
+	if resp.ID != userID || resp.Role != role {
+		t.Errorf("got %+v, want user %d with role %s", resp, userID, role)
+	}
+}
+
+func TestAdminRoutes(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	adminID, adminToken := createAdmin(t, server, store, "admin@example.com")
+	userID, userToken := createUser(t, server, "user@example.com")
+	moderatorID, _ := createUser(t, server, "moderator@example.com")
+	setRole(t, server, adminToken, moderatorID, handlers.RoleModerator)
+	moderatorToken := login(t, server, "moderator@example.com").Token
+	apiToken := createAPIToken(t, server, adminToken, config.ScopeChirpsWrite, config.ScopeChirpsDelete)
+
+	doJSON(t, server, http.MethodGet, "/api/metrics", adminToken, nil, http.StatusOK, nil)
+	doJSON(t, server, http.MethodGet, "/api/metrics", "", nil, http.StatusUnauthorized, nil)
+	for _, token := range []string{userToken, moderatorToken, apiToken.Token} {
+		doJSON(t, server, http.MethodGet, "/api/metrics", token, nil, http.StatusForbidden, nil)
+		doJSON(t, server, http.MethodPut, fmt.Sprintf("/api/admin/users/%d/role", userID), token, map[string]string{"role": handlers.RoleAdmin}, http.StatusForbidden, nil)
+	}
+
+	path := fmt.Sprintf("/api/admin/users/%d/role", userID)
+	doJSON(t, server, http.MethodPut, path, adminToken, map[string]string{"role": "superuser"}, http.StatusBadRequest, nil)
+	doJSON(t, server, http.MethodPut, fmt.Sprintf("/api/admin/users/%d/role", adminID), adminToken, map[string]string{"role": handlers.RoleUser}, http.StatusBadRequest, nil)
+	doJSON(t, server, http.MethodPut, "/api/admin/users/9999/role", adminToken, map[string]string{"role": handlers.RoleUser}, http.StatusNotFound, nil)
+
+	// None of the above changed anything
+	user, err := store.GetUser(userID)
+	if err != nil || user.GetRole() != handlers.RoleUser {
+		t.Errorf("user has role %q (%v), want %s", user.GetRole(), err, handlers.RoleUser)
+	}
+	doJSON(t, server, http.MethodGet, "/api/metrics", adminToken, nil, http.StatusOK, nil)
+}
+
+func TestModeratorDeletesChirps(t *testing.T) {
+	store := openTestStore(t)
+	server := newTestServer(t, store)
+	_, adminToken := createAdmin(t, server, store, "admin@example.com")
+	_, authorToken := createUser(t, server, "author@example.com")
+	moderatorID, _ := createUser(t, server, "moderator@example.com")
+	_, bystanderToken := createUser(t, server, "bystander@example.com")
+
+	first := postChirp(t, server, authorToken, "first")
+	second := postChirp(t, server, authorToken, "second")
+	firstPath := fmt.Sprintf("/api/chirps/%d", first.ID)
+	secondPath := fmt.Sprintf("/api/chirps/%d", second.ID)
+
+	doJSON(t, server, http.MethodDelete, firstPath, bystanderToken, nil, http.StatusForbidden, nil)
+
+	setRole(t, server, adminToken, moderatorID, handlers.RoleModerator)
+	moderator := login(t, server, "moderator@example.com")
+	doJSON(t, server, http.MethodDelete, firstPath, moderator.Token, nil, http.StatusNoContent, nil)
+	doJSON(t, server, http.MethodGet, firstPath, "", nil, http.StatusNotFound, nil)
+	// Moderating chirps is all the role allows
+	doJSON(t, server, http.MethodGet, "/api/metrics", moderator.Token, nil, http.StatusForbidden, nil)
+
+	// A demoted moderator's access token stops working straight away, even
+	// though it has not expired
+	setRole(t, server, adminToken, moderatorID, handlers.RoleUser)
+	doJSON(t, server, http.MethodDelete, secondPath, moderator.Token, nil, http.StatusUnauthorized, nil)
+
+	// The next refresh brings the token in line with the new role
+	var refreshed loginTokens
+	doJSON(t, server, http.MethodPost, "/api/refresh", moderator.RefreshToken, nil, http.StatusOK, &refreshed)
+	doJSON(t, server, http.MethodDelete, secondPath, refreshed.Token, nil, http.StatusForbidden, nil)
+	doJSON(t, server, http.MethodGet, secondPath, "", nil, http.StatusOK, nil)
+
+	// Authors can still delete their own chirps
+	doJSON(t, server, http.MethodDelete, secondPath, authorToken, nil, http.StatusNoContent, nil)
+}
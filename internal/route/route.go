@@ -10,33 +10,101 @@ import (
 func ConfigureRoutes(mux *http.ServeMux, apiCfg *config.ApiConfig) {
 	fileServer := http.FileServer(http.Dir(filepath.Join(".")))
 
+	// protected routes need a valid access token, see ApiConfig.MiddlewareAuth
+	protected := func(handler http.HandlerFunc) http.Handler {
+		return apiCfg.MiddlewareAuth(handler)
+	}
+
+	// scoped routes also take personal API tokens with the scope, see
+	// ApiConfig.MiddlewareAuthScope
+	scoped := func(scope string, handler http.HandlerFunc) http.Handler {
+		return apiCfg.MiddlewareAuthScope(scope, handler)
+	}
+
+	// admin routes need an access token for an admin, see ApiConfig.MiddlewareRole
+	admin := func(handler http.HandlerFunc) http.Handler {
+		return apiCfg.MiddlewareRole(handlers.RoleAdmin, handler)
+	}
+
 	mux.Handle("/", apiCfg.MiddlewareMetricsInc(http.StripPrefix("/", fileServer)))
 
 	mux.HandleFunc("GET /api/healthz", handlers.HandlerReadiness)
 
-	mux.HandleFunc("GET /api/metrics", apiCfg.HandlerMetrics)
+	mux.HandleFunc("GET /.well-known/jwks.json", apiCfg.HandlerJWKS)
+
+	mux.Handle("GET /api/metrics", admin(apiCfg.HandlerMetrics))
+
+	mux.Handle("GET /api/reset", admin(apiCfg.HandlerReset))
+
+	mux.Handle("GET /admin/metrics", admin(apiCfg.HandlerMetricsHTML))
+
+	mux.Handle("PUT /api/admin/users/{userID}/role", admin(apiCfg.HandlerSetUserRole))
+
+	mux.Handle("POST /api/chirps", scoped(config.ScopeChirpsWrite, apiCfg.HandlerAddChirps))
+
+	mux.HandleFunc("GET /api/chirps", apiCfg.HandlerGetChirps)
 
-	mux.HandleFunc("GET /api/reset", apiCfg.HandlerReset)
+	mux.HandleFunc("GET /api/chirps/", apiCfg.HandlerGetChirpsID)
 
-	mux.HandleFunc("GET /admin/metrics", apiCfg.HandlerMetricsHTML)
+	mux.Handle("PUT /api/chirps/{chirpID}", scoped(config.ScopeChirpsWrite, apiCfg.HandlerUpdateChirp))
 
-	mux.HandleFunc("POST /api/chirps", apiCfg.HandlerAddChirps)
+	mux.Handle("PATCH /api/chirps/{chirpID}", scoped(config.ScopeChirpsWrite, apiCfg.HandlerUpdateChirp))
 
-	mux.HandleFunc("GET /api/chirps", handlers.HandlerGetChirps)
+	mux.HandleFunc("GET /api/chirps/{chirpID}/revisions", apiCfg.HandlerGetChirpRevisions)
 
-	mux.HandleFunc("GET /api/chirps/", handlers.HandlerGetChirpsID)
+	mux.HandleFunc("POST /api/users", apiCfg.HandlerAddUser)
 
-	mux.HandleFunc("POST /api/users", handlers.HandlerAddUser)
+	mux.HandleFunc("GET /api/users/verify", apiCfg.HandlerVerifyEmail)
+
+	mux.Handle("POST /api/users/verify/resend", protected(apiCfg.HandlerResendVerification))
 
 	mux.HandleFunc("POST /api/login", apiCfg.HandlerAuthenticateUser)
 
-	mux.HandleFunc("PUT /api/users", apiCfg.HandlerPutUser)
+	mux.HandleFunc("POST /api/login/mfa", apiCfg.HandlerLoginMFA)
+
+	mux.Handle("POST /api/users/mfa/totp", protected(apiCfg.HandlerStartTOTP))
+
+	mux.Handle("POST /api/users/mfa/totp/confirm", protected(apiCfg.HandlerConfirmTOTP))
+
+	mux.Handle("DELETE /api/users/mfa/totp", protected(apiCfg.HandlerDisableTOTP))
+
+	mux.HandleFunc("GET /api/oidc/{provider}/login", apiCfg.HandlerOIDCLogin)
+
+	mux.HandleFunc("GET /api/oidc/{provider}/callback", apiCfg.HandlerOIDCCallback)
+
+	mux.Handle("POST /api/oidc/{provider}/link", protected(apiCfg.HandlerOIDCLink))
+
+	mux.Handle("POST /api/oidc/{provider}/reauth", protected(apiCfg.HandlerOIDCReauth))
+
+	mux.Handle("PUT /api/users", protected(apiCfg.HandlerPutUser))
+
+	mux.Handle("DELETE /api/users", protected(apiCfg.HandlerDeleteUser))
+
+	mux.Handle("GET /api/users/me/export", protected(apiCfg.HandlerExportUser))
+
+	mux.HandleFunc("POST /api/password-reset/request", apiCfg.HandlerRequestPasswordReset)
+
+	mux.HandleFunc("POST /api/password-reset/confirm", apiCfg.HandlerConfirmPasswordReset)
 
 	mux.HandleFunc("POST /api/refresh", apiCfg.HandlerRefreshToken)
 
 	mux.HandleFunc("POST /api/revoke", apiCfg.HandlerRevokeToken)
 
-	mux.HandleFunc("DELETE /api/chirps/", apiCfg.HandlerDeleteChirps)
+	mux.Handle("POST /api/logout", protected(apiCfg.HandlerLogout))
+
+	mux.Handle("GET /api/sessions", protected(apiCfg.HandlerGetSessions))
+
+	mux.Handle("DELETE /api/sessions/{sessionID}", protected(apiCfg.HandlerDeleteSession))
+
+	mux.Handle("POST /api/sessions/revoke-all", protected(apiCfg.HandlerRevokeAllSessions))
+
+	mux.Handle("POST /api/tokens", protected(apiCfg.HandlerCreateAPIToken))
+
+	mux.Handle("GET /api/tokens", protected(apiCfg.HandlerGetAPITokens))
+
+	mux.Handle("DELETE /api/tokens/{tokenID}", protected(apiCfg.HandlerDeleteAPIToken))
+
+	mux.Handle("DELETE /api/chirps/", scoped(config.ScopeChirpsDelete, apiCfg.HandlerDeleteChirps))
 
 	mux.HandleFunc("POST /api/polka/webhooks", apiCfg.HandlerPolkaWebhooks)
 }
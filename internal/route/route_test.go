@@ -0,0 +1,244 @@
+package route
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"sync"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/config"
+	"github.com/RichardHoa/go-server/internal/mail"
+	"github.com/RichardHoa/go-server/internal/search"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+const (
+	testPassword    = "correct horse battery"
+	testPolkaAPIKey = "polka-test-key"
+)
+
+// TestConcurrentWrites hammers the write endpoints from many goroutines at
+// once and checks that no write is lost. Run it with -race.
+func TestConcurrentWrites(t *testing.T) {
+	for _, driver := range []string{"json", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			testConcurrentWrites(t, driver)
+		})
+	}
+}
+
+func testConcurrentWrites(t *testing.T, driver string) {
+	const (
+		userCount      = 4
+		chirpsPerUser  = 50
+		updatesPerUser = 3
+	)
+
+	dbPath := filepath.Join(t.TempDir(), "database")
+	store, err := storage.Open(driver, dbPath)
+	if err != nil {
+		t.Fatalf("could not open store: %v", err)
+	}
+	server := newTestServer(t, store)
+
+	type testUser struct {
+		id    int
+		token string
+		email string // The email the last update set
+	}
+	users := make([]*testUser, userCount)
+	for i := range users {
+		email := fmt.Sprintf("user%d@example.com", i)
+		var created struct {
+			ID int `json:"id"`
+		}
+		doJSON(t, server, http.MethodPost, "/api/users", "", map[string]string{"email": email, "password": testPassword}, http.StatusCreated, &created)
+		var login struct {
+			Token string `json:"token"`
+		}
+		doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": email, "password": testPassword}, http.StatusOK, &login)
+		users[i] = &testUser{id: created.ID, token: login.Token, email: email}
+	}
+
+	var mu sync.Mutex
+	var chirpIDs []int
+	var wg sync.WaitGroup
+	for i, user := range users {
+		// Chirps
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < chirpsPerUser; n++ {
+				var chirp struct {
+					ID int `json:"id"`
+				}
+				doJSON(t, server, http.MethodPost, "/api/chirps", user.token, map[string]string{"body": fmt.Sprintf("chirp %d by user %d", n, i)}, http.StatusCreated, &chirp)
+				mu.Lock()
+				chirpIDs = append(chirpIDs, chirp.ID)
+				mu.Unlock()
+			}
+		}()
+
+		// Email changes race the upgrade below for the same user row
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := 0; n < updatesPerUser; n++ {
+				email := fmt.Sprintf("user%d-%d@example.com", i, n)
+				doJSON(t, server, http.MethodPut, "/api/users", user.token, map[string]string{"email": email, "password": testPassword}, http.StatusOK, nil)
+				user.email = email
+			}
+		}()
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			body := map[string]interface{}{"event": "user.upgraded", "data": map[string]int{"user_id": user.id}}
+			doJSON(t, server, http.MethodPost, "/api/polka/webhooks", "ApiKey "+testPolkaAPIKey, body, http.StatusNoContent, nil)
+		}()
+	}
+	wg.Wait()
+	if t.Failed() {
+		return
+	}
+
+	// Every chirp got its own ID
+	seen := make(map[int]bool)
+	for _, id := range chirpIDs {
+		if seen[id] {
+			t.Errorf("chirp ID %d handed out twice", id)
+		}
+		seen[id] = true
+	}
+	if len(seen) != userCount*chirpsPerUser {
+		t.Errorf("got %d distinct chirp IDs, want %d", len(seen), userCount*chirpsPerUser)
+	}
+
+	// Everything survives closing and reopening the store
+	server.Close()
+	closeStore(t, store)
+	store, err = storage.Open(driver, dbPath)
+	if err != nil {
+		t.Fatalf("could not reopen store: %v", err)
+	}
+	defer closeStore(t, store)
+
+	chirps, err := store.ListChirps(storage.ChirpQuery{})
+	if err != nil {
+		t.Fatalf("could not list chirps: %v", err)
+	}
+	if len(chirps) != len(seen) {
+		t.Errorf("%d chirps persisted, want %d", len(chirps), len(seen))
+	}
+	for _, chirp := range chirps {
+		if !seen[chirp.ID] {
+			t.Errorf("persisted chirp %d was never returned by the API", chirp.ID)
+		}
+	}
+
+	for _, user := range users {
+		stored, err := store.GetUser(user.id)
+		if err != nil {
+			t.Fatalf("could not get user %d: %v", user.id, err)
+		}
+		if !stored.IsChirpyRed {
+			t.Errorf("user %d lost the Chirpy Red upgrade", user.id)
+		}
+		if stored.Email != user.email {
+			t.Errorf("user %d has email %q, want %q", user.id, stored.Email, user.email)
+		}
+	}
+}
+
+// newTestServer serves the routes with the store and no mail or breach list
+func newTestServer(t *testing.T, store storage.Store) *httptest.Server {
+	t.Helper()
+	return serveConfig(t, newTestConfig(store))
+}
+
+// openTestStore opens a JSON store that is closed when the test ends
+func openTestStore(t *testing.T) storage.Store {
+	t.Helper()
+	store, err := storage.Open("json", filepath.Join(t.TempDir(), "database"))
+	if err != nil {
+		t.Fatalf("could not open store: %v", err)
+	}
+	t.Cleanup(func() { closeStore(t, store) })
+	return store
+}
+
+// newTestConfig is the config newTestServer uses, for tests that change it
+func newTestConfig(store storage.Store) *config.ApiConfig {
+	return &config.ApiConfig{
+		JWTSecret:   "test-secret",
+		PolkaAPIKey: testPolkaAPIKey,
+		Store:       store,
+		Search:      search.NewIndex(),
+		Mailer:      mail.LogMailer{Logger: log.New(io.Discard, "", 0)},
+	}
+}
+
+// serveConfig serves the routes with apiCfg until the test ends
+func serveConfig(t *testing.T, apiCfg *config.ApiConfig) *httptest.Server {
+	t.Helper()
+	mux := http.NewServeMux()
+	ConfigureRoutes(mux, apiCfg)
+	server := httptest.NewServer(mux)
+	t.Cleanup(server.Close)
+	return server
+}
+
+// doJSON sends body as JSON and decodes the response into out. auth is the
+// bearer token, or a full Authorization header if it has a space.
+func doJSON(t *testing.T, server *httptest.Server, method, path, auth string, body interface{}, wantStatus int, out interface{}) {
+	t.Helper()
+	payload, err := json.Marshal(body)
+	if err != nil {
+		t.Errorf("could not marshal body: %v", err)
+		return
+	}
+	req, err := http.NewRequest(method, server.URL+path, bytes.NewReader(payload))
+	if err != nil {
+		t.Errorf("could not build request: %v", err)
+		return
+	}
+	if auth != "" {
+		if !bytes.ContainsRune([]byte(auth), ' ') {
+			auth = "Bearer " + auth
+		}
+		req.Header.Set("Authorization", auth)
+	}
+
+	resp, err := server.Client().Do(req)
+	if err != nil {
+		t.Errorf("%s %s: %v", method, path, err)
+		return
+	}
+	defer resp.Body.Close()
+	respBody, _ := io.ReadAll(resp.Body)
+	if resp.StatusCode != wantStatus {
+		t.Errorf("%s %s: status %d, want %d: %s", method, path, resp.StatusCode, wantStatus, respBody)
+		return
+	}
+	if out != nil {
+		if err := json.Unmarshal(respBody, out); err != nil {
+			t.Errorf("%s %s: could not decode response: %v", method, path, err)
+		}
+	}
+}
+
+// closeStore closes backends that hold files open
+func closeStore(t *testing.T, store storage.Store) {
+	t.Helper()
+	if closer, ok := store.(io.Closer); ok {
+		if err := closer.Close(); err != nil {
+			t.Errorf("could not close store: %v", err)
+		}
+	}
+}
@@ -0,0 +1,144 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"testing"
+)
+
+// loginTokens are the tokens a login or refresh hands out
+type loginTokens struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+}
+
+// login logs in as a user created with testPassword
+func login(t *testing.T, server *httptest.Server, email string) loginTokens {
+	t.Helper()
+	var tokens loginTokens
+	doJSON(t, server, http.MethodPost, "/api/login", "", map[string]string{"email": email, "password": testPassword}, http.StatusOK, &tokens)
+	return tokens
+}
+
+func TestRefreshTokenRotation(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	createUser(t, server, "user@example.com")
+	first := login(t, server, "user@example.com")
+	other := login(t, server, "user@example.com")
+
+	var second loginTokens
+	doJSON(t, server, http.MethodPost, "/api/refresh", first.RefreshToken, nil, http.StatusOK, &second)
+	if second.Token == "" || second.RefreshToken == "" || second.RefreshToken == first.RefreshToken {
+		t.Fatalf("refresh returned %+v, want a new access and refresh token", second)
+	}
+	doJSON(t, server, http.MethodPost, "/api/chirps", second.Token, map[string]string{"body": "refreshed"}, http.StatusCreated, nil)
+
+	// Replaying the old refresh token ends the session for everyone holding it
+	doJSON(t, server, http.MethodPost, "/api/refresh", first.RefreshToken, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/refresh", second.RefreshToken, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/chirps", second.Token, map[string]string{"body": "refreshed"}, http.StatusUnauthorized, nil)
+
+	// The user's other session is untouched
+	doJSON(t, server, http.MethodPost, "/api/refresh", other.RefreshToken, nil, http.StatusOK, nil)
+	doJSON(t, server, http.MethodPost, "/api/refresh", "not-a-token", nil, http.StatusUnauthorized, nil)
+}
+
+// sessionResponse is a session as GET /api/sessions lists it
+type sessionResponse struct {
+	ID      int  `json:"id"`
+	Current bool `json:"current"`
+}
+
+// listSessions returns the IDs of the user's active sessions, and the one
+// the access token belongs to
+func listSessions(t *testing.T, server *httptest.Server, accessToken string) ([]int, int) {
+	t.Helper()
+	var sessions []sessionResponse
+	doJSON(t, server, http.MethodGet, "/api/sessions", accessToken, nil, http.StatusOK, &sessions)
+	ids := []int{}
+	current := 0
+	for _, session := range sessions {
+		ids = append(ids, session.ID)
+		if session.Current {
+			if current != 0 {
+				t.Errorf("sessions %d and %d are both current", current, session.ID)
+			}
+			current = session.ID
+		}
+	}
+	return ids, current
+}
+
+func TestDeleteSession(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	createUser(t, server, "user@example.com")
+	laptop := login(t, server, "user@example.com")
+	phone := login(t, server, "user@example.com")
+	createUser(t, server, "other@example.com")
+	other := login(t, server, "other@example.com")
+
+	ids, laptopID := listSessions(t, server, laptop.Token)
+	_, phoneID := listSessions(t, server, phone.Token)
+	if len(ids) != 3 || laptopID == 0 || phoneID == 0 || laptopID == phoneID {
+		t.Fatalf("got sessions %v with laptop %d and phone %d, want 3 with two different current ones", ids, laptopID, phoneID)
+	}
+
+	doJSON(t, server, http.MethodDelete, fmt.Sprintf("/api/sessions/%d", phoneID), laptop.Token, nil, http.StatusNoContent, nil)
+
+	// Both of the phone's tokens stop working at once
+	doJSON(t, server, http.MethodGet, "/api/sessions", phone.Token, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/refresh", phone.RefreshToken, nil, http.StatusUnauthorized, nil)
+	if ids, _ := listSessions(t, server, laptop.Token); len(ids) != 2 || slices.Contains(ids, phoneID) {
+		t.Errorf("got sessions %v, want 2 without %d", ids, phoneID)
+	}
+	doJSON(t, server, http.MethodPost, "/api/refresh", laptop.RefreshToken, nil, http.StatusOK, nil)
+
+	// Ended, missing and other users' sessions are all not found
+	doJSON(t, server, http.MethodDelete, fmt.Sprintf("/api/sessions/%d", phoneID), laptop.Token, nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodDelete, "/api/sessions/9999", laptop.Token, nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodDelete, "/api/sessions/phone", laptop.Token, nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodDelete, fmt.Sprintf("/api/sessions/%d", laptopID), other.Token, nil, http.StatusNotFound, nil)
+	doJSON(t, server, http.MethodGet, "/api/sessions", other.Token, nil, http.StatusOK, nil)
+}
+
+func TestRevokeAllSessions(t *testing.T) {
+	server := newTestServer(t, openTestStore(t))
+	_, signupToken := createUser(t, server, "user@example.com")
+	laptop := login(t, server, "user@example.com")
+	phone := login(t, server, "user@example.com")
+	createUser(t, server, "other@example.com")
+	other := login(t, server, "other@example.com")
+
+	var resp struct {
+		Revoked int `json:"revoked"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/sessions/revoke-all", laptop.Token, nil, http.StatusOK, &resp)
+	if resp.Revoked != 2 {
+		t.Errorf("revoked %d sessions, want 2", resp.Revoked)
+	}
+	for _, token := range []string{signupToken, phone.Token} {
+		doJSON(t, server, http.MethodGet, "/api/sessions", token, nil, http.StatusUnauthorized, nil)
+	}
+	doJSON(t, server, http.MethodPost, "/api/refresh", phone.RefreshToken, nil, http.StatusUnauthorized, nil)
+
+	// The session it was sent from carries on
+	var refreshed loginTokens
+	doJSON(t, server, http.MethodPost, "/api/refresh", laptop.RefreshToken, nil, http.StatusOK, &refreshed)
+	if ids, current := listSessions(t, server, refreshed.Token); len(ids) != 1 || ids[0] != current {
+		t.Errorf("got sessions %v with current %d, want only the current one", ids, current)
+	}
+
+	// Unless it goes too
+	doJSON(t, server, http.MethodPost, "/api/sessions/revoke-all?include_current=true", refreshed.Token, nil, http.StatusOK, &resp)
+	if resp.Revoked != 1 {
+		t.Errorf("revoked %d sessions, want 1", resp.Revoked)
+	}
+	doJSON(t, server, http.MethodGet, "/api/sessions", refreshed.Token, nil, http.StatusUnauthorized, nil)
+	doJSON(t, server, http.MethodPost, "/api/refresh", refreshed.RefreshToken, nil, http.StatusUnauthorized, nil)
+
+	// Other users are not logged out
+	doJSON(t, server, http.MethodGet, "/api/sessions", other.Token, nil, http.StatusOK, nil)
+	doJSON(t, server, http.MethodPost, "/api/refresh", other.RefreshToken, nil, http.StatusOK, nil)
+}
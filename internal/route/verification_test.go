@@ -0,0 +1,45 @@
+package route
+
+import (
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	"github.com/RichardHoa/go-server/internal/storage"
+)
+
+func TestRequireVerifiedEmail(t *testing.T) {
+	store, err := storage.Open("json", filepath.Join(t.TempDir(), "database"))
+	if err != nil {
+		t.Fatalf("could not open store: %v", err)
+	}
+	defer closeStore(t, store)
+
+	apiCfg := newTestConfig(store)
+	server := serveConfig(t, apiCfg)
+	userID, accessToken := createUser(t, server, "user@example.com")
+
+	// A chirp from before the policy was turned on
+	var chirp struct {
+		ID int `json:"id"`
+	}
+	doJSON(t, server, http.MethodPost, "/api/chirps", accessToken, map[string]string{"body": "hello"}, http.StatusCreated, &chirp)
+	apiCfg.RequireVerifiedEmail = true
+
+	path := fmt.Sprintf("/api/chirps/%d", chirp.ID)
+	edit := map[string]string{"body": "edited"}
+	doJSON(t, server, http.MethodPost, "/api/chirps", accessToken, map[string]string{"body": "hello again"}, http.StatusForbidden, nil)
+	doJSON(t, server, http.MethodPut, path, accessToken, edit, http.StatusForbidden, nil)
+	doJSON(t, server, http.MethodPatch, path, accessToken, edit, http.StatusForbidden, nil)
+
+	if _, err := store.UpdateUser(userID, func(user *handlers.User) error {
+		user.EmailVerified = true
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	doJSON(t, server, http.MethodPut, path, accessToken, edit, http.StatusOK, nil)
+	doJSON(t, server, http.MethodPost, "/api/chirps", accessToken, map[string]string{"body": "hello again"}, http.StatusCreated, nil)
+}
@@ -0,0 +1,164 @@
+package search
+
+import (
+	"math"
+	"sort"
+	"strings"
+	"sync"
+	"unicode"
+)
+
+// BM25 tuning parameters, the usual defaults
+const (
+	k1 = 1.2  // How quickly repeated terms stop adding to the score
+	b  = 0.75 // How much long documents are penalised
+)
+
+// Result is a matching document and its relevance score
+type Result struct {
+	ID    int
+	Score float64
+}
+
+// Index is an in-memory inverted index over short documents (chirp bodies),
+// ranked with BM25. It is safe for concurrent use, and a nil *Index behaves
+// as an index that ignores writes and matches nothing.
+type Index struct {
+	mu       sync.RWMutex
+	postings map[string]map[int]int // term -> document ID -> term frequency
+	docTerms map[int][]string       // document ID -> its distinct terms, for removal
+	docLen   map[int]int            // document ID -> number of terms
+	totalLen int
+}
+
+// NewIndex returns an empty index
+func NewIndex() *Index {
+	return &Index{
+		postings: make(map[string]map[int]int),
+		docTerms: make(map[int][]string),
+		docLen:   make(map[int]int),
+	}
+}
+
+// Tokenize splits text into lower-cased words. Any rune that is not a letter
+// or a digit separates words, so punctuation, emoji and whitespace in every
+// script are handled the same way.
+func Tokenize(text string) []string {
+	words := strings.FieldsFunc(text, func(r rune) bool {
+		return !unicode.IsLetter(r) && !unicode.IsNumber(r)
+	})
+	for i, word := range words {
+		words[i] = strings.ToLower(word)
+	}
+	return words
+}
+
+// Add indexes the document, replacing any previous version with the same ID
+func (idx *Index) Add(id int, text string) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+
+	terms := Tokenize(text)
+	frequencies := make(map[string]int)
+	for _, term := range terms {
+		frequencies[term]++
+	}
+
+	distinct := make([]string, 0, len(frequencies))
+	for term, tf := range frequencies {
+		if idx.postings[term] == nil {
+			idx.postings[term] = make(map[int]int)
+		}
+		idx.postings[term][id] = tf
+		distinct = append(distinct, term)
+	}
+
+	idx.docTerms[id] = distinct
+	idx.docLen[id] = len(terms)
+	idx.totalLen += len(terms)
+}
+
+// Remove drops the document from the index
+func (idx *Index) Remove(id int) {
+	if idx == nil {
+		return
+	}
+	idx.mu.Lock()
+	defer idx.mu.Unlock()
+
+	idx.remove(id)
+}
+
+// remove is Remove without locking. The caller must hold idx.mu.
+func (idx *Index) remove(id int) {
+	terms, exists := idx.docTerms[id]
+	if !exists {
+		return
+	}
+
+	for _, term := range terms {
+		delete(idx.postings[term], id)
+		if len(idx.postings[term]) == 0 {
+			delete(idx.postings, term)
+		}
+	}
+	idx.totalLen -= idx.docLen[id]
+	delete(idx.docTerms, id)
+	delete(idx.docLen, id)
+}
+
+// Search returns every document containing at least one of the query's
+// terms, best match first. Documents with equal scores are returned newest
+// (highest ID) first.
+func (idx *Index) Search(query string) []Result {
+	if idx == nil {
+		return []Result{}
+	}
+	idx.mu.RLock()
+	defer idx.mu.RUnlock()
+
+	docCount := float64(len(idx.docLen))
+	if docCount == 0 {
+		return []Result{}
+	}
+	avgLen := float64(idx.totalLen) / docCount
+
+	scores := make(map[int]float64)
+	seen := make(map[string]bool)
+	for _, term := range Tokenize(query) {
+		if seen[term] {
+			continue
+		}
+		seen[term] = true
+
+		postings := idx.postings[term]
+		if len(postings) == 0 {
+			continue
+		}
+
+		df := float64(len(postings))
+		idf := math.Log(1 + (docCount-df+0.5)/(df+0.5))
+		for id, tf := range postings {
+			freq := float64(tf)
+			norm := 1 - b + b*float64(idx.docLen[id])/avgLen
+			scores[id] += idf * freq * (k1 + 1) / (freq + k1*norm)
+		}
+	}
+
+	results := make([]Result, 0, len(scores))
+	for id, score := range scores {
+		results = append(results, Result{ID: id, Score: score})
+	}
+	sort.Slice(results, func(i, j int) bool {
+		if results[i].Score != results[j].Score {
+			return results[i].Score > results[j].Score
+		}
+		return results[i].ID > results[j].ID
+	})
+	return results
+}
@@ -0,0 +1,113 @@
+package search
+
+import (
+	"slices"
+	"testing"
+)
+
+func TestTokenize(t *testing.T) {
+	tests := []struct {
+		text string
+		want []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"don't stop", []string{"don", "t", "stop"}},
+		{"  tabs\tand\nnewlines  ", []string{"tabs", "and", "newlines"}},
+		{"42 chirps", []string{"42", "chirps"}},
+		{"emoji🙂between", []string{"emoji", "between"}},
+		{"ÉCOLE Café", []string{"école", "café"}},
+		{"日本語のテキスト", []string{"日本語のテキスト"}},
+		{"...", []string{}},
+		{"", []string{}},
+	}
+
+	for _, tt := range tests {
+		if got := Tokenize(tt.text); !slices.Equal(got, tt.want) {
+			t.Errorf("Tokenize(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestSearchRanking(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(1, "The quick brown fox")
+	idx.Add(2, "The lazy dog sleeps all day in the sun by the river")
+	idx.Add(3, "A quick fox, a quick dog")
+
+	tests := []struct {
+		query string
+		want  []int
+	}{
+		// Doc 3 has quick twice
+		{"quick", []int{3, 1}},
+		{"quick fox", []int{3, 1}},
+		// Short documents beat long ones with the same matches
+		{"fox", []int{1, 3}},
+		// lazy is in fewer documents than fox, so it counts for more
+		{"lazy fox", []int{2, 1, 3}},
+		// The same term twice in the query counts once
+		{"dog dog", []int{3, 2}},
+		// Using "the" three times outweighs being long
+		{"the", []int{2, 1}},
+		{"QUICK!", []int{3, 1}},
+		{"cat", []int{}},
+		{"", []int{}},
+	}
+
+	for _, tt := range tests {
+		results := idx.Search(tt.query)
+		got := make([]int, 0, len(results))
+		for i, result := range results {
+			got = append(got, result.ID)
+			if i > 0 && result.Score > results[i-1].Score {
+				t.Errorf("Search(%q): results not sorted by score: %+v", tt.query, results)
+			}
+		}
+		if !slices.Equal(got, tt.want) {
+			t.Errorf("Search(%q) = %v, want %v (%+v)", tt.query, got, tt.want, results)
+		}
+	}
+}
+
+func TestSearchTiesNewestFirst(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(1, "same words")
+	idx.Add(3, "same words")
+	idx.Add(2, "same words")
+
+	results := idx.Search("words")
+	got := []int{}
+	for _, result := range results {
+		got = append(got, result.ID)
+	}
+	if !slices.Equal(got, []int{3, 2, 1}) {
+		t.Errorf("got %v, want [3 2 1]", got)
+	}
+}
+
+func TestAddReplacesAndRemove(t *testing.T) {
+	idx := NewIndex()
+	idx.Add(1, "old words")
+	idx.Add(1, "new words")
+	if results := idx.Search("old"); len(results) != 0 {
+		t.Errorf("found the replaced body: %+v", results)
+	}
+	if results := idx.Search("new"); len(results) != 1 || results[0].ID != 1 {
+		t.Errorf("Search(new) = %+v, want document 1", results)
+	}
+
+	idx.Remove(1)
+	idx.Remove(1)
+	if results := idx.Search("new words"); len(results) != 0 {
+		t.Errorf("found a removed document: %+v", results)
+	}
+	if idx.totalLen != 0 || len(idx.postings) != 0 {
+		t.Errorf("removing every document left totalLen %d and %d terms", idx.totalLen, len(idx.postings))
+	}
+
+	var nilIndex *Index
+	nilIndex.Add(1, "ignored")
+	if results := nilIndex.Search("ignored"); len(results) != 0 {
+		t.Errorf("nil index found %+v", results)
+	}
+}
@@ -0,0 +1,57 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// GenerateKey writes a new private key to dir and returns its kid. The kid is
+// the creation time, so with no JWT_SIGNING_KID set the new key starts
+// signing on the next restart while older keys keep verifying.
+func GenerateKey(dir, alg string) (string, error) {
+	var private crypto.Signer
+	var err error
+	switch alg {
+	case "EdDSA":
+		_, private, err = ed25519.GenerateKey(rand.Reader)
+	case "RS256":
+		private, err = rsa.GenerateKey(rand.Reader, minRSABits)
+	default:
+		return "", fmt.Errorf("unsupported algorithm %q, use EdDSA or RS256", alg)
+	}
+	if err != nil {
+		return "", fmt.Errorf("could not generate key: %v", err)
+	}
+
+	der, err := x509.MarshalPKCS8PrivateKey(private)
+	if err != nil {
+		return "", fmt.Errorf("could not encode key: %v", err)
+	}
+
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return "", fmt.Errorf("could not create key directory: %v", err)
+	}
+
+	kid := time.Now().UTC().Format("20060102T150405Z")
+	file, err := os.OpenFile(filepath.Join(dir, kid+".pem"), os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return "", fmt.Errorf("could not create key file: %v", err)
+	}
+	defer file.Close()
+
+	if err := pem.Encode(file, &pem.Block{Type: "PRIVATE KEY", Bytes: der}); err != nil {
+		return "", fmt.Errorf("could not write key: %v", err)
+	}
+	if err := file.Close(); err != nil {
+		return "", fmt.Errorf("could not write key: %v", err)
+	}
+	return kid, nil
+}
@@ -0,0 +1,172 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// minRSABits is the smallest RSA key we accept
+const minRSABits = 2048
+
+// Key is one verification key, and also a signing key if Private is set
+type Key struct {
+	ID      string // The "kid" header of tokens signed with this key
+	Method  jwt.SigningMethod
+	Private crypto.Signer    // nil for retired keys that only verify
+	Public  crypto.PublicKey // []byte for HMAC keys
+}
+
+// KeySet signs tokens with one key and verifies tokens signed by any of its
+// keys, picked by the token's kid. Keeping retired keys in the set lets us
+// rotate without logging anybody out.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewHMAC returns a key set with a single HS256 secret, as used before
+// asymmetric keys were supported. Its tokens carry no kid.
+func NewHMAC(secret []byte) *KeySet {
+	key := &Key{Method: jwt.SigningMethodHS256, Public: secret}
+	return &KeySet{signing: key, keys: map[string]*Key{"": key}}
+}
+
+// LoadDir loads every *.pem file in dir. The file name without ".pem" is the
+// key's kid. Private keys (PKCS#8, or PKCS#1 for RSA) can sign and verify;
+// public keys (PKIX) only verify. signingKID picks the key that signs new
+// tokens; if empty, the private key whose kid sorts last is used, so naming
+// keys by date makes the newest one sign.
+func LoadDir(dir, signingKID string) (*KeySet, error) {
+	paths, err := filepath.Glob(filepath.Join(dir, "*.pem"))
+	if err != nil {
+		return nil, fmt.Errorf("could not list keys: %v", err)
+	}
+	sort.Strings(paths)
+
+	ks := &KeySet{keys: make(map[string]*Key)}
+	for _, path := range paths {
+		key, err := loadKey(path)
+		if err != nil {
+			return nil, err
+		}
+		ks.keys[key.ID] = key
+		if key.Private != nil && signingKID == "" {
+			ks.signing = key
+		}
+	}
+	if len(ks.keys) == 0 {
+		return nil, fmt.Errorf("no *.pem keys found in %s", dir)
+	}
+
+	if signingKID != "" {
+		ks.signing = ks.keys[signingKID]
+		if ks.signing == nil || ks.signing.Private == nil {
+			return nil, fmt.Errorf("no private key with kid %q in %s", signingKID, dir)
+		}
+	}
+	if ks.signing == nil {
+		return nil, fmt.Errorf("no private key found in %s", dir)
+	}
+	return ks, nil
+}
+
+// loadKey reads one PEM file
+func loadKey(path string) (*Key, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not read key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("%s is not a PEM file", path)
+	}
+
+	key := &Key{ID: strings.TrimSuffix(filepath.Base(path), ".pem")}
+	var parsed interface{}
+	switch block.Type {
+	case "PRIVATE KEY":
+		parsed, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		parsed, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	case "PUBLIC KEY":
+		parsed, err = x509.ParsePKIXPublicKey(block.Bytes)
+	default:
+		return nil, fmt.Errorf("%s: unsupported PEM block %q", path, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("could not parse %s: %v", path, err)
+	}
+
+	switch k := parsed.(type) {
+	case *rsa.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodRS256, k, &k.PublicKey
+	case *rsa.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodRS256, k
+	case ed25519.PrivateKey:
+		key.Method, key.Private, key.Public = jwt.SigningMethodEdDSA, k, k.Public()
+	case ed25519.PublicKey:
+		key.Method, key.Public = jwt.SigningMethodEdDSA, k
+	default:
+		return nil, fmt.Errorf("%s: only RSA and Ed25519 keys are supported, got %T", path, parsed)
+	}
+
+	if rsaKey, ok := key.Public.(*rsa.PublicKey); ok && rsaKey.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("%s: RSA keys must be at least %d bits", path, minRSABits)
+	}
+	return key, nil
+}
+
+// Sign signs the claims with the signing key, setting the kid header
+func (ks *KeySet) Sign(claims jwt.Claims) (string, error) {
+	token := jwt.NewWithClaims(ks.signing.Method, claims)
+	if ks.signing.ID != "" {
+		token.Header["kid"] = ks.signing.ID
+	}
+
+	var key interface{} = ks.signing.Private
+	if ks.signing.Private == nil {
+		key = ks.signing.Public // HMAC signs with the shared secret
+	}
+	return token.SignedString(key)
+}
+
+// Methods lists the algorithms of the keys in the set, for
+// jwt.WithValidMethods
+func (ks *KeySet) Methods() []string {
+	seen := make(map[string]bool)
+	var methods []string
+	for _, key := range ks.keys {
+		if alg := key.Method.Alg(); !seen[alg] {
+			seen[alg] = true
+			methods = append(methods, alg)
+		}
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// Keyfunc finds the key a token was signed with. It is passed to
+// jwt.ParseWithClaims.
+func (ks *KeySet) Keyfunc(token *jwt.Token) (interface{}, error) {
+	kid, _ := token.Header["kid"].(string)
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown kid %q", kid)
+	}
+	// The algorithm must be the key's own, not whatever the token claims
+	if token.Method.Alg() != key.Method.Alg() {
+		return nil, errors.New("signing method does not match key")
+	}
+	return key.Public, nil
+}
@@ -0,0 +1,168 @@
+package signing
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/pem"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// writeKey writes the private key to dir as kid.pem, or only its public half
+// for a retired key
+func writeKey(t *testing.T, dir, kid string, private crypto.Signer, retired bool) {
+	t.Helper()
+	var block *pem.Block
+	if retired {
+		der, err := x509.MarshalPKIXPublicKey(private.Public())
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PUBLIC KEY", Bytes: der}
+	} else {
+		der, err := x509.MarshalPKCS8PrivateKey(private)
+		if err != nil {
+			t.Fatal(err)
+		}
+		block = &pem.Block{Type: "PRIVATE KEY", Bytes: der}
+	}
+	if err := os.WriteFile(filepath.Join(dir, kid+".pem"), pem.EncodeToMemory(block), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testClaims() jwt.RegisteredClaims {
+	return jwt.RegisteredClaims{Subject: "42", ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Hour))}
+}
+
+func sign(t *testing.T, method jwt.SigningMethod, kid string, key interface{}) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, testClaims())
+	if kid != "" {
+		token.Header["kid"] = kid
+	}
+	tokenString, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tokenString
+}
+
+// verify parses the token the way the server does
+func verify(ks *KeySet, tokenString string) error {
+	_, err := jwt.Parse(tokenString, ks.Keyfunc, jwt.WithValidMethods(ks.Methods()))
+	return err
+}
+
+func TestKeySetRotation(t *testing.T) {
+	oldKey, err := rsa.GenerateKey(rand.Reader, minRSABits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, newKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKey(t, dir, "2024-01", oldKey, true)
+	writeKey(t, dir, "2025-01", newKey, false)
+
+	ks, err := LoadDir(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// New tokens are signed with the newest private key
+	tokenString, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := jwt.Parse(tokenString, ks.Keyfunc, jwt.WithValidMethods(ks.Methods()))
+	if err != nil {
+		t.Fatalf("rejected our own token: %v", err)
+	}
+	if token.Header["kid"] != "2025-01" || token.Method.Alg() != "EdDSA" {
+		t.Errorf("signed with kid %v and %s, want 2025-01 and EdDSA", token.Header["kid"], token.Method.Alg())
+	}
+
+	// Tokens signed before the rotation stay valid until they expire
+	if err := verify(ks, sign(t, jwt.SigningMethodRS256, "2024-01", oldKey)); err != nil {
+		t.Errorf("rejected a token of the retired key: %v", err)
+	}
+
+	// Only a private key can sign
+	if _, err := LoadDir(dir, "2024-01"); err == nil {
+		t.Error("picked a retired key to sign with")
+	}
+}
+
+func TestKeyfuncRejects(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, minRSABits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, otherKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	writeKey(t, dir, "rsa", rsaKey, false)
+	ks, err := LoadDir(dir, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	publicPEM := pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: publicDER})
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{"HS256 with the RSA public key", sign(t, jwt.SigningMethodHS256, "rsa", publicPEM)},
+		{"HS256 with the RSA modulus", sign(t, jwt.SigningMethodHS256, "rsa", rsaKey.PublicKey.N.Bytes())},
+		{"alg none", sign(t, jwt.SigningMethodNone, "rsa", jwt.UnsafeAllowNoneSignatureType)},
+		{"unknown kid", sign(t, jwt.SigningMethodEdDSA, "other", otherKey)},
+		{"no kid", sign(t, jwt.SigningMethodRS256, "", rsaKey)},
+		{"other algorithm for the kid", sign(t, jwt.SigningMethodRS512, "rsa", rsaKey)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := verify(ks, tt.token); err == nil {
+				t.Error("token accepted")
+			}
+			// Keyfunc refuses on its own, whatever methods the caller allows
+			if _, err := jwt.Parse(tt.token, ks.Keyfunc); err == nil {
+				t.Error("token accepted without WithValidMethods")
+			}
+		})
+	}
+}
+
+func TestHMACKeySet(t *testing.T) {
+	ks := NewHMAC([]byte("secret"))
+	tokenString, err := ks.Sign(testClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := verify(ks, tokenString); err != nil {
+		t.Errorf("rejected our own token: %v", err)
+	}
+	if err := verify(ks, sign(t, jwt.SigningMethodHS256, "", []byte("other secret"))); err == nil {
+		t.Error("accepted a token signed with another secret")
+	}
+	if len(ks.JWKS().Keys) != 0 {
+		t.Error("published the HMAC secret")
+	}
+}
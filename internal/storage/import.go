@@ -0,0 +1,142 @@
+package storage
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// ImportJSON copies every user, chirp, session, token and identity from a
+// database.json file into the SQLite store, keeping their IDs. The import runs
+// in a single transaction, so a failure leaves the SQLite database untouched.
+func ImportJSON(jsonPath string, dst *SQLiteStore) (users int, chirps int, err error) {
+	// NewJSONStore would start an empty database at a mistyped path
+	if _, err := os.Stat(jsonPath); err != nil {
+		return 0, 0, fmt.Errorf("could not read JSON database: %v", err)
+	}
+	src, err := NewJSONStore(jsonPath)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer src.Close()
+
+	src.mu.RLock()
+	database := src.database
+	src.mu.RUnlock()
+
+	tx, err := dst.db.Begin()
+	if err != nil {
+		return 0, 0, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Users first so the chirps' author foreign keys resolve
+	for _, user := range database.Users {
+		_, err := tx.Exec(`INSERT INTO users (`+userColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`,
+			user.ID, user.Email, user.Password, user.IsChirpyRed, user.EmailVerified, toMicros(user.CreatedAt), toMicros(user.UpdatedAt),
+			nullMicros(user.TokensValidAfter), user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, strings.Join(user.RecoveryCodes, " "),
+			user.GetRole())
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not import user %d: %v", user.ID, err)
+		}
+	}
+
+	for _, chirp := range database.Chirps {
+		_, err := tx.Exec(`INSERT INTO chirps (`+chirpColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+			chirp.ID, chirp.Body, nullID(chirp.AuthorID), toMicros(chirp.CreatedAt), toMicros(chirp.UpdatedAt), chirp.Edited)
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not import chirp %d: %v", chirp.ID, err)
+		}
+
+		for _, revision := range database.Revisions[chirp.GetUniqueIdentifier()] {
+			_, err := tx.Exec(`INSERT INTO chirp_revisions (chirp_id, revision, body, created_at) VALUES (?, ?, ?, ?)`,
+				chirp.ID, revision.Revision, revision.Body, toMicros(revision.CreatedAt))
+			if err != nil {
+				return 0, 0, fmt.Errorf("could not import revision %d of chirp %d: %v", revision.Revision, chirp.ID, err)
+			}
+		}
+	}
+
+	// Sessions before their refresh tokens, so logins survive the move
+	for _, session := range database.Sessions {
+		_, err := tx.Exec(`INSERT INTO sessions (`+sessionColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?)`,
+			session.ID, session.UserID, session.UserAgent, session.IP, toMicros(session.CreatedAt), toMicros(session.LastUsedAt),
+			toMicros(session.ExpiresAt), nullMicros(session.RevokedAt))
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not import session %d: %v", session.ID, err)
+		}
+	}
+	for _, token := range database.RefreshTokens {
+		if err := insertRefreshToken(tx, token); err != nil {
+			return 0, 0, fmt.Errorf("could not import refresh token of session %d: %v", token.SessionID, err)
+		}
+	}
+
+	for _, token := range database.OneTimeTokens {
+		if err := insertOneTimeToken(tx, token); err != nil {
+			return 0, 0, fmt.Errorf("could not import one-time token of user %d: %v", token.UserID, err)
+		}
+	}
+	for tokenID, expiresAt := range database.DeniedTokens {
+		_, err := tx.Exec(`INSERT INTO denied_access_tokens (token_id, expires_at) VALUES (?, ?)`, tokenID, toMicros(expiresAt))
+		if err != nil {
+			return 0, 0, fmt.Errorf("could not import denied access token %s: %v", tokenID, err)
+		}
+	}
+
+	for _, identity := range database.Identities {
+		if err := insertIdentity(tx, identity); err != nil {
+			return 0, 0, fmt.Errorf("could not import %s identity of user %d: %v", identity.Provider, identity.UserID, err)
+		}
+	}
+
+	for _, token := range database.APITokens {
+		if _, err := insertAPIToken(tx, token); err != nil {
+			return 0, 0, fmt.Errorf("could not import API token %d: %v", token.ID, err)
+		}
+	}
+
+	// IDs of deleted rows must not be handed out again, so the sequences
+	// continue from the JSON store's, not from the highest imported ID
+	sequences := []struct {
+		table string
+		seq   int
+	}{
+		{"users", database.Sequences.Users},
+		{"chirps", database.Sequences.Chirps},
+		{"sessions", database.Sequences.Sessions},
+		{"api_tokens", database.Sequences.APITokens},
+	}
+	for _, sequence := range sequences {
+		if err := raiseSequence(tx, sequence.table, sequence.seq); err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, 0, fmt.Errorf("could not commit import: %v", err)
+	}
+	return len(database.Users), len(database.Chirps), nil
+}
+
+// raiseSequence makes the table's AUTOINCREMENT continue after seq, unless it
+// is already past it. sqlite_sequence has no unique constraint on name, so
+// this updates the row and inserts one only if there was none.
+func raiseSequence(tx *sql.Tx, table string, seq int) error {
+	result, err := tx.Exec(`UPDATE sqlite_sequence SET seq = max(seq, ?) WHERE name = ?`, seq, table)
+	if err != nil {
+		return fmt.Errorf("could not update %s sequence: %v", table, err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update %s sequence: %v", table, err)
+	}
+	if updated > 0 || seq == 0 {
+		return nil
+	}
+	if _, err := tx.Exec(`INSERT INTO sqlite_sequence (name, seq) VALUES (?, ?)`, table, seq); err != nil {
+		return fmt.Errorf("could not insert %s sequence: %v", table, err)
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+func TestImportJSONKeepsSequences(t *testing.T) {
+	dir := t.TempDir()
+	jsonPath := filepath.Join(dir, "database.json")
+
+	src, err := NewJSONStore(jsonPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	kept, err := src.CreateUser(handlers.User{Email: "kept@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CreateChirp(handlers.Chirp{Body: "kept", AuthorID: kept.ID}); err != nil {
+		t.Fatal(err)
+	}
+
+	// A deleted user takes the highest user, chirp, session and API token IDs
+	// with them; the SQLite store must not hand those IDs out again
+	deleted, err := src.CreateUser(handlers.User{Email: "deleted@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CreateChirp(handlers.Chirp{Body: "deleted", AuthorID: deleted.ID}); err != nil {
+		t.Fatal(err)
+	}
+	session := handlers.Session{UserID: deleted.ID, CreatedAt: now, LastUsedAt: now, ExpiresAt: now.Add(time.Hour)}
+	refreshToken := handlers.RefreshToken{Hash: "refresh", UserID: deleted.ID, CreatedAt: now, ExpiresAt: now.Add(time.Hour)}
+	if _, err := src.CreateSession(session, refreshToken); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.CreateAPIToken(handlers.APIToken{UserID: deleted.ID, Name: "bot", Hash: "api", CreatedAt: now}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := src.DeleteUser(deleted.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	if err := src.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	dst, err := NewSQLiteStore(filepath.Join(dir, "database.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	users, chirps, err := ImportJSON(jsonPath, dst)
+	if err != nil {
+		t.Fatalf("could not import: %v", err)
+	}
+	if users != 1 || chirps != 1 {
+		t.Errorf("imported %d users and %d chirps, want 1 and 1", users, chirps)
+	}
+
+	user, err := dst.CreateUser(handlers.User{Email: "new@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != deleted.ID+1 {
+		t.Errorf("new user got ID %d, want %d", user.ID, deleted.ID+1)
+	}
+	chirp, err := dst.CreateChirp(handlers.Chirp{Body: "new", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 3 {
+		t.Errorf("new chirp got ID %d, want 3", chirp.ID)
+	}
+	session.UserID, refreshToken.UserID, refreshToken.Hash = user.ID, user.ID, "refresh-2"
+	newSession, err := dst.CreateSession(session, refreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newSession.ID != 2 {
+		t.Errorf("new session got ID %d, want 2", newSession.ID)
+	}
+	apiToken, err := dst.CreateAPIToken(handlers.APIToken{UserID: user.ID, Name: "bot", Hash: "api-2", CreatedAt: now})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if apiToken.ID != 2 {
+		t.Errorf("new API token got ID %d, want 2", apiToken.ID)
+	}
+}
+
+func TestImportJSONMissingFile(t *testing.T) {
+	dir := t.TempDir()
+	dst, err := NewSQLiteStore(filepath.Join(dir, "database.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer dst.Close()
+
+	jsonPath := filepath.Join(dir, "missing.json")
+	if _, _, err := ImportJSON(jsonPath, dst); err == nil {
+		t.Error("imported a file that does not exist")
+	}
+	for _, path := range []string{jsonPath, jsonPath + ".wal"} {
+		if _, err := os.Stat(path); !os.IsNotExist(err) {
+			t.Errorf("import created %s", path)
+		}
+	}
+}
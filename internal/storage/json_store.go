@@ -0,0 +1,804 @@
+package storage
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+// checkpointEvery is how many logged mutations are allowed to pile up in the
+// write-ahead log before they are folded into the snapshot file
+const checkpointEvery = 100
+
+// JSONStore keeps the whole database in a single JSON file.
+//
+// The store is the only owner of the data: the file is read once when the
+// store is opened and from then on every read and write goes through the
+// in-memory copy under a single lock, so concurrent requests can never
+// overwrite each other's changes.
+//
+// Every mutation is appended to a write-ahead log (<file>.wal) and fsynced
+// before it is applied, and the log is periodically folded into the snapshot
+// file with an atomic write-and-rename. If the process dies in between, the
+// log is replayed the next time the store is opened, so an acknowledged write
+// is never lost and the snapshot is never half-written.
+type JSONStore struct {
+	filePath string
+	wal      *wal
+	mu       sync.RWMutex // Guards database, wal and pending
+	database handlers.Database
+	pending  int // Records in the WAL since the last checkpoint
+}
+
+// NewJSONStore opens the JSON file at filePath, replaying any mutations left
+// in its write-ahead log by a previous crash
+func NewJSONStore(filePath string) (*JSONStore, error) {
+	walLog, err := openWAL(filePath + ".wal")
+	if err != nil {
+		return nil, err
+	}
+
+	s := &JSONStore{filePath: filePath, wal: walLog}
+	if err := s.recover(); err != nil {
+		walLog.file.Close()
+		return nil, err
+	}
+	return s, nil
+}
+
+// Close folds the log into the snapshot and releases the write-ahead log
+func (s *JSONStore) Close() error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if err := s.checkpoint(); err != nil {
+		return err
+	}
+	return s.wal.file.Close()
+}
+
+// recover loads the snapshot, replays any logged mutations on top of it,
+// checkpoints the result and empties the log
+func (s *JSONStore) recover() error {
+	database, err := s.load()
+	if err != nil {
+		return err
+	}
+
+	records, err := s.wal.records()
+	if err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := rec.apply(&database); err != nil {
+			return fmt.Errorf("could not replay WAL: %v", err)
+		}
+	}
+
+	s.database = database
+	s.pending = len(records)
+	if err := s.checkpoint(); err != nil {
+		return err
+	}
+	// A torn last line is still in the log when there was nothing to fold
+	// in; the next append must not be joined onto it
+	return s.wal.reset()
+}
+
+// load reads the database file, returning an empty database if it does not exist yet
+func (s *JSONStore) load() (handlers.Database, error) {
+	database := handlers.Database{}
+
+	fileBytes, err := os.ReadFile(s.filePath)
+	if err != nil && !os.IsNotExist(err) {
+		return database, fmt.Errorf("could not read file: %v", err)
+	}
+	if err == nil && len(fileBytes) > 0 {
+		if err := json.Unmarshal(fileBytes, &database); err != nil {
+			return database, fmt.Errorf("could not unmarshal JSON: %v", err)
+		}
+	}
+
+	// Ensure the maps are initialized if they're null
+	if database.Chirps == nil {
+		database.Chirps = make(map[string]handlers.Chirp)
+	}
+	if database.Users == nil {
+		database.Users = make(map[string]handlers.User)
+	}
+	if database.Revisions == nil {
+		database.Revisions = make(map[string][]handlers.ChirpRevision)
+	}
+	if database.Sessions == nil {
+		database.Sessions = make(map[string]handlers.Session)
+	}
+	if database.RefreshTokens == nil {
+		database.RefreshTokens = make(map[string]handlers.RefreshToken)
+	}
+	if database.DeniedTokens == nil {
+		database.DeniedTokens = make(map[string]time.Time)
+	}
+	if database.OneTimeTokens == nil {
+		database.OneTimeTokens = make(map[string]handlers.OneTimeToken)
+	}
+	if database.Identities == nil {
+		database.Identities = make(map[string]handlers.Identity)
+	}
+	if database.APITokens == nil {
+		database.APITokens = make(map[string]handlers.APIToken)
+	}
+
+	// Files written before sequences were stored only have the records, so
+	// never hand out an ID at or below one that is already in use
+	for _, chirp := range database.Chirps {
+		database.Sequences.Chirps = max(database.Sequences.Chirps, chirp.GetID())
+	}
+	for _, user := range database.Users {
+		database.Sequences.Users = max(database.Sequences.Users, user.GetID())
+	}
+	for _, session := range database.Sessions {
+		database.Sequences.Sessions = max(database.Sequences.Sessions, session.GetID())
+	}
+	for _, token := range database.APITokens {
+		database.Sequences.APITokens = max(database.Sequences.APITokens, token.GetID())
+	}
+
+	// Records written before timestamps existed are dated to the file's last
+	// modification, the latest point at which we know they existed
+	if fileInfo, err := os.Stat(s.filePath); err == nil {
+		backfill := fileInfo.ModTime().UTC().Truncate(time.Microsecond)
+		for key, chirp := range database.Chirps {
+			if chirp.CreatedAt.IsZero() {
+				chirp.CreatedAt, chirp.UpdatedAt = backfill, backfill
+				database.Chirps[key] = chirp
+			}
+		}
+		for key, user := range database.Users {
+			if user.CreatedAt.IsZero() {
+				user.CreatedAt, user.UpdatedAt = backfill, backfill
+				database.Users[key] = user
+			}
+		}
+	}
+
+	return database, nil
+}
+
+// checkpoint atomically rewrites the snapshot and empties the log.
+// The caller must hold s.mu.
+func (s *JSONStore) checkpoint() error {
+	if s.pending == 0 {
+		return nil
+	}
+
+	// Denied and one-time tokens that have expired can no longer be used, so
+	// there is no point in keeping them around
+	now := time.Now()
+	for tokenID, expiresAt := range s.database.DeniedTokens {
+		if !now.Before(expiresAt) {
+			delete(s.database.DeniedTokens, tokenID)
+		}
+	}
+	for hash, token := range s.database.OneTimeTokens {
+		if !now.Before(token.ExpiresAt) {
+			delete(s.database.OneTimeTokens, hash)
+		}
+	}
+
+	fileBytes, err := json.MarshalIndent(s.database, "", "  ")
+	if err != nil {
+		return fmt.Errorf("could not marshal JSON: %v", err)
+	}
+	if err := writeFileAtomic(s.filePath, fileBytes, 0644); err != nil {
+		return err
+	}
+	if err := s.wal.reset(); err != nil {
+		return err
+	}
+	s.pending = 0
+	return nil
+}
+
+// commit makes the records durable in the log and applies them to the
+// in-memory database. The caller must hold s.mu for writing.
+func (s *JSONStore) commit(records ...walRecord) error {
+	if err := s.wal.append(records...); err != nil {
+		return err
+	}
+	for _, rec := range records {
+		if err := rec.apply(&s.database); err != nil {
+			return err
+		}
+	}
+
+	s.pending += len(records)
+	if s.pending >= checkpointEvery {
+		// The mutation is already durable in the log, so a failed checkpoint
+		// is retried on the next commit rather than failing this one
+		s.checkpoint()
+	}
+	return nil
+}
+
+// emailTaken reports whether another user already has the email.
+// The caller must hold s.mu.
+func (s *JSONStore) emailTaken(email string, exceptID int) bool {
+	for _, existingUser := range s.database.Users {
+		if existingUser.GetUniqueIdentifier() == email && existingUser.GetID() != exceptID {
+			return true
+		}
+	}
+	return false
+}
+
+func (s *JSONStore) CreateUser(user handlers.User) (handlers.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.GetUniqueIdentifier(), 0) {
+		return handlers.User{}, ErrAlreadyExists
+	}
+
+	// Applying the record advances the sequence past the new ID
+	user.ID = s.database.Sequences.Users + 1
+	user.Role = user.GetRole()
+	user.CreatedAt = timestamp()
+	user.UpdatedAt = user.CreatedAt
+	if err := s.commit(walRecord{Op: opPutUser, User: &user}); err != nil {
+		return handlers.User{}, err
+	}
+	return user, nil
+}
+
+func (s *JSONStore) GetUser(id int) (handlers.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	user, exists := s.database.Users[strconv.Itoa(id)]
+	if !exists {
+		return handlers.User{}, ErrNotFound
+	}
+	return user, nil
+}
+
+func (s *JSONStore) GetUserByEmail(email string) (handlers.User, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, user := range s.database.Users {
+		if user.GetUniqueIdentifier() == email {
+			return user, nil
+		}
+	}
+	return handlers.User{}, ErrNotFound
+}
+
+func (s *JSONStore) UpdateUser(id int, fn func(user *handlers.User) error) (handlers.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	user, err := s.updatedUser(id, fn)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	if err := s.commit(walRecord{Op: opPutUser, User: &user}); err != nil {
+		return handlers.User{}, err
+	}
+	return user, nil
+}
+
+// updatedUser returns the user with fn applied, without saving it.
+// The caller must hold s.mu.
+func (s *JSONStore) updatedUser(id int, fn func(user *handlers.User) error) (handlers.User, error) {
+	user, exists := s.database.Users[strconv.Itoa(id)]
+	if !exists {
+		return handlers.User{}, ErrNotFound
+	}
+
+	if err := fn(&user); err != nil {
+		return handlers.User{}, err
+	}
+	// The callback must not move the record to another ID or rewrite its history
+	user.ID = id
+	user.CreatedAt = s.database.Users[strconv.Itoa(id)].CreatedAt
+	user.UpdatedAt = timestamp()
+
+	if s.emailTaken(user.GetUniqueIdentifier(), id) {
+		return handlers.User{}, ErrAlreadyExists
+	}
+	return user, nil
+}
+
+func (s *JSONStore) DeleteUser(id int, keepChirps bool) ([]int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.database.Users[strconv.Itoa(id)]; !exists {
+		return nil, ErrNotFound
+	}
+
+	var chirpIDs []int
+	if !keepChirps {
+		for _, chirp := range s.database.Chirps {
+			if chirp.AuthorID == id {
+				chirpIDs = append(chirpIDs, chirp.ID)
+			}
+		}
+		sort.Ints(chirpIDs)
+	}
+
+	if err := s.commit(walRecord{Op: opDeleteUser, ID: id, KeepChirps: keepChirps}); err != nil {
+		return nil, err
+	}
+	return chirpIDs, nil
+}
+
+func (s *JSONStore) CreateChirp(chirp handlers.Chirp) (handlers.Chirp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	// Applying the record advances the sequence past the new ID
+	chirp.SetID(s.database.Sequences.Chirps + 1)
+	chirp.CreatedAt = timestamp()
+	chirp.UpdatedAt = chirp.CreatedAt
+	if err := s.commit(walRecord{Op: opPutChirp, Chirp: &chirp}); err != nil {
+		return handlers.Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (s *JSONStore) GetChirp(id int) (handlers.Chirp, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chirp, exists := s.database.Chirps[strconv.Itoa(id)]
+	if !exists {
+		return handlers.Chirp{}, ErrNotFound
+	}
+	return chirp, nil
+}
+
+func (s *JSONStore) ListChirps(query ChirpQuery) ([]handlers.Chirp, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	chirps := []handlers.Chirp{}
+	for _, chirp := range s.database.Chirps {
+		if query.Matches(chirp) {
+			chirps = append(chirps, chirp)
+		}
+	}
+
+	sort.Slice(chirps, func(i, j int) bool {
+		if query.Desc {
+			return PositionOf(chirps[j]).Before(PositionOf(chirps[i]))
+		}
+		return PositionOf(chirps[i]).Before(PositionOf(chirps[j]))
+	})
+
+	if query.Limit > 0 && len(chirps) > query.Limit {
+		chirps = chirps[:query.Limit]
+	}
+	return chirps, nil
+}
+
+func (s *JSONStore) UpdateChirp(id int, fn func(chirp *handlers.Chirp) error) (handlers.Chirp, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	key := strconv.Itoa(id)
+	previous, exists := s.database.Chirps[key]
+	if !exists {
+		return handlers.Chirp{}, ErrNotFound
+	}
+
+	chirp := previous
+	if err := fn(&chirp); err != nil {
+		return handlers.Chirp{}, err
+	}
+	// Only the body can change
+	chirp.ID, chirp.AuthorID, chirp.CreatedAt = previous.ID, previous.AuthorID, previous.CreatedAt
+	if chirp.Body == previous.Body {
+		return previous, nil
+	}
+
+	chirp.Edited = true
+	chirp.UpdatedAt = timestamp()
+	revision := handlers.ChirpRevision{
+		ChirpID:   id,
+		Revision:  len(s.database.Revisions[key]) + 1,
+		Body:      previous.Body,
+		CreatedAt: previous.UpdatedAt,
+	}
+	if err := s.commit(walRecord{Op: opEditChirp, Chirp: &chirp, Revision: &revision}); err != nil {
+		return handlers.Chirp{}, err
+	}
+	return chirp, nil
+}
+
+func (s *JSONStore) ListChirpRevisions(chirpID int) ([]handlers.ChirpRevision, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	key := strconv.Itoa(chirpID)
+	if _, exists := s.database.Chirps[key]; !exists {
+		return nil, ErrNotFound
+	}
+
+	revisions := make([]handlers.ChirpRevision, len(s.database.Revisions[key]))
+	copy(revisions, s.database.Revisions[key])
+	return revisions, nil
+}
+
+func (s *JSONStore) DeleteChirp(id int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.database.Chirps[strconv.Itoa(id)]; !exists {
+		return ErrNotFound
+	}
+
+	return s.commit(walRecord{Op: opDeleteChirp, ID: id})
+}
+
+func (s *JSONStore) CreateSession(session handlers.Session, token handlers.RefreshToken) (handlers.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.database.Users[strconv.Itoa(session.UserID)]; !exists {
+		return handlers.Session{}, ErrNotFound
+	}
+
+	// Applying the record advances the sequence past the new ID
+	session.ID = s.database.Sequences.Sessions + 1
+	session.CreatedAt = timestamp()
+	session.LastUsedAt = session.CreatedAt
+	session.ExpiresAt = token.ExpiresAt
+	session.RevokedAt = time.Time{}
+
+	token.SessionID, token.UserID, token.CreatedAt = session.ID, session.UserID, session.CreatedAt
+	if err := s.commit(walRecord{Op: opPutSession, Session: &session, RefreshTokens: []handlers.RefreshToken{token}}); err != nil {
+		return handlers.Session{}, err
+	}
+	return session, nil
+}
+
+func (s *JSONStore) GetSession(id int) (handlers.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	session, exists := s.database.Sessions[strconv.Itoa(id)]
+	if !exists {
+		return handlers.Session{}, ErrNotFound
+	}
+	return session, nil
+}
+
+func (s *JSONStore) RotateRefreshToken(tokenHash string, next handlers.RefreshToken, ip, userAgent string) (handlers.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.database.RefreshTokens[tokenHash]
+	if !exists {
+		return handlers.Session{}, ErrNotFound
+	}
+	session, exists := s.database.Sessions[strconv.Itoa(token.SessionID)]
+	if !exists {
+		return handlers.Session{}, ErrNotFound
+	}
+
+	now := timestamp()
+	if !session.RevokedAt.IsZero() {
+		return handlers.Session{}, ErrRevoked
+	}
+	if !token.UsedAt.IsZero() {
+		// Someone is holding a copy of an old token, so nobody can be trusted
+		// with this session any more
+		session.RevokedAt = now
+		if err := s.commit(walRecord{Op: opPutSession, Session: &session}); err != nil {
+			return handlers.Session{}, err
+		}
+		return handlers.Session{}, ErrTokenReused
+	}
+	if !now.Before(token.ExpiresAt) {
+		return handlers.Session{}, ErrTokenExpired
+	}
+
+	token.UsedAt = now
+	next.SessionID, next.UserID, next.CreatedAt = session.ID, session.UserID, now
+	session.LastUsedAt, session.ExpiresAt = now, next.ExpiresAt
+	session.IP, session.UserAgent = ip, userAgent
+	if err := s.commit(walRecord{Op: opPutSession, Session: &session, RefreshTokens: []handlers.RefreshToken{token, next}}); err != nil {
+		return handlers.Session{}, err
+	}
+	return session, nil
+}
+
+func (s *JSONStore) RevokeSessionByRefreshToken(tokenHash string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.database.RefreshTokens[tokenHash]
+	if !exists {
+		return ErrNotFound
+	}
+	session, exists := s.database.Sessions[strconv.Itoa(token.SessionID)]
+	if !exists {
+		return ErrNotFound
+	}
+	if !session.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	session.RevokedAt = timestamp()
+	return s.commit(walRecord{Op: opPutSession, Session: &session})
+}
+
+func (s *JSONStore) ListSessions(userID int) ([]handlers.Session, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	sessions := []handlers.Session{}
+	for _, session := range s.database.Sessions {
+		if session.UserID == userID {
+			sessions = append(sessions, session)
+		}
+	}
+	sortSessions(sessions)
+	return sessions, nil
+}
+
+func (s *JSONStore) RevokeSession(userID, sessionID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	session, exists := s.database.Sessions[strconv.Itoa(sessionID)]
+	if !exists || session.UserID != userID {
+		return ErrNotFound
+	}
+	if !session.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	session.RevokedAt = timestamp()
+	return s.commit(walRecord{Op: opPutSession, Session: &session})
+}
+
+func (s *JSONStore) RevokeAllSessions(userID, exceptSessionID int) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := timestamp()
+	var records []walRecord
+	for _, session := range s.database.Sessions {
+		if session.UserID != userID || session.ID == exceptSessionID || !session.Active(now) {
+			continue
+		}
+		session.RevokedAt = now
+		records = append(records, walRecord{Op: opPutSession, Session: &session})
+	}
+	if len(records) == 0 {
+		return 0, nil
+	}
+
+	if err := s.commit(records...); err != nil {
+		return 0, err
+	}
+	return len(records), nil
+}
+
+func (s *JSONStore) DenyAccessToken(tokenID string, expiresAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	expiresAt = expiresAt.UTC().Truncate(time.Microsecond)
+	return s.commit(walRecord{Op: opDenyToken, TokenID: tokenID, ExpiresAt: &expiresAt})
+}
+
+func (s *JSONStore) IsAccessTokenDenied(tokenID string) (bool, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	expiresAt, exists := s.database.DeniedTokens[tokenID]
+	return exists && time.Now().Before(expiresAt), nil
+}
+
+func (s *JSONStore) CreateOneTimeToken(token handlers.OneTimeToken) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.database.Users[strconv.Itoa(token.UserID)]; !exists {
+		return ErrNotFound
+	}
+
+	now := timestamp()
+	tokens := []handlers.OneTimeToken{}
+	for _, previous := range s.database.OneTimeTokens {
+		if previous.UserID == token.UserID && previous.Purpose == token.Purpose && previous.UsedAt.IsZero() && now.Before(previous.ExpiresAt) {
+			previous.ExpiresAt = now
+			tokens = append(tokens, previous)
+		}
+	}
+
+	token.CreatedAt = now
+	tokens = append(tokens, token)
+	return s.commit(walRecord{Op: opPutTokens, OneTimeTokens: tokens})
+}
+
+func (s *JSONStore) RedeemOneTimeToken(tokenHash, purpose string, fn func(user *handlers.User) error) (handlers.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.database.OneTimeTokens[tokenHash]
+	if !exists || token.Purpose != purpose {
+		return handlers.User{}, ErrNotFound
+	}
+	if !token.UsedAt.IsZero() {
+		return handlers.User{}, ErrTokenReused
+	}
+	now := timestamp()
+	if !now.Before(token.ExpiresAt) {
+		return handlers.User{}, ErrTokenExpired
+	}
+
+	user, err := s.updatedUser(token.UserID, fn)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	token.UsedAt = now
+	if err := s.commit(walRecord{Op: opPutTokens, OneTimeTokens: []handlers.OneTimeToken{token}, User: &user}); err != nil {
+		return handlers.User{}, err
+	}
+	return user, nil
+}
+
+func (s *JSONStore) GetIdentity(provider, subject string) (handlers.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identity, exists := s.database.Identities[handlers.Identity{Provider: provider, Subject: subject}.Key()]
+	if !exists {
+		return handlers.Identity{}, ErrNotFound
+	}
+	return identity, nil
+}
+
+func (s *JSONStore) ListIdentities(userID int) ([]handlers.Identity, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	identities := []handlers.Identity{}
+	for _, identity := range s.database.Identities {
+		if identity.UserID == userID {
+			identities = append(identities, identity)
+		}
+	}
+	sort.Slice(identities, func(i, j int) bool {
+		if !identities[i].CreatedAt.Equal(identities[j].CreatedAt) {
+			return identities[i].CreatedAt.Before(identities[j].CreatedAt)
+		}
+		return identities[i].Key() < identities[j].Key()
+	})
+	return identities, nil
+}
+
+func (s *JSONStore) LinkIdentity(identity handlers.Identity) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.database.Users[strconv.Itoa(identity.UserID)]; !exists {
+		return ErrNotFound
+	}
+	if _, exists := s.database.Identities[identity.Key()]; exists {
+		return ErrAlreadyExists
+	}
+
+	identity.CreatedAt = timestamp()
+	return s.commit(walRecord{Op: opPutIdentity, Identity: &identity})
+}
+
+func (s *JSONStore) CreateUserWithIdentity(user handlers.User, identity handlers.Identity) (handlers.User, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if s.emailTaken(user.GetUniqueIdentifier(), 0) {
+		return handlers.User{}, ErrAlreadyExists
+	}
+	if _, exists := s.database.Identities[identity.Key()]; exists {
+		return handlers.User{}, ErrAlreadyExists
+	}
+
+	// Applying the record advances the sequence past the new ID
+	user.ID = s.database.Sequences.Users + 1
+	user.Role = user.GetRole()
+	user.CreatedAt = timestamp()
+	user.UpdatedAt = user.CreatedAt
+	identity.UserID = user.ID
+	identity.CreatedAt = user.CreatedAt
+	if err := s.commit(walRecord{Op: opPutIdentity, Identity: &identity, User: &user}); err != nil {
+		return handlers.User{}, err
+	}
+	return user, nil
+}
+
+func (s *JSONStore) CreateAPIToken(token handlers.APIToken) (handlers.APIToken, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	if _, exists := s.database.Users[strconv.Itoa(token.UserID)]; !exists {
+		return handlers.APIToken{}, ErrNotFound
+	}
+
+	// Applying the record advances the sequence past the new ID
+	token.ID = s.database.Sequences.APITokens + 1
+	token.CreatedAt = timestamp()
+	token.LastUsedAt = time.Time{}
+	token.RevokedAt = time.Time{}
+	if err := s.commit(walRecord{Op: opPutAPIToken, APIToken: &token}); err != nil {
+		return handlers.APIToken{}, err
+	}
+	return token, nil
+}
+
+func (s *JSONStore) GetAPITokenByHash(tokenHash string) (handlers.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	for _, token := range s.database.APITokens {
+		if token.Hash == tokenHash {
+			return token, nil
+		}
+	}
+	return handlers.APIToken{}, ErrNotFound
+}
+
+func (s *JSONStore) ListAPITokens(userID int) ([]handlers.APIToken, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	tokens := []handlers.APIToken{}
+	for _, token := range s.database.APITokens {
+		if token.UserID == userID {
+			tokens = append(tokens, token)
+		}
+	}
+	sortAPITokens(tokens)
+	return tokens, nil
+}
+
+func (s *JSONStore) RevokeAPIToken(userID, tokenID int) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.database.APITokens[strconv.Itoa(tokenID)]
+	if !exists || token.UserID != userID {
+		return ErrNotFound
+	}
+	if !token.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	token.RevokedAt = timestamp()
+	return s.commit(walRecord{Op: opPutAPIToken, APIToken: &token})
+}
+
+func (s *JSONStore) TouchAPIToken(tokenID int, usedAt time.Time) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	token, exists := s.database.APITokens[strconv.Itoa(tokenID)]
+	if !exists {
+		return ErrNotFound
+	}
+
+	token.LastUsedAt = usedAt.UTC().Truncate(time.Microsecond)
+	return s.commit(walRecord{Op: opPutAPIToken, APIToken: &token})
+}
@@ -0,0 +1,39 @@
+package storage
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+func TestJSONStoreBackfillsSequences(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+
+	// A file from before sequences were stored
+	legacy := `{
+  "users": {"3": {"id": 3, "email": "user@example.com", "password": "hash"}},
+  "chirps": {"7": {"id": 7, "body": "hello", "author_id": 3}},
+  "sessions": {"4": {"id": 4, "user_id": 3}},
+  "api_tokens": {"5": {"id": 5, "user_id": 3, "name": "bot", "hash": "api"}}
+}`
+	if err := os.WriteFile(filePath, []byte(legacy), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openJSONStore(t, filePath)
+	defer s.Close()
+	want := handlers.Sequences{Chirps: 7, Users: 3, Sessions: 4, APITokens: 5}
+	if s.database.Sequences != want {
+		t.Errorf("got sequences %+v, want %+v", s.database.Sequences, want)
+	}
+
+	token, err := s.CreateAPIToken(handlers.APIToken{UserID: 3, Name: "bot", Hash: "api-2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if token.ID != 6 {
+		t.Errorf("new API token got ID %d, want 6", token.ID)
+	}
+}
@@ -0,0 +1,230 @@
+package storage
+
+import (
+	"database/sql"
+	"path/filepath"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	t.Helper()
+	db, err := openSQLite(filepath.Join(t.TempDir(), "database.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	return db
+}
+
+func schemaVersion(t *testing.T, db *sql.DB) (version, count int) {
+	t.Helper()
+	err := db.QueryRow(`SELECT COALESCE(MAX(version), 0), COUNT(*) FROM schema_migrations`).Scan(&version, &count)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return version, count
+}
+
+func TestMigrateEmptyDatabase(t *testing.T) {
+	migrations, err := loadMigrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	latest := migrations[len(migrations)-1].version
+
+	db := openTestDB(t)
+	if err := migrate(db); err != nil {
+		t.Fatalf("could not migrate empty database: %v", err)
+	}
+	if version, count := schemaVersion(t, db); version != latest || count != len(migrations) {
+		t.Errorf("schema at version %d with %d migrations, want %d and %d", version, count, latest, len(migrations))
+	}
+
+	// Starting the server again finds nothing to do
+	if err := migrate(db); err != nil {
+		t.Fatalf("could not migrate up-to-date database: %v", err)
+	}
+	if version, count := schemaVersion(t, db); version != latest || count != len(migrations) {
+		t.Errorf("second run left version %d with %d migrations, want %d and %d", version, count, latest, len(migrations))
+	}
+
+	// A schema from a newer release is left alone
+	if _, err := db.Exec(`INSERT INTO schema_migrations (version, name, applied_at) VALUES (?, 'future.sql', 0)`, latest+1); err != nil {
+		t.Fatal(err)
+	}
+	if err := migrate(db); err == nil {
+		t.Error("migrated a database with a newer schema")
+	}
+}
+
+func TestMigrateKeepsData(t *testing.T) {
+	db := openTestDB(t)
+
+	// The schema as released with chirp revisions
+	if err := migrateTo(db, 3); err != nil {
+		t.Fatalf("could not migrate to version 3: %v", err)
+	}
+	seed := []string{
+		`INSERT INTO users (id, email, password, is_chirpy_red, created_at, updated_at) VALUES
+			(1, 'one@example.com', 'hash', 1, 1000000, 1000000),
+			(2, 'two@example.com', 'hash', 0, 2000000, 2000000),
+			(3, 'three@example.com', 'hash', 0, 3000000, 3000000)`,
+		`INSERT INTO chirps (id, body, author_id, created_at, updated_at, edited) VALUES
+			(1, 'edited', 1, 1000000, 1500000, 1),
+			(2, 'by two', 2, 2000000, 2000000, 0),
+			(3, 'also by two', 2, 3000000, 3000000, 0),
+			(4, 'deleted', 1, 4000000, 4000000, 0)`,
+		`INSERT INTO chirp_revisions (chirp_id, revision, body, created_at) VALUES
+			(1, 1, 'original', 1000000),
+			(2, 1, 'first draft', 2000000)`,
+		// The highest IDs are gone, but must not be handed out again
+		`DELETE FROM chirps WHERE id = 4`,
+		`DELETE FROM users WHERE id = 3`,
+	}
+	for _, statement := range seed {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("could not seed: %v", err)
+		}
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("could not migrate to head: %v", err)
+	}
+	store := &SQLiteStore{db: db}
+
+	user, err := store.GetUser(1)
+	if err != nil {
+		t.Fatalf("user lost: %v", err)
+	}
+	if user.Email != "one@example.com" || !user.IsChirpyRed || user.GetRole() != handlers.RoleUser {
+		t.Errorf("got user %+v", user)
+	}
+	chirps, err := store.ListChirps(ChirpQuery{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(chirps) != 3 {
+		t.Errorf("got %d chirps, want 3", len(chirps))
+	}
+	chirp, err := store.GetChirp(1)
+	if err != nil {
+		t.Fatalf("chirp lost: %v", err)
+	}
+	if chirp.Body != "edited" || chirp.AuthorID != 1 || !chirp.Edited {
+		t.Errorf("got chirp %+v", chirp)
+	}
+	revisions, err := store.ListChirpRevisions(1)
+	if err != nil || len(revisions) != 1 || revisions[0].Body != "original" {
+		t.Errorf("got revisions %+v (%v), want the original body", revisions, err)
+	}
+
+	sequences := map[string]int{}
+	rows, err := db.Query(`SELECT name, seq FROM sqlite_sequence`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for rows.Next() {
+		var name string
+		var seq int
+		if err := rows.Scan(&name, &seq); err != nil {
+			t.Fatal(err)
+		}
+		sequences[name] = seq
+	}
+	rows.Close()
+	if sequences["users"] != 3 || sequences["chirps"] != 4 {
+		t.Errorf("got sequences %v, want users 3 and chirps 4", sequences)
+	}
+	if _, ok := sequences["chirps_new"]; ok {
+		t.Error("the rebuilt table's sequence was left behind")
+	}
+
+	var violations int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM pragma_foreign_key_check`).Scan(&violations); err != nil {
+		t.Fatal(err)
+	}
+	if violations != 0 {
+		t.Errorf("%d foreign key violations after migrating", violations)
+	}
+
+	newChirp, err := store.CreateChirp(handlers.Chirp{Body: "new", AuthorID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if newChirp.ID != 5 {
+		t.Errorf("new chirp got ID %d, want 5", newChirp.ID)
+	}
+
+	// Deleting a user keeps their chirps without an author, with revisions
+	if _, err := db.Exec(`DELETE FROM users WHERE id = 2`); err != nil {
+		t.Fatal(err)
+	}
+	var authorless, revisionCount int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM chirps WHERE id IN (2, 3) AND author_id IS NULL`).Scan(&authorless); err != nil {
+		t.Fatal(err)
+	}
+	if authorless != 2 {
+		t.Errorf("%d of the deleted user's chirps kept without an author, want 2", authorless)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM chirp_revisions WHERE chirp_id = 2`).Scan(&revisionCount); err != nil {
+		t.Fatal(err)
+	}
+	if revisionCount != 1 {
+		t.Errorf("%d revisions left of an authorless chirp, want 1", revisionCount)
+	}
+
+	// Deleting a chirp still takes its revisions with it
+	if err := store.DeleteChirp(1); err != nil {
+		t.Fatal(err)
+	}
+	if err := db.QueryRow(`SELECT COUNT(*) FROM chirp_revisions WHERE chirp_id = 1`).Scan(&revisionCount); err != nil {
+		t.Fatal(err)
+	}
+	if revisionCount != 0 {
+		t.Errorf("%d revisions left of a deleted chirp, want 0", revisionCount)
+	}
+}
+
+func TestMigrateKeepsSequenceWithoutChirps(t *testing.T) {
+	db := openTestDB(t)
+
+	// Every chirp was deleted before the chirps table is rebuilt
+	if err := migrateTo(db, 11); err != nil {
+		t.Fatalf("could not migrate to version 11: %v", err)
+	}
+	seed := []string{
+		`INSERT INTO users (id, email, password, created_at, updated_at) VALUES (1, 'one@example.com', 'hash', 1000000, 1000000)`,
+		`INSERT INTO chirps (id, body, author_id, created_at, updated_at) VALUES
+			(1, 'one', 1, 1000000, 1000000),
+			(2, 'two', 1, 2000000, 2000000),
+			(3, 'three', 1, 3000000, 3000000)`,
+		`DELETE FROM chirps`,
+	}
+	for _, statement := range seed {
+		if _, err := db.Exec(statement); err != nil {
+			t.Fatalf("could not seed: %v", err)
+		}
+	}
+
+	if err := migrate(db); err != nil {
+		t.Fatalf("could not migrate to head: %v", err)
+	}
+	var entries int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_sequence WHERE name = 'chirps'`).Scan(&entries); err != nil {
+		t.Fatal(err)
+	}
+	if entries != 1 {
+		t.Errorf("%d sqlite_sequence entries for chirps, want 1", entries)
+	}
+
+	store := &SQLiteStore{db: db}
+	chirp, err := store.CreateChirp(handlers.Chirp{Body: "new", AuthorID: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != 4 {
+		t.Errorf("new chirp got ID %d, want 4", chirp.ID)
+	}
+}
@@ -0,0 +1,18 @@
+CREATE TABLE users (
+    id                       INTEGER PRIMARY KEY AUTOINCREMENT,
+    email                    TEXT    NOT NULL UNIQUE,
+    password                 TEXT    NOT NULL,
+    refresh_token            TEXT,
+    refresh_token_expires_at TIMESTAMP,
+    is_chirpy_red            BOOLEAN NOT NULL DEFAULT 0
+);
+
+CREATE INDEX idx_users_refresh_token ON users (refresh_token);
+
+CREATE TABLE chirps (
+    id        INTEGER PRIMARY KEY AUTOINCREMENT,
+    body      TEXT    NOT NULL,
+    author_id INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE
+);
+
+CREATE INDEX idx_chirps_author_id ON chirps (author_id);
@@ -0,0 +1,12 @@
+-- Timestamps are stored as Unix microseconds so range filters and the
+-- (created_at, id) keyset cursor compare plain integers.
+ALTER TABLE users ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN created_at INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE chirps ADD COLUMN updated_at INTEGER NOT NULL DEFAULT 0;
+
+-- Rows that predate timestamps get the time of this migration
+UPDATE users SET created_at = strftime('%s', 'now') * 1000000, updated_at = strftime('%s', 'now') * 1000000;
+UPDATE chirps SET created_at = strftime('%s', 'now') * 1000000, updated_at = strftime('%s', 'now') * 1000000;
+
+CREATE INDEX idx_chirps_created_at ON chirps (created_at, id);
@@ -0,0 +1,9 @@
+ALTER TABLE chirps ADD COLUMN edited BOOLEAN NOT NULL DEFAULT 0;
+
+CREATE TABLE chirp_revisions (
+    chirp_id   INTEGER NOT NULL REFERENCES chirps (id) ON DELETE CASCADE,
+    revision   INTEGER NOT NULL,
+    body       TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (chirp_id, revision)
+);
@@ -0,0 +1,30 @@
+-- Refresh tokens move out of users into per-device sessions. Tokens are now
+-- stored hashed, so the old plain-text tokens cannot be carried over and
+-- everybody has to log in again once.
+CREATE TABLE sessions (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    user_agent   TEXT    NOT NULL DEFAULT '',
+    ip           TEXT    NOT NULL DEFAULT '',
+    created_at   INTEGER NOT NULL,
+    last_used_at INTEGER NOT NULL,
+    expires_at   INTEGER NOT NULL,
+    revoked_at   INTEGER
+);
+
+CREATE INDEX idx_sessions_user_id ON sessions (user_id);
+
+CREATE TABLE refresh_tokens (
+    token_hash TEXT    PRIMARY KEY,
+    session_id INTEGER NOT NULL REFERENCES sessions (id) ON DELETE CASCADE,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER
+);
+
+CREATE INDEX idx_refresh_tokens_session_id ON refresh_tokens (session_id);
+
+DROP INDEX idx_users_refresh_token;
+ALTER TABLE users DROP COLUMN refresh_token;
+ALTER TABLE users DROP COLUMN refresh_token_expires_at;
@@ -0,0 +1,12 @@
+-- Access tokens issued before tokens_valid_after are rejected, which is how a
+-- password change logs out every token that was handed out with the old one.
+ALTER TABLE users ADD COLUMN tokens_valid_after INTEGER;
+
+-- Single access tokens revoked before they expire, keyed by their jti claim.
+-- Rows are dropped once the token would have expired anyway.
+CREATE TABLE denied_access_tokens (
+    token_id   TEXT    PRIMARY KEY,
+    expires_at INTEGER NOT NULL
+);
+
+CREATE INDEX idx_denied_access_tokens_expires_at ON denied_access_tokens (expires_at);
@@ -0,0 +1,12 @@
+-- Single-use tokens sent by email, such as password reset links. Only their
+-- hash is stored.
+CREATE TABLE one_time_tokens (
+    token_hash TEXT    PRIMARY KEY,
+    purpose    TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    created_at INTEGER NOT NULL,
+    expires_at INTEGER NOT NULL,
+    used_at    INTEGER
+);
+
+CREATE INDEX idx_one_time_tokens_user_id ON one_time_tokens (user_id, purpose);
@@ -0,0 +1,3 @@
+-- Addresses are unverified until the emailed link is opened. Existing
+-- accounts start unverified too and can ask for a new link.
+ALTER TABLE users ADD COLUMN email_verified INTEGER NOT NULL DEFAULT 0;
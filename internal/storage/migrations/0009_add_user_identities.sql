@@ -0,0 +1,12 @@
+-- Accounts at OpenID Connect providers linked to users. The provider's
+-- subject identifies the account for as long as it exists.
+CREATE TABLE user_identities (
+    provider   TEXT    NOT NULL,
+    subject    TEXT    NOT NULL,
+    user_id    INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    email      TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (provider, subject)
+);
+
+CREATE INDEX idx_user_identities_user_id ON user_identities (user_id);
@@ -0,0 +1,15 @@
+-- Personal API tokens for bots and integrations. Only their hash is stored;
+-- scopes are separated by spaces.
+CREATE TABLE api_tokens (
+    id           INTEGER PRIMARY KEY AUTOINCREMENT,
+    user_id      INTEGER NOT NULL REFERENCES users (id) ON DELETE CASCADE,
+    name         TEXT    NOT NULL,
+    token_hash   TEXT    NOT NULL UNIQUE,
+    scopes       TEXT    NOT NULL,
+    created_at   INTEGER NOT NULL,
+    last_used_at INTEGER,
+    expires_at   INTEGER,
+    revoked_at   INTEGER
+);
+
+CREATE INDEX idx_api_tokens_user_id ON api_tokens (user_id);
@@ -0,0 +1,3 @@
+-- Roles for access control: user, moderator or admin. Existing users are
+-- plain users; the first admin is made with `go-server make-admin`.
+ALTER TABLE users ADD COLUMN role TEXT NOT NULL DEFAULT 'user';
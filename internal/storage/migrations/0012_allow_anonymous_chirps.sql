@@ -0,0 +1,42 @@
+-- Chirps of deleted users can be kept without an author, so author_id
+-- becomes nullable. SQLite cannot change a column's constraints, so the table
+-- is rebuilt. Dropping chirps would cascade to chirp_revisions, which is
+-- rebuilt first so no revision is lost.
+CREATE TABLE chirps_new (
+    id         INTEGER PRIMARY KEY AUTOINCREMENT,
+    body       TEXT    NOT NULL,
+    author_id  INTEGER REFERENCES users (id) ON DELETE SET NULL,
+    created_at INTEGER NOT NULL DEFAULT 0,
+    updated_at INTEGER NOT NULL DEFAULT 0,
+    edited     BOOLEAN NOT NULL DEFAULT 0
+);
+
+INSERT INTO chirps_new (id, body, author_id, created_at, updated_at, edited)
+    SELECT id, body, author_id, created_at, updated_at, edited FROM chirps;
+
+-- Keep handing out IDs after the highest one ever used, not the highest
+-- left, even when every chirp was deleted and nothing was copied.
+-- sqlite_sequence has no unique key, so the copy's entry is replaced by hand.
+DELETE FROM sqlite_sequence WHERE name = 'chirps_new';
+INSERT INTO sqlite_sequence (name, seq)
+    SELECT 'chirps_new', seq FROM sqlite_sequence WHERE name = 'chirps';
+
+CREATE TABLE chirp_revisions_new (
+    chirp_id   INTEGER NOT NULL REFERENCES chirps_new (id) ON DELETE CASCADE,
+    revision   INTEGER NOT NULL,
+    body       TEXT    NOT NULL,
+    created_at INTEGER NOT NULL,
+    PRIMARY KEY (chirp_id, revision)
+);
+
+INSERT INTO chirp_revisions_new SELECT chirp_id, revision, body, created_at FROM chirp_revisions;
+
+DROP TABLE chirp_revisions;
+DROP TABLE chirps;
+
+-- Renaming also points chirp_revisions_new's foreign key at chirps
+ALTER TABLE chirps_new RENAME TO chirps;
+ALTER TABLE chirp_revisions_new RENAME TO chirp_revisions;
+
+CREATE INDEX idx_chirps_author_id ON chirps (author_id);
+CREATE INDEX idx_chirps_created_at ON chirps (created_at, id);
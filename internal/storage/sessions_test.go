@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"errors"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+// forEachDriver runs the test against both backends. open opens the same
+// database again, to check what survives a restart.
+func forEachDriver(t *testing.T, test func(t *testing.T, open func() Store)) {
+	for _, driver := range []string{"json", "sqlite"} {
+		t.Run(driver, func(t *testing.T) {
+			filePath := filepath.Join(t.TempDir(), "database")
+			test(t, func() Store {
+				t.Helper()
+				store, err := Open(driver, filePath)
+				if err != nil {
+					t.Fatalf("could not open store: %v", err)
+				}
+				t.Cleanup(func() { store.(io.Closer).Close() })
+				return store
+			})
+		})
+	}
+}
+
+// refreshToken returns a refresh token with the hash that expires in expiresIn
+func refreshToken(hash string, expiresIn time.Duration) handlers.RefreshToken {
+	return handlers.RefreshToken{Hash: hash, ExpiresAt: time.Now().UTC().Add(expiresIn).Truncate(time.Microsecond)}
+}
+
+// createSession creates a user with a session whose refresh token has the hash
+func createSession(t *testing.T, store Store, email, hash string) handlers.Session {
+	t.Helper()
+	user, err := store.CreateUser(handlers.User{Email: email, Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	session, err := store.CreateSession(handlers.Session{UserID: user.ID, IP: "192.0.2.1", UserAgent: "first"}, refreshToken(hash, time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return session
+}
+
+func TestRotateRefreshToken(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, open func() Store) {
+		store := open()
+		session := createSession(t, store, "user@example.com", "first")
+
+		next := refreshToken("second", 2*time.Hour)
+		rotated, err := store.RotateRefreshToken("first", next, "192.0.2.2", "second")
+		if err != nil {
+			t.Fatalf("could not rotate: %v", err)
+		}
+		if rotated.ID != session.ID || rotated.IP != "192.0.2.2" || rotated.UserAgent != "second" || !rotated.ExpiresAt.Equal(next.ExpiresAt) {
+			t.Errorf("rotated session %+v, want session %d used from the new client until the new token expires", rotated, session.ID)
+		}
+
+		// The new token can be rotated in turn
+		if _, err := store.RotateRefreshToken("second", refreshToken("third", time.Hour), "", ""); err != nil {
+			t.Errorf("could not rotate the new token: %v", err)
+		}
+		if _, err := store.RotateRefreshToken("unknown", refreshToken("fourth", time.Hour), "", ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("unknown token got %v, want %v", err, ErrNotFound)
+		}
+	})
+}
+
+func TestRotateRefreshTokenDetectsReuse(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, open func() Store) {
+		store := open()
+		session := createSession(t, store, "user@example.com", "first")
+		other := createSession(t, store, "other@example.com", "other")
+
+		if _, err := store.RotateRefreshToken("first", refreshToken("second", time.Hour), "", ""); err != nil {
+			t.Fatal(err)
+		}
+		// Someone replays the old token
+		if _, err := store.RotateRefreshToken("first", refreshToken("stolen", time.Hour), "", ""); !errors.Is(err, ErrTokenReused) {
+			t.Fatalf("reused token got %v, want %v", err, ErrTokenReused)
+		}
+
+		// The whole session is gone, including the token handed out last
+		if _, err := store.RotateRefreshToken("second", refreshToken("third", time.Hour), "", ""); !errors.Is(err, ErrRevoked) {
+			t.Errorf("newest token of the session got %v, want %v", err, ErrRevoked)
+		}
+		if _, err := store.RotateRefreshToken("stolen", refreshToken("fourth", time.Hour), "", ""); !errors.Is(err, ErrNotFound) {
+			t.Errorf("the token offered to the thief got %v, want %v", err, ErrNotFound)
+		}
+		if _, err := store.RotateRefreshToken("other", refreshToken("other-next", time.Hour), "", ""); err != nil {
+			t.Errorf("another user's session was affected: %v", err)
+		}
+
+		// Even after a restart
+		store.(io.Closer).Close()
+		store = open()
+		revoked, err := store.GetSession(session.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if revoked.RevokedAt.IsZero() {
+			t.Error("the session was not revoked")
+		}
+		kept, err := store.GetSession(other.ID)
+		if err != nil || !kept.RevokedAt.IsZero() {
+			t.Errorf("other session %+v (%v), want it active", kept, err)
+		}
+	})
+}
+
+func TestRotateRefreshTokenExpired(t *testing.T) {
+	forEachDriver(t, func(t *testing.T, open func() Store) {
+		store := open()
+		user, err := store.CreateUser(handlers.User{Email: "user@example.com", Password: "hash"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		session, err := store.CreateSession(handlers.Session{UserID: user.ID}, refreshToken("expired", -time.Minute))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := store.RotateRefreshToken("expired", refreshToken("next", time.Hour), "", ""); !errors.Is(err, ErrTokenExpired) {
+			t.Errorf("expired token got %v, want %v", err, ErrTokenExpired)
+		}
+		// An expired token is not a stolen one
+		got, err := store.GetSession(session.ID)
+		if err != nil || !got.RevokedAt.IsZero() {
+			t.Errorf("session %+v (%v), want it not revoked", got, err)
+		}
+	})
+}
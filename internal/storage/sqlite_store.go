@@ -0,0 +1,1059 @@
+package storage
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+	_ "modernc.org/sqlite"
+)
+
+// SQLiteStore keeps users and chirps in an embedded SQLite database
+type SQLiteStore struct {
+	db *sql.DB
+}
+
+// NewSQLiteStore opens (or creates) the database at filePath and applies any
+// pending schema migrations.
+//
+// Transactions are started with BEGIN IMMEDIATE so a read-modify-write
+// transaction takes the write lock before it reads, and concurrent writers
+// queue up (for up to busy_timeout) instead of failing or losing updates.
+func NewSQLiteStore(filePath string) (*SQLiteStore, error) {
+	db, err := openSQLite(filePath)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := migrate(db); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	return &SQLiteStore{db: db}, nil
+}
+
+// openSQLite opens the database without migrating it
+func openSQLite(filePath string) (*sql.DB, error) {
+	dsn := fmt.Sprintf("file:%s?_pragma=foreign_keys(1)&_pragma=busy_timeout(5000)&_pragma=journal_mode(WAL)&_time_format=sqlite&_txlock=immediate", filePath)
+	db, err := sql.Open("sqlite", dsn)
+	if err != nil {
+		return nil, fmt.Errorf("could not open database: %v", err)
+	}
+	return db, nil
+}
+
+// Close releases the underlying database handle
+func (s *SQLiteStore) Close() error {
+	return s.db.Close()
+}
+
+const (
+	userColumns = `id, email, password, is_chirpy_red, email_verified, created_at, updated_at, tokens_valid_after,
+		totp_secret, totp_enabled, totp_last_step, recovery_codes, role`
+	chirpColumns    = `id, body, author_id, created_at, updated_at, edited`
+	sessionColumns  = `id, user_id, user_agent, ip, created_at, last_used_at, expires_at, revoked_at`
+	tokenColumns    = `token_hash, session_id, user_id, created_at, expires_at, used_at`
+	oneTimeColumns  = `token_hash, purpose, user_id, created_at, expires_at, used_at`
+	identityColumns = `provider, subject, user_id, email, created_at`
+	apiTokenColumns = `id, user_id, name, token_hash, scopes, created_at, last_used_at, expires_at, revoked_at`
+)
+
+// rowScanner is satisfied by both *sql.Row and *sql.Rows
+type rowScanner interface {
+	Scan(dest ...any) error
+}
+
+func scanUser(row rowScanner) (handlers.User, error) {
+	var user handlers.User
+	var createdAt, updatedAt int64
+	var tokensValidAfter sql.NullInt64
+	var recoveryCodes string
+
+	err := row.Scan(&user.ID, &user.Email, &user.Password, &user.IsChirpyRed, &user.EmailVerified, &createdAt, &updatedAt, &tokensValidAfter,
+		&user.TOTPSecret, &user.TOTPEnabled, &user.TOTPLastStep, &recoveryCodes, &user.Role)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.User{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not scan user: %v", err)
+	}
+
+	user.CreatedAt = fromMicros(createdAt)
+	user.UpdatedAt = fromMicros(updatedAt)
+	user.TokensValidAfter = fromNullMicros(tokensValidAfter)
+	user.RecoveryCodes = strings.Fields(recoveryCodes)
+	return user, nil
+}
+
+func scanChirp(row rowScanner) (handlers.Chirp, error) {
+	var chirp handlers.Chirp
+	var authorID sql.NullInt64 // NULL for chirps kept after their author was deleted
+	var createdAt, updatedAt int64
+
+	err := row.Scan(&chirp.ID, &chirp.Body, &authorID, &createdAt, &updatedAt, &chirp.Edited)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.Chirp{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.Chirp{}, fmt.Errorf("could not scan chirp: %v", err)
+	}
+
+	chirp.AuthorID = int(authorID.Int64)
+	chirp.CreatedAt = fromMicros(createdAt)
+	chirp.UpdatedAt = fromMicros(updatedAt)
+	return chirp, nil
+}
+
+// toMicros and fromMicros convert the created_at and updated_at columns,
+// which hold Unix microseconds
+func toMicros(value time.Time) int64 {
+	return value.UnixMicro()
+}
+
+func fromMicros(value int64) time.Time {
+	return time.UnixMicro(value).UTC()
+}
+
+// nullMicros stores zero times as NULL
+func nullMicros(value time.Time) sql.NullInt64 {
+	return sql.NullInt64{Int64: toMicros(value), Valid: !value.IsZero()}
+}
+
+// nullID stores ID 0, such as the author of an anonymised chirp, as NULL
+func nullID(id int) sql.NullInt64 {
+	return sql.NullInt64{Int64: int64(id), Valid: id != 0}
+}
+
+// fromNullMicros reads NULL as the zero time
+func fromNullMicros(value sql.NullInt64) time.Time {
+	if !value.Valid {
+		return time.Time{}
+	}
+	return fromMicros(value.Int64)
+}
+
+func scanSession(row rowScanner) (handlers.Session, error) {
+	var session handlers.Session
+	var createdAt, lastUsedAt, expiresAt int64
+	var revokedAt sql.NullInt64
+
+	err := row.Scan(&session.ID, &session.UserID, &session.UserAgent, &session.IP, &createdAt, &lastUsedAt, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.Session{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.Session{}, fmt.Errorf("could not scan session: %v", err)
+	}
+
+	session.CreatedAt = fromMicros(createdAt)
+	session.LastUsedAt = fromMicros(lastUsedAt)
+	session.ExpiresAt = fromMicros(expiresAt)
+	session.RevokedAt = fromNullMicros(revokedAt)
+	return session, nil
+}
+
+func scanRefreshToken(row rowScanner) (handlers.RefreshToken, error) {
+	var token handlers.RefreshToken
+	var createdAt, expiresAt int64
+	var usedAt sql.NullInt64
+
+	err := row.Scan(&token.Hash, &token.SessionID, &token.UserID, &createdAt, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.RefreshToken{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.RefreshToken{}, fmt.Errorf("could not scan refresh token: %v", err)
+	}
+
+	token.CreatedAt = fromMicros(createdAt)
+	token.ExpiresAt = fromMicros(expiresAt)
+	token.UsedAt = fromNullMicros(usedAt)
+	return token, nil
+}
+
+func scanOneTimeToken(row rowScanner) (handlers.OneTimeToken, error) {
+	var token handlers.OneTimeToken
+	var createdAt, expiresAt int64
+	var usedAt sql.NullInt64
+
+	err := row.Scan(&token.Hash, &token.Purpose, &token.UserID, &createdAt, &expiresAt, &usedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.OneTimeToken{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.OneTimeToken{}, fmt.Errorf("could not scan one-time token: %v", err)
+	}
+
+	token.CreatedAt = fromMicros(createdAt)
+	token.ExpiresAt = fromMicros(expiresAt)
+	token.UsedAt = fromNullMicros(usedAt)
+	return token, nil
+}
+
+func (s *SQLiteStore) CreateUser(user handlers.User) (handlers.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	user, err = insertUser(tx, user)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.User{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return user, nil
+}
+
+// insertUser assigns the next user ID and inserts the user
+func insertUser(tx *sql.Tx, user handlers.User) (handlers.User, error) {
+	var existingID int
+	err := tx.QueryRow(`SELECT id FROM users WHERE email = ?`, user.Email).Scan(&existingID)
+	if err == nil {
+		return handlers.User{}, ErrAlreadyExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return handlers.User{}, fmt.Errorf("could not check email: %v", err)
+	}
+
+	// AUTOINCREMENT never hands out an ID that was used before, even after a delete
+	user.Role = user.GetRole()
+	user.CreatedAt = timestamp()
+	user.UpdatedAt = user.CreatedAt
+	err = tx.QueryRow(`INSERT INTO users (email, password, is_chirpy_red, email_verified, created_at, updated_at, tokens_valid_after,
+			totp_secret, totp_enabled, totp_last_step, recovery_codes, role)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		user.Email, user.Password, user.IsChirpyRed, user.EmailVerified, toMicros(user.CreatedAt), toMicros(user.UpdatedAt),
+		nullMicros(user.TokensValidAfter), user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep,
+		strings.Join(user.RecoveryCodes, " "), user.Role).Scan(&user.ID)
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not insert user: %v", err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) GetUser(id int) (handlers.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) GetUserByEmail(email string) (handlers.User, error) {
+	return scanUser(s.db.QueryRow(`SELECT `+userColumns+` FROM users WHERE email = ?`, email))
+}
+
+func (s *SQLiteStore) UpdateUser(id int, fn func(user *handlers.User) error) (handlers.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	user, err := updateUser(tx, id, fn)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.User{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return user, nil
+}
+
+// updateUser applies fn to the user inside the caller's transaction
+func updateUser(tx *sql.Tx, id int, fn func(user *handlers.User) error) (handlers.User, error) {
+	user, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id))
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	// The callback must not move the record to another ID or rewrite its history
+	createdAt := user.CreatedAt
+	if err := fn(&user); err != nil {
+		return handlers.User{}, err
+	}
+	user.ID = id
+	user.CreatedAt = createdAt
+	user.UpdatedAt = timestamp()
+
+	var existingID int
+	err = tx.QueryRow(`SELECT id FROM users WHERE email = ? AND id != ?`, user.Email, id).Scan(&existingID)
+	if err == nil {
+		return handlers.User{}, ErrAlreadyExists
+	}
+	if !errors.Is(err, sql.ErrNoRows) {
+		return handlers.User{}, fmt.Errorf("could not check email: %v", err)
+	}
+
+	_, err = tx.Exec(`UPDATE users SET
+			email = ?,
+			password = ?,
+			is_chirpy_red = ?,
+			email_verified = ?,
+			updated_at = ?,
+			tokens_valid_after = ?,
+			totp_secret = ?,
+			totp_enabled = ?,
+			totp_last_step = ?,
+			recovery_codes = ?,
+			role = ?
+		WHERE id = ?`,
+		user.Email, user.Password, user.IsChirpyRed, user.EmailVerified, toMicros(user.UpdatedAt), nullMicros(user.TokensValidAfter),
+		user.TOTPSecret, user.TOTPEnabled, user.TOTPLastStep, strings.Join(user.RecoveryCodes, " "), user.GetRole(), user.ID)
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not update user: %v", err)
+	}
+	return user, nil
+}
+
+func (s *SQLiteStore) DeleteUser(id int, keepChirps bool) ([]int, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return nil, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, id)); err != nil {
+		return nil, err
+	}
+
+	var chirpIDs []int
+	if !keepChirps {
+		rows, err := tx.Query(`DELETE FROM chirps WHERE author_id = ? RETURNING id`, id)
+		if err != nil {
+			return nil, fmt.Errorf("could not delete chirps: %v", err)
+		}
+		for rows.Next() {
+			var chirpID int
+			if err := rows.Scan(&chirpID); err != nil {
+				rows.Close()
+				return nil, fmt.Errorf("could not scan chirp ID: %v", err)
+			}
+			chirpIDs = append(chirpIDs, chirpID)
+		}
+		// The rows must be closed before the transaction can go on
+		err = rows.Err()
+		rows.Close()
+		if err != nil {
+			return nil, fmt.Errorf("could not delete chirps: %v", err)
+		}
+		sort.Ints(chirpIDs)
+	}
+
+	// Sessions, tokens and identities go with the user (ON DELETE CASCADE);
+	// kept chirps lose their author (ON DELETE SET NULL)
+	if _, err := tx.Exec(`DELETE FROM users WHERE id = ?`, id); err != nil {
+		return nil, fmt.Errorf("could not delete user: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return chirpIDs, nil
+}
+
+func (s *SQLiteStore) CreateChirp(chirp handlers.Chirp) (handlers.Chirp, error) {
+	chirp.CreatedAt = timestamp()
+	chirp.UpdatedAt = chirp.CreatedAt
+	err := s.db.QueryRow(`INSERT INTO chirps (body, author_id, created_at, updated_at) VALUES (?, ?, ?, ?) RETURNING id`,
+		chirp.Body, chirp.AuthorID, toMicros(chirp.CreatedAt), toMicros(chirp.UpdatedAt)).Scan(&chirp.ID)
+	if err != nil {
+		return handlers.Chirp{}, fmt.Errorf("could not insert chirp: %v", err)
+	}
+	return chirp, nil
+}
+
+func (s *SQLiteStore) GetChirp(id int) (handlers.Chirp, error) {
+	return scanChirp(s.db.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) ListChirps(query ChirpQuery) ([]handlers.Chirp, error) {
+	where := []string{"1 = 1"}
+	args := []any{}
+
+	if query.AuthorID != 0 {
+		where = append(where, "author_id = ?")
+		args = append(args, query.AuthorID)
+	}
+	if !query.Since.IsZero() {
+		where = append(where, "created_at >= ?")
+		args = append(args, toMicros(query.Since))
+	}
+	if !query.Until.IsZero() {
+		where = append(where, "created_at < ?")
+		args = append(args, toMicros(query.Until))
+	}
+	order := "ASC"
+	if query.Desc {
+		order = "DESC"
+	}
+	if query.After != nil {
+		if query.Desc {
+			where = append(where, "(created_at, id) < (?, ?)")
+		} else {
+			where = append(where, "(created_at, id) > (?, ?)")
+		}
+		args = append(args, toMicros(query.After.CreatedAt), query.After.ID)
+	}
+
+	statement := `SELECT ` + chirpColumns + ` FROM chirps WHERE ` + strings.Join(where, " AND ") +
+		` ORDER BY created_at ` + order + `, id ` + order
+	if query.Limit > 0 {
+		statement += ` LIMIT ?`
+		args = append(args, query.Limit)
+	}
+
+	rows, err := s.db.Query(statement, args...)
+	if err != nil {
+		return nil, fmt.Errorf("could not query chirps: %v", err)
+	}
+	defer rows.Close()
+
+	chirps := []handlers.Chirp{}
+	for rows.Next() {
+		chirp, err := scanChirp(rows)
+		if err != nil {
+			return nil, err
+		}
+		chirps = append(chirps, chirp)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read chirps: %v", err)
+	}
+	return chirps, nil
+}
+
+func (s *SQLiteStore) UpdateChirp(id int, fn func(chirp *handlers.Chirp) error) (handlers.Chirp, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.Chirp{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	previous, err := scanChirp(tx.QueryRow(`SELECT `+chirpColumns+` FROM chirps WHERE id = ?`, id))
+	if err != nil {
+		return handlers.Chirp{}, err
+	}
+
+	chirp := previous
+	if err := fn(&chirp); err != nil {
+		return handlers.Chirp{}, err
+	}
+	// Only the body can change
+	chirp.ID, chirp.AuthorID, chirp.CreatedAt = previous.ID, previous.AuthorID, previous.CreatedAt
+	if chirp.Body == previous.Body {
+		return previous, nil
+	}
+
+	chirp.Edited = true
+	chirp.UpdatedAt = timestamp()
+
+	_, err = tx.Exec(`INSERT INTO chirp_revisions (chirp_id, revision, body, created_at)
+		SELECT ?, COALESCE(MAX(revision), 0) + 1, ?, ? FROM chirp_revisions WHERE chirp_id = ?`,
+		id, previous.Body, toMicros(previous.UpdatedAt), id)
+	if err != nil {
+		return handlers.Chirp{}, fmt.Errorf("could not save revision: %v", err)
+	}
+
+	_, err = tx.Exec(`UPDATE chirps SET body = ?, updated_at = ?, edited = 1 WHERE id = ?`, chirp.Body, toMicros(chirp.UpdatedAt), id)
+	if err != nil {
+		return handlers.Chirp{}, fmt.Errorf("could not update chirp: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.Chirp{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return chirp, nil
+}
+
+func (s *SQLiteStore) ListChirpRevisions(chirpID int) ([]handlers.ChirpRevision, error) {
+	if _, err := s.GetChirp(chirpID); err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.Query(`SELECT chirp_id, revision, body, created_at FROM chirp_revisions WHERE chirp_id = ? ORDER BY revision`, chirpID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query revisions: %v", err)
+	}
+	defer rows.Close()
+
+	revisions := []handlers.ChirpRevision{}
+	for rows.Next() {
+		var revision handlers.ChirpRevision
+		var createdAt int64
+		if err := rows.Scan(&revision.ChirpID, &revision.Revision, &revision.Body, &createdAt); err != nil {
+			return nil, fmt.Errorf("could not scan revision: %v", err)
+		}
+		revision.CreatedAt = fromMicros(createdAt)
+		revisions = append(revisions, revision)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read revisions: %v", err)
+	}
+	return revisions, nil
+}
+
+func (s *SQLiteStore) DeleteChirp(id int) error {
+	result, err := s.db.Exec(`DELETE FROM chirps WHERE id = ?`, id)
+	if err != nil {
+		return fmt.Errorf("could not delete chirp: %v", err)
+	}
+	return expectOneRow(result)
+}
+
+func (s *SQLiteStore) CreateSession(session handlers.Session, token handlers.RefreshToken) (handlers.Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.Session{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, session.UserID)); err != nil {
+		return handlers.Session{}, err
+	}
+
+	session.CreatedAt = timestamp()
+	session.LastUsedAt = session.CreatedAt
+	session.ExpiresAt = token.ExpiresAt
+	session.RevokedAt = time.Time{}
+	err = tx.QueryRow(`INSERT INTO sessions (user_id, user_agent, ip, created_at, last_used_at, expires_at)
+		VALUES (?, ?, ?, ?, ?, ?) RETURNING id`,
+		session.UserID, session.UserAgent, session.IP, toMicros(session.CreatedAt), toMicros(session.LastUsedAt),
+		toMicros(session.ExpiresAt)).Scan(&session.ID)
+	if err != nil {
+		return handlers.Session{}, fmt.Errorf("could not insert session: %v", err)
+	}
+
+	token.SessionID, token.UserID, token.CreatedAt = session.ID, session.UserID, session.CreatedAt
+	if err := insertRefreshToken(tx, token); err != nil {
+		return handlers.Session{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.Session{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return session, nil
+}
+
+func (s *SQLiteStore) GetSession(id int) (handlers.Session, error) {
+	return scanSession(s.db.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, id))
+}
+
+func (s *SQLiteStore) RotateRefreshToken(tokenHash string, next handlers.RefreshToken, ip, userAgent string) (handlers.Session, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.Session{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	token, err := scanRefreshToken(tx.QueryRow(`SELECT `+tokenColumns+` FROM refresh_tokens WHERE token_hash = ?`, tokenHash))
+	if err != nil {
+		return handlers.Session{}, err
+	}
+	session, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ?`, token.SessionID))
+	if err != nil {
+		return handlers.Session{}, err
+	}
+
+	now := timestamp()
+	if !session.RevokedAt.IsZero() {
+		return handlers.Session{}, ErrRevoked
+	}
+	if !token.UsedAt.IsZero() {
+		// Someone is holding a copy of an old token, so nobody can be trusted
+		// with this session any more
+		if _, err := tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ?`, toMicros(now), session.ID); err != nil {
+			return handlers.Session{}, fmt.Errorf("could not revoke session: %v", err)
+		}
+		if err := tx.Commit(); err != nil {
+			return handlers.Session{}, fmt.Errorf("could not commit transaction: %v", err)
+		}
+		return handlers.Session{}, ErrTokenReused
+	}
+	if !now.Before(token.ExpiresAt) {
+		return handlers.Session{}, ErrTokenExpired
+	}
+
+	if _, err := tx.Exec(`UPDATE refresh_tokens SET used_at = ? WHERE token_hash = ?`, toMicros(now), tokenHash); err != nil {
+		return handlers.Session{}, fmt.Errorf("could not update refresh token: %v", err)
+	}
+	next.SessionID, next.UserID, next.CreatedAt = session.ID, session.UserID, now
+	if err := insertRefreshToken(tx, next); err != nil {
+		return handlers.Session{}, err
+	}
+
+	session.LastUsedAt, session.ExpiresAt = now, next.ExpiresAt
+	session.IP, session.UserAgent = ip, userAgent
+	_, err = tx.Exec(`UPDATE sessions SET last_used_at = ?, expires_at = ?, ip = ?, user_agent = ? WHERE id = ?`,
+		toMicros(session.LastUsedAt), toMicros(session.ExpiresAt), session.IP, session.UserAgent, session.ID)
+	if err != nil {
+		return handlers.Session{}, fmt.Errorf("could not update session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.Session{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return session, nil
+}
+
+func (s *SQLiteStore) RevokeSessionByRefreshToken(tokenHash string) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	session, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions
+		WHERE id = (SELECT session_id FROM refresh_tokens WHERE token_hash = ?)`, tokenHash))
+	if err != nil {
+		return err
+	}
+	if !session.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	if _, err := tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ?`, toMicros(timestamp()), session.ID); err != nil {
+		return fmt.Errorf("could not revoke session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) ListSessions(userID int) ([]handlers.Session, error) {
+	rows, err := s.db.Query(`SELECT `+sessionColumns+` FROM sessions WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query sessions: %v", err)
+	}
+	defer rows.Close()
+
+	sessions := []handlers.Session{}
+	for rows.Next() {
+		session, err := scanSession(rows)
+		if err != nil {
+			return nil, err
+		}
+		sessions = append(sessions, session)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read sessions: %v", err)
+	}
+	return sessions, nil
+}
+
+func (s *SQLiteStore) RevokeSession(userID, sessionID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	session, err := scanSession(tx.QueryRow(`SELECT `+sessionColumns+` FROM sessions WHERE id = ? AND user_id = ?`, sessionID, userID))
+	if err != nil {
+		return err
+	}
+	if !session.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	if _, err := tx.Exec(`UPDATE sessions SET revoked_at = ? WHERE id = ?`, toMicros(timestamp()), session.ID); err != nil {
+		return fmt.Errorf("could not revoke session: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RevokeAllSessions(userID, exceptSessionID int) (int, error) {
+	now := toMicros(timestamp())
+	result, err := s.db.Exec(`UPDATE sessions SET revoked_at = ?
+		WHERE user_id = ? AND id != ? AND revoked_at IS NULL AND expires_at > ?`,
+		now, userID, exceptSessionID, now)
+	if err != nil {
+		return 0, fmt.Errorf("could not revoke sessions: %v", err)
+	}
+
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("could not read affected rows: %v", err)
+	}
+	return int(affected), nil
+}
+
+func (s *SQLiteStore) DenyAccessToken(tokenID string, expiresAt time.Time) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	// Entries for tokens that have expired since are of no use any more
+	if _, err := tx.Exec(`DELETE FROM denied_access_tokens WHERE expires_at <= ?`, toMicros(timestamp())); err != nil {
+		return fmt.Errorf("could not prune denied access tokens: %v", err)
+	}
+	_, err = tx.Exec(`INSERT INTO denied_access_tokens (token_id, expires_at) VALUES (?, ?)
+		ON CONFLICT (token_id) DO UPDATE SET expires_at = max(expires_at, excluded.expires_at)`,
+		tokenID, toMicros(expiresAt))
+	if err != nil {
+		return fmt.Errorf("could not deny access token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) IsAccessTokenDenied(tokenID string) (bool, error) {
+	var denied bool
+	err := s.db.QueryRow(`SELECT EXISTS (SELECT 1 FROM denied_access_tokens WHERE token_id = ? AND expires_at > ?)`,
+		tokenID, toMicros(timestamp())).Scan(&denied)
+	if err != nil {
+		return false, fmt.Errorf("could not check denied access tokens: %v", err)
+	}
+	return denied, nil
+}
+
+func (s *SQLiteStore) CreateOneTimeToken(token handlers.OneTimeToken) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, token.UserID)); err != nil {
+		return err
+	}
+
+	// Tokens that can no longer be redeemed are of no use any more, and
+	// only the latest token per purpose can be
+	now := timestamp()
+	if _, err := tx.Exec(`DELETE FROM one_time_tokens WHERE expires_at <= ?`, toMicros(now)); err != nil {
+		return fmt.Errorf("could not prune one-time tokens: %v", err)
+	}
+	_, err = tx.Exec(`UPDATE one_time_tokens SET expires_at = ?
+		WHERE user_id = ? AND purpose = ? AND used_at IS NULL`,
+		toMicros(now), token.UserID, token.Purpose)
+	if err != nil {
+		return fmt.Errorf("could not expire one-time tokens: %v", err)
+	}
+
+	token.CreatedAt = now
+	if err := insertOneTimeToken(tx, token); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) RedeemOneTimeToken(tokenHash, purpose string, fn func(user *handlers.User) error) (handlers.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	token, err := scanOneTimeToken(tx.QueryRow(`SELECT `+oneTimeColumns+` FROM one_time_tokens WHERE token_hash = ? AND purpose = ?`, tokenHash, purpose))
+	if err != nil {
+		return handlers.User{}, err
+	}
+	if !token.UsedAt.IsZero() {
+		return handlers.User{}, ErrTokenReused
+	}
+	now := timestamp()
+	if !now.Before(token.ExpiresAt) {
+		return handlers.User{}, ErrTokenExpired
+	}
+
+	user, err := updateUser(tx, token.UserID, fn)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	if _, err := tx.Exec(`UPDATE one_time_tokens SET used_at = ? WHERE token_hash = ?`, toMicros(now), token.Hash); err != nil {
+		return handlers.User{}, fmt.Errorf("could not use one-time token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.User{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return user, nil
+}
+
+// insertOneTimeToken stores a token inside the caller's transaction
+func insertOneTimeToken(tx *sql.Tx, token handlers.OneTimeToken) error {
+	_, err := tx.Exec(`INSERT INTO one_time_tokens (`+oneTimeColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		token.Hash, token.Purpose, token.UserID, toMicros(token.CreatedAt), toMicros(token.ExpiresAt), nullMicros(token.UsedAt))
+	if err != nil {
+		return fmt.Errorf("could not insert one-time token: %v", err)
+	}
+	return nil
+}
+
+// insertRefreshToken stores a token inside the caller's transaction
+func insertRefreshToken(tx *sql.Tx, token handlers.RefreshToken) error {
+	_, err := tx.Exec(`INSERT INTO refresh_tokens (`+tokenColumns+`) VALUES (?, ?, ?, ?, ?, ?)`,
+		token.Hash, token.SessionID, token.UserID, toMicros(token.CreatedAt), toMicros(token.ExpiresAt), nullMicros(token.UsedAt))
+	if err != nil {
+		return fmt.Errorf("could not insert refresh token: %v", err)
+	}
+	return nil
+}
+
+// expectOneRow maps "nothing changed" to ErrNotFound
+func expectOneRow(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not read affected rows: %v", err)
+	}
+	if affected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
+
+func scanIdentity(row rowScanner) (handlers.Identity, error) {
+	var identity handlers.Identity
+	var createdAt int64
+
+	err := row.Scan(&identity.Provider, &identity.Subject, &identity.UserID, &identity.Email, &createdAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.Identity{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.Identity{}, fmt.Errorf("could not scan identity: %v", err)
+	}
+	identity.CreatedAt = fromMicros(createdAt)
+	return identity, nil
+}
+
+// insertIdentity inserts the identity, reporting one that is already linked
+// as ErrAlreadyExists
+func insertIdentity(tx *sql.Tx, identity handlers.Identity) error {
+	_, err := scanIdentity(tx.QueryRow(`SELECT `+identityColumns+` FROM user_identities WHERE provider = ? AND subject = ?`,
+		identity.Provider, identity.Subject))
+	if err == nil {
+		return ErrAlreadyExists
+	}
+	if !errors.Is(err, ErrNotFound) {
+		return err
+	}
+
+	_, err = tx.Exec(`INSERT INTO user_identities (`+identityColumns+`) VALUES (?, ?, ?, ?, ?)`,
+		identity.Provider, identity.Subject, identity.UserID, identity.Email, toMicros(identity.CreatedAt))
+	if err != nil {
+		return fmt.Errorf("could not insert identity: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) GetIdentity(provider, subject string) (handlers.Identity, error) {
+	return scanIdentity(s.db.QueryRow(`SELECT `+identityColumns+` FROM user_identities WHERE provider = ? AND subject = ?`,
+		provider, subject))
+}
+
+func (s *SQLiteStore) ListIdentities(userID int) ([]handlers.Identity, error) {
+	rows, err := s.db.Query(`SELECT `+identityColumns+` FROM user_identities WHERE user_id = ? ORDER BY created_at, provider, subject`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query identities: %v", err)
+	}
+	defer rows.Close()
+
+	identities := []handlers.Identity{}
+	for rows.Next() {
+		identity, err := scanIdentity(rows)
+		if err != nil {
+			return nil, err
+		}
+		identities = append(identities, identity)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read identities: %v", err)
+	}
+	return identities, nil
+}
+
+func (s *SQLiteStore) LinkIdentity(identity handlers.Identity) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, identity.UserID)); err != nil {
+		return err
+	}
+
+	identity.CreatedAt = timestamp()
+	if err := insertIdentity(tx, identity); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) CreateUserWithIdentity(user handlers.User, identity handlers.Identity) (handlers.User, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.User{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	user, err = insertUser(tx, user)
+	if err != nil {
+		return handlers.User{}, err
+	}
+
+	identity.UserID = user.ID
+	identity.CreatedAt = user.CreatedAt
+	if err := insertIdentity(tx, identity); err != nil {
+		return handlers.User{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.User{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return user, nil
+}
+
+func scanAPIToken(row rowScanner) (handlers.APIToken, error) {
+	var token handlers.APIToken
+	var scopes string
+	var createdAt int64
+	var lastUsedAt, expiresAt, revokedAt sql.NullInt64
+
+	err := row.Scan(&token.ID, &token.UserID, &token.Name, &token.Hash, &scopes, &createdAt, &lastUsedAt, &expiresAt, &revokedAt)
+	if errors.Is(err, sql.ErrNoRows) {
+		return handlers.APIToken{}, ErrNotFound
+	}
+	if err != nil {
+		return handlers.APIToken{}, fmt.Errorf("could not scan API token: %v", err)
+	}
+
+	token.Scopes = strings.Fields(scopes)
+	token.CreatedAt = fromMicros(createdAt)
+	token.LastUsedAt = fromNullMicros(lastUsedAt)
+	token.ExpiresAt = fromNullMicros(expiresAt)
+	token.RevokedAt = fromNullMicros(revokedAt)
+	return token, nil
+}
+
+// insertAPIToken stores a token inside the caller's transaction. A token
+// without an ID gets the next one.
+func insertAPIToken(tx *sql.Tx, token handlers.APIToken) (handlers.APIToken, error) {
+	id := sql.NullInt64{Int64: int64(token.ID), Valid: token.ID != 0}
+	err := tx.QueryRow(`INSERT INTO api_tokens (`+apiTokenColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?) RETURNING id`,
+		id, token.UserID, token.Name, token.Hash, strings.Join(token.Scopes, " "), toMicros(token.CreatedAt),
+		nullMicros(token.LastUsedAt), nullMicros(token.ExpiresAt), nullMicros(token.RevokedAt)).Scan(&token.ID)
+	if err != nil {
+		return handlers.APIToken{}, fmt.Errorf("could not insert API token: %v", err)
+	}
+	return token, nil
+}
+
+func (s *SQLiteStore) CreateAPIToken(token handlers.APIToken) (handlers.APIToken, error) {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return handlers.APIToken{}, fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	if _, err := scanUser(tx.QueryRow(`SELECT `+userColumns+` FROM users WHERE id = ?`, token.UserID)); err != nil {
+		return handlers.APIToken{}, err
+	}
+
+	token.ID = 0
+	token.CreatedAt = timestamp()
+	token.LastUsedAt = time.Time{}
+	token.RevokedAt = time.Time{}
+	token, err = insertAPIToken(tx, token)
+	if err != nil {
+		return handlers.APIToken{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return handlers.APIToken{}, fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return token, nil
+}
+
+func (s *SQLiteStore) GetAPITokenByHash(tokenHash string) (handlers.APIToken, error) {
+	return scanAPIToken(s.db.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE token_hash = ?`, tokenHash))
+}
+
+func (s *SQLiteStore) ListAPITokens(userID int) ([]handlers.APIToken, error) {
+	rows, err := s.db.Query(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE user_id = ? ORDER BY created_at DESC, id DESC`, userID)
+	if err != nil {
+		return nil, fmt.Errorf("could not query API tokens: %v", err)
+	}
+	defer rows.Close()
+
+	tokens := []handlers.APIToken{}
+	for rows.Next() {
+		token, err := scanAPIToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, fmt.Errorf("could not read API tokens: %v", err)
+	}
+	return tokens, nil
+}
+
+func (s *SQLiteStore) RevokeAPIToken(userID, tokenID int) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return fmt.Errorf("could not start transaction: %v", err)
+	}
+	defer tx.Rollback()
+
+	token, err := scanAPIToken(tx.QueryRow(`SELECT `+apiTokenColumns+` FROM api_tokens WHERE id = ? AND user_id = ?`, tokenID, userID))
+	if err != nil {
+		return err
+	}
+	if !token.RevokedAt.IsZero() {
+		return ErrRevoked
+	}
+
+	if _, err := tx.Exec(`UPDATE api_tokens SET revoked_at = ? WHERE id = ?`, toMicros(timestamp()), token.ID); err != nil {
+		return fmt.Errorf("could not revoke API token: %v", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("could not commit transaction: %v", err)
+	}
+	return nil
+}
+
+func (s *SQLiteStore) TouchAPIToken(tokenID int, usedAt time.Time) error {
+	result, err := s.db.Exec(`UPDATE api_tokens SET last_used_at = ? WHERE id = ?`, toMicros(usedAt.UTC().Truncate(time.Microsecond)), tokenID)
+	if err != nil {
+		return fmt.Errorf("could not update API token: %v", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("could not update API token: %v", err)
+	}
+	if updated == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
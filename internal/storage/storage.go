@@ -0,0 +1,214 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+var (
+	// ErrNotFound is returned when the requested record does not exist
+	ErrNotFound = errors.New("record not found")
+	// ErrAlreadyExists is returned when a record with the same unique identifier exists
+	ErrAlreadyExists = errors.New("record already exists")
+	// ErrRevoked is returned when a token belongs to a revoked session
+	ErrRevoked = errors.New("session revoked")
+	// ErrTokenExpired is returned when a token is used after it expired
+	ErrTokenExpired = errors.New("token expired")
+	// ErrTokenReused is returned when a single-use token is used a second time
+	ErrTokenReused = errors.New("token reused")
+)
+
+// Store is the persistence layer used by the handlers. Every backend
+// (the JSON file and SQLite) implements this interface.
+type Store interface {
+	// Users
+	// CreateUser assigns the next user ID and returns the stored user
+	CreateUser(user handlers.User) (handlers.User, error)
+	GetUser(id int) (handlers.User, error)
+	GetUserByEmail(email string) (handlers.User, error)
+	// UpdateUser loads the user, applies fn and saves the result as one
+	// atomic step, so no other write can land between the read and the write.
+	// Returning an error from fn aborts the update.
+	UpdateUser(id int, fn func(user *handlers.User) error) (handlers.User, error)
+	// DeleteUser deletes the user with their sessions, tokens and linked
+	// identities as one atomic step. Their chirps are deleted as well, or with
+	// keepChirps kept without an author. It returns the IDs of the deleted
+	// chirps.
+	DeleteUser(id int, keepChirps bool) ([]int, error)
+
+	// Chirps
+	// CreateChirp assigns the next chirp ID and returns the stored chirp
+	CreateChirp(chirp handlers.Chirp) (handlers.Chirp, error)
+	GetChirp(id int) (handlers.Chirp, error)
+	// ListChirps returns the chirps matching the query, in the query's order
+	ListChirps(query ChirpQuery) ([]handlers.Chirp, error)
+	// UpdateChirp loads the chirp, applies fn and saves the result as one
+	// atomic step. If the body changed, the previous body is kept as a
+	// revision and the chirp is marked as edited. Returning an error from fn
+	// aborts the update.
+	UpdateChirp(id int, fn func(chirp *handlers.Chirp) error) (handlers.Chirp, error)
+	// ListChirpRevisions returns the earlier bodies of a chirp, oldest first
+	ListChirpRevisions(chirpID int) ([]handlers.ChirpRevision, error)
+	DeleteChirp(id int) error
+
+	// Sessions and their refresh tokens. Tokens are looked up by hash, the
+	// store never sees the tokens themselves.
+	GetSession(id int) (handlers.Session, error)
+	// CreateSession assigns the next session ID and stores the session with
+	// its first refresh token
+	CreateSession(session handlers.Session, token handlers.RefreshToken) (handlers.Session, error)
+	// RotateRefreshToken swaps the refresh token with the given hash for next
+	// as one atomic step and records the client that used it. Presenting a
+	// token that was already swapped revokes its whole session and returns
+	// ErrTokenReused.
+	RotateRefreshToken(tokenHash string, next handlers.RefreshToken, ip, userAgent string) (handlers.Session, error)
+	// RevokeSessionByRefreshToken revokes the session the token belongs to
+	RevokeSessionByRefreshToken(tokenHash string) error
+	// ListSessions returns every session of the user, revoked and expired
+	// ones included, newest first
+	ListSessions(userID int) ([]handlers.Session, error)
+	// RevokeSession revokes one of the user's sessions. Sessions of other
+	// users are reported as ErrNotFound.
+	RevokeSession(userID, sessionID int) error
+	// RevokeAllSessions revokes every active session of the user except
+	// exceptSessionID (0 keeps none) and returns how many were revoked
+	RevokeAllSessions(userID, exceptSessionID int) (int, error)
+
+	// Access token denylist, keyed by the tokens' jti claim
+	// DenyAccessToken rejects the token until expiresAt. Past that the token
+	// has expired anyway, so the entry is dropped.
+	DenyAccessToken(tokenID string, expiresAt time.Time) error
+	IsAccessTokenDenied(tokenID string) (bool, error)
+
+	// One-time tokens sent by email. Like refresh tokens they are looked up
+	// by hash.
+	// CreateOneTimeToken stores the token. Any unused token the user still
+	// has for the same purpose expires, so only the latest email works.
+	CreateOneTimeToken(token handlers.OneTimeToken) error
+	// RedeemOneTimeToken marks the token used and applies fn to its user as
+	// one atomic step. Unknown tokens and tokens for another purpose are
+	// ErrNotFound, expired ones ErrTokenExpired and used ones ErrTokenReused.
+	// Returning an error from fn aborts the update and keeps the token.
+	RedeemOneTimeToken(tokenHash, purpose string, fn func(user *handlers.User) error) (handlers.User, error)
+
+	// Identities at OpenID Connect providers, looked up by provider and subject
+	GetIdentity(provider, subject string) (handlers.Identity, error)
+	// ListIdentities returns the identities linked to the user, oldest first
+	ListIdentities(userID int) ([]handlers.Identity, error)
+	// LinkIdentity links the identity to an existing user. An identity that
+	// is already linked, to this user or another, is ErrAlreadyExists.
+	LinkIdentity(identity handlers.Identity) error
+	// CreateUserWithIdentity creates the user and links the identity to it as
+	// one atomic step, so a failed link never leaves a user behind
+	CreateUserWithIdentity(user handlers.User, identity handlers.Identity) (handlers.User, error)
+
+	// Personal API tokens. Like refresh tokens they are looked up by hash.
+	// CreateAPIToken assigns the next token ID and returns the stored token
+	CreateAPIToken(token handlers.APIToken) (handlers.APIToken, error)
+	GetAPITokenByHash(tokenHash string) (handlers.APIToken, error)
+	// ListAPITokens returns every token of the user, revoked and expired ones
+	// included, newest first
+	ListAPITokens(userID int) ([]handlers.APIToken, error)
+	// RevokeAPIToken revokes one of the user's tokens. Tokens of other users
+	// are reported as ErrNotFound.
+	RevokeAPIToken(userID, tokenID int) error
+	// TouchAPIToken records that the token was used at usedAt
+	TouchAPIToken(tokenID int, usedAt time.Time) error
+}
+
+// ChirpQuery filters and pages ListChirps. Chirps are ordered by creation
+// time, ties broken by ID. Pages are keyset based: a page starts right after
+// the last chirp of the previous page, so inserts and deletes between
+// requests never shift or repeat results.
+type ChirpQuery struct {
+	AuthorID int            // Only chirps by this author; 0 means every author
+	Since    time.Time      // Only chirps created at or after this time; zero means no lower bound
+	Until    time.Time      // Only chirps created before this time; zero means no upper bound
+	Desc     bool           // Newest first instead of oldest first
+	After    *ChirpPosition // Only chirps after this position in the chosen order; nil starts at the beginning
+	Limit    int            // Maximum number of chirps; 0 means no limit
+}
+
+// ChirpPosition is a chirp's place in the (created_at, id) ordering
+type ChirpPosition struct {
+	CreatedAt time.Time
+	ID        int
+}
+
+// PositionOf returns the chirp's place in the ordering
+func PositionOf(chirp handlers.Chirp) ChirpPosition {
+	return ChirpPosition{CreatedAt: chirp.CreatedAt, ID: chirp.ID}
+}
+
+// Before reports whether p sorts before other in ascending order
+func (p ChirpPosition) Before(other ChirpPosition) bool {
+	if !p.CreatedAt.Equal(other.CreatedAt) {
+		return p.CreatedAt.Before(other.CreatedAt)
+	}
+	return p.ID < other.ID
+}
+
+// Matches reports whether the chirp passes the query's filters
+func (q ChirpQuery) Matches(chirp handlers.Chirp) bool {
+	if q.AuthorID != 0 && chirp.AuthorID != q.AuthorID {
+		return false
+	}
+	if !q.Since.IsZero() && chirp.CreatedAt.Before(q.Since) {
+		return false
+	}
+	if !q.Until.IsZero() && !chirp.CreatedAt.Before(q.Until) {
+		return false
+	}
+	if q.After != nil {
+		position := PositionOf(chirp)
+		if q.Desc && !position.Before(*q.After) {
+			return false
+		}
+		if !q.Desc && !q.After.Before(position) {
+			return false
+		}
+	}
+	return true
+}
+
+// sortAPITokens orders API tokens newest first
+func sortAPITokens(tokens []handlers.APIToken) {
+	sort.Slice(tokens, func(i, j int) bool {
+		if !tokens[i].CreatedAt.Equal(tokens[j].CreatedAt) {
+			return tokens[i].CreatedAt.After(tokens[j].CreatedAt)
+		}
+		return tokens[i].ID > tokens[j].ID
+	})
+}
+
+// sortSessions orders sessions newest first
+func sortSessions(sessions []handlers.Session) {
+	sort.Slice(sessions, func(i, j int) bool {
+		if !sessions[i].CreatedAt.Equal(sessions[j].CreatedAt) {
+			return sessions[i].CreatedAt.After(sessions[j].CreatedAt)
+		}
+		return sessions[i].ID > sessions[j].ID
+	})
+}
+
+// timestamp returns the current time at the microsecond precision every
+// backend can store
+func timestamp() time.Time {
+	return time.Now().UTC().Truncate(time.Microsecond)
+}
+
+// Open returns the store for the configured driver ("json" or "sqlite")
+func Open(driver string, filePath string) (Store, error) {
+	switch driver {
+	case "", "json":
+		return NewJSONStore(filePath)
+	case "sqlite":
+		return NewSQLiteStore(filePath)
+	default:
+		return nil, fmt.Errorf("unknown database driver %q", driver)
+	}
+}
@@ -0,0 +1,285 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+// WAL operations. Every record carries the full new value so replaying a
+// record twice gives the same result as replaying it once.
+const (
+	opPutUser     = "put_user"
+	opDeleteUser  = "delete_user" // User ID, plus whether their chirps are kept without an author
+	opPutChirp    = "put_chirp"
+	opDeleteChirp = "delete_chirp"
+	opEditChirp   = "edit_chirp"   // New chirp state plus the revision it replaced
+	opPutSession  = "put_session"  // Session state plus any of its refresh tokens that changed
+	opDenyToken   = "deny_token"   // Access token ID plus when the token expires
+	opPutTokens   = "put_tokens"   // One-time tokens that changed, plus the user if redeeming one changed it
+	opPutIdentity = "put_identity" // Linked identity, plus the user if it was created with the identity
+	opPutAPIToken = "put_api_token"
+)
+
+// walRecord is one line of the write-ahead log
+type walRecord struct {
+	Op    string          `json:"op"`
+	ID    int             `json:"id,omitempty"`
+	User  *handlers.User  `json:"user,omitempty"`
+	Chirp *handlers.Chirp `json:"chirp,omitempty"`
+
+	Revision *handlers.ChirpRevision `json:"revision,omitempty"`
+
+	Session       *handlers.Session       `json:"session,omitempty"`
+	RefreshTokens []handlers.RefreshToken `json:"refresh_tokens,omitempty"`
+
+	TokenID   string     `json:"token_id,omitempty"`
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+
+	OneTimeTokens []handlers.OneTimeToken `json:"one_time_tokens,omitempty"`
+
+	Identity *handlers.Identity `json:"identity,omitempty"`
+
+	APIToken *handlers.APIToken `json:"api_token,omitempty"`
+
+	KeepChirps bool `json:"keep_chirps,omitempty"`
+}
+
+// apply replays the record onto the database
+func (rec walRecord) apply(database *handlers.Database) error {
+	switch rec.Op {
+	case opPutUser:
+		if rec.User == nil {
+			return fmt.Errorf("%s record without user", rec.Op)
+		}
+		database.Users[fmt.Sprint(rec.User.GetID())] = *rec.User
+		database.Sequences.Users = max(database.Sequences.Users, rec.User.GetID())
+	case opDeleteUser:
+		deleteUser(database, rec.ID, rec.KeepChirps)
+	case opPutChirp:
+		if rec.Chirp == nil {
+			return fmt.Errorf("%s record without chirp", rec.Op)
+		}
+		database.Chirps[rec.Chirp.GetUniqueIdentifier()] = *rec.Chirp
+		database.Sequences.Chirps = max(database.Sequences.Chirps, rec.Chirp.GetID())
+	case opEditChirp:
+		if rec.Chirp == nil || rec.Revision == nil {
+			return fmt.Errorf("%s record without chirp or revision", rec.Op)
+		}
+		key := rec.Chirp.GetUniqueIdentifier()
+		database.Chirps[key] = *rec.Chirp
+		// Replaying the same record twice must not duplicate the revision
+		revisions := database.Revisions[key]
+		if len(revisions) < rec.Revision.Revision {
+			database.Revisions[key] = append(revisions, *rec.Revision)
+		}
+	case opDeleteChirp:
+		delete(database.Chirps, fmt.Sprint(rec.ID))
+		delete(database.Revisions, fmt.Sprint(rec.ID))
+	case opPutSession:
+		if rec.Session == nil {
+			return fmt.Errorf("%s record without session", rec.Op)
+		}
+		database.Sessions[fmt.Sprint(rec.Session.GetID())] = *rec.Session
+		database.Sequences.Sessions = max(database.Sequences.Sessions, rec.Session.GetID())
+		for _, token := range rec.RefreshTokens {
+			database.RefreshTokens[token.Hash] = token
+		}
+	case opDenyToken:
+		if rec.TokenID == "" || rec.ExpiresAt == nil {
+			return fmt.Errorf("%s record without token ID or expiry", rec.Op)
+		}
+		database.DeniedTokens[rec.TokenID] = *rec.ExpiresAt
+	case opPutTokens:
+		for _, token := range rec.OneTimeTokens {
+			database.OneTimeTokens[token.Hash] = token
+		}
+		if rec.User != nil {
+			database.Users[fmt.Sprint(rec.User.GetID())] = *rec.User
+		}
+	case opPutIdentity:
+		if rec.Identity == nil {
+			return fmt.Errorf("%s record without identity", rec.Op)
+		}
+		if rec.User != nil {
+			database.Users[fmt.Sprint(rec.User.GetID())] = *rec.User
+			database.Sequences.Users = max(database.Sequences.Users, rec.User.GetID())
+		}
+		database.Identities[rec.Identity.Key()] = *rec.Identity
+	case opPutAPIToken:
+		if rec.APIToken == nil {
+			return fmt.Errorf("%s record without API token", rec.Op)
+		}
+		database.APITokens[fmt.Sprint(rec.APIToken.GetID())] = *rec.APIToken
+		database.Sequences.APITokens = max(database.Sequences.APITokens, rec.APIToken.GetID())
+	default:
+		return fmt.Errorf("unknown WAL operation %q", rec.Op)
+	}
+	return nil
+}
+
+// deleteUser removes the user and everything that belongs to them. Kept
+// chirps lose their author.
+func deleteUser(database *handlers.Database, userID int, keepChirps bool) {
+	delete(database.Users, fmt.Sprint(userID))
+	for key, chirp := range database.Chirps {
+		if chirp.AuthorID != userID {
+			continue
+		}
+		if keepChirps {
+			chirp.AuthorID = 0
+			database.Chirps[key] = chirp
+			continue
+		}
+		delete(database.Chirps, key)
+		delete(database.Revisions, key)
+	}
+	for key, session := range database.Sessions {
+		if session.UserID == userID {
+			delete(database.Sessions, key)
+		}
+	}
+	for key, token := range database.RefreshTokens {
+		if token.UserID == userID {
+			delete(database.RefreshTokens, key)
+		}
+	}
+	for key, token := range database.OneTimeTokens {
+		if token.UserID == userID {
+			delete(database.OneTimeTokens, key)
+		}
+	}
+	for key, identity := range database.Identities {
+		if identity.UserID == userID {
+			delete(database.Identities, key)
+		}
+	}
+	for key, token := range database.APITokens {
+		if token.UserID == userID {
+			delete(database.APITokens, key)
+		}
+	}
+}
+
+// wal is an append-only log of mutations that have not yet been folded into
+// the snapshot file
+type wal struct {
+	file *os.File
+}
+
+func openWAL(filePath string) (*wal, error) {
+	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("could not open WAL: %v", err)
+	}
+	return &wal{file: file}, nil
+}
+
+// append writes the records and fsyncs them; once it returns the mutation is durable
+func (l *wal) append(records ...walRecord) error {
+	var buf bytes.Buffer
+	for _, rec := range records {
+		line, err := json.Marshal(rec)
+		if err != nil {
+			return fmt.Errorf("could not marshal WAL record: %v", err)
+		}
+		buf.Write(line)
+		buf.WriteByte('\n')
+	}
+
+	if _, err := l.file.Write(buf.Bytes()); err != nil {
+		return fmt.Errorf("could not append to WAL: %v", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync WAL: %v", err)
+	}
+	return nil
+}
+
+// records reads back every complete record in the log. A torn final line
+// (the process died while appending it) was never acknowledged and is dropped.
+func (l *wal) records() ([]walRecord, error) {
+	if _, err := l.file.Seek(0, io.SeekStart); err != nil {
+		return nil, fmt.Errorf("could not read WAL: %v", err)
+	}
+
+	var records []walRecord
+	reader := bufio.NewReader(l.file)
+	for lineNumber := 1; ; lineNumber++ {
+		line, err := reader.ReadBytes('\n')
+		if err == io.EOF {
+			// Anything left without a newline is a torn write
+			return records, nil
+		}
+		if err != nil {
+			return nil, fmt.Errorf("could not read WAL: %v", err)
+		}
+
+		var rec walRecord
+		if err := json.Unmarshal(line, &rec); err != nil {
+			return nil, fmt.Errorf("corrupt WAL record on line %d: %v", lineNumber, err)
+		}
+		records = append(records, rec)
+	}
+}
+
+// reset empties the log once its records are safely in the snapshot
+func (l *wal) reset() error {
+	if err := l.file.Truncate(0); err != nil {
+		return fmt.Errorf("could not truncate WAL: %v", err)
+	}
+	if err := l.file.Sync(); err != nil {
+		return fmt.Errorf("could not sync WAL: %v", err)
+	}
+	return nil
+}
+
+// writeFileAtomic replaces filePath with data so that readers (and a crash at
+// any point) see either the old or the new content, never a partial file
+func writeFileAtomic(filePath string, data []byte, perm os.FileMode) error {
+	dir := filepath.Dir(filePath)
+
+	tmp, err := os.CreateTemp(dir, filepath.Base(filePath)+".tmp-*")
+	if err != nil {
+		return fmt.Errorf("could not create temp file: %v", err)
+	}
+	tmpPath := tmp.Name()
+	// Clean up the temp file on any failure before the rename
+	defer os.Remove(tmpPath)
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not write temp file: %v", err)
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return fmt.Errorf("could not sync temp file: %v", err)
+	}
+	if err := tmp.Close(); err != nil {
+		return fmt.Errorf("could not close temp file: %v", err)
+	}
+	if err := os.Chmod(tmpPath, perm); err != nil {
+		return fmt.Errorf("could not chmod temp file: %v", err)
+	}
+	if err := os.Rename(tmpPath, filePath); err != nil {
+		return fmt.Errorf("could not rename temp file: %v", err)
+	}
+
+	// Sync the directory so the rename itself survives a crash
+	dirFile, err := os.Open(dir)
+	if err != nil {
+		return fmt.Errorf("could not open directory: %v", err)
+	}
+	defer dirFile.Close()
+	if err := dirFile.Sync(); err != nil {
+		return fmt.Errorf("could not sync directory: %v", err)
+	}
+	return nil
+}
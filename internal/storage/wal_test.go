@@ -0,0 +1,268 @@
+package storage
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/RichardHoa/go-server/internal/handlers"
+)
+
+// crash drops the store without the checkpoint Close does, as if the
+// process had died
+func crash(t *testing.T, s *JSONStore) {
+	t.Helper()
+	if err := s.wal.file.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func openJSONStore(t *testing.T, filePath string) *JSONStore {
+	t.Helper()
+	s, err := NewJSONStore(filePath)
+	if err != nil {
+		t.Fatalf("could not open store: %v", err)
+	}
+	return s
+}
+
+func TestWALReplaysAfterCrash(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	s := openJSONStore(t, filePath)
+	user, err := s.CreateUser(handlers.User{Email: "user@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := s.CreateChirp(handlers.Chirp{Body: "first", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateChirp(chirp.ID, func(chirp *handlers.Chirp) error {
+		chirp.Body = "second"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, s)
+
+	s = openJSONStore(t, filePath)
+	defer s.Close()
+	if _, err := s.GetUser(user.ID); err != nil {
+		t.Errorf("user lost: %v", err)
+	}
+	got, err := s.GetChirp(chirp.ID)
+	if err != nil {
+		t.Fatalf("chirp lost: %v", err)
+	}
+	if got.Body != "second" || !got.Edited {
+		t.Errorf("got chirp %+v, want the edited body", got)
+	}
+	revisions, err := s.ListChirpRevisions(chirp.ID)
+	if err != nil || len(revisions) != 1 || revisions[0].Body != "first" {
+		t.Errorf("got revisions %+v (%v), want the first body", revisions, err)
+	}
+}
+
+func TestWALDropsTornLastLine(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	first, _ := json.Marshal(walRecord{Op: opPutUser, User: &handlers.User{ID: 1, Email: "one@example.com"}})
+	second, _ := json.Marshal(walRecord{Op: opPutUser, User: &handlers.User{ID: 2, Email: "two@example.com"}})
+
+	// The process died halfway through appending the second record
+	log := append(append(first, '\n'), second[:len(second)/2]...)
+	if err := os.WriteFile(filePath+".wal", log, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openJSONStore(t, filePath)
+	defer s.Close()
+	if _, err := s.GetUser(1); err != nil {
+		t.Errorf("complete record lost: %v", err)
+	}
+	if _, err := s.GetUser(2); err != ErrNotFound {
+		t.Errorf("torn record applied: %v", err)
+	}
+
+	// Recovery checkpoints, so the torn line cannot come back
+	info, err := os.Stat(filePath + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("WAL has %d bytes after recovery, want 0", info.Size())
+	}
+}
+
+func TestWALDropsTornOnlyLine(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	record, _ := json.Marshal(walRecord{Op: opPutUser, User: &handlers.User{ID: 1, Email: "one@example.com"}})
+
+	// The process died halfway through appending the only record
+	if err := os.WriteFile(filePath+".wal", record[:len(record)/2], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s := openJSONStore(t, filePath)
+	user, err := s.CreateUser(handlers.User{Email: "user@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	crash(t, s)
+
+	// The new record must not have been appended onto the torn line
+	s = openJSONStore(t, filePath)
+	defer s.Close()
+	if _, err := s.GetUser(user.ID); err != nil {
+		t.Errorf("user lost: %v", err)
+	}
+}
+
+func TestWALRejectsCorruptRecord(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	record, _ := json.Marshal(walRecord{Op: opPutUser, User: &handlers.User{ID: 1, Email: "one@example.com"}})
+
+	// A complete line that does not parse was not torn by a crash
+	log := append([]byte("{not json\n"), append(record, '\n')...)
+	if err := os.WriteFile(filePath+".wal", log, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if s, err := NewJSONStore(filePath); err == nil {
+		s.Close()
+		t.Error("opened a store with a corrupt WAL")
+	}
+}
+
+func TestWALReplayIsIdempotent(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	s := openJSONStore(t, filePath)
+	user, err := s.CreateUser(handlers.User{Email: "user@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	chirp, err := s.CreateChirp(handlers.Chirp{Body: "first", AuthorID: user.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.UpdateChirp(chirp.ID, func(chirp *handlers.Chirp) error {
+		chirp.Body = "second"
+		return nil
+	}); err != nil {
+		t.Fatal(err)
+	}
+	log, err := os.ReadFile(filePath + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The process died after writing the snapshot but before truncating the
+	// log, so every record is replayed on top of a snapshot that has it
+	if err := s.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filePath+".wal", log, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	s = openJSONStore(t, filePath)
+	defer s.Close()
+	users, chirps := len(s.database.Users), len(s.database.Chirps)
+	if users != 1 || chirps != 1 {
+		t.Errorf("got %d users and %d chirps after replay, want 1 and 1", users, chirps)
+	}
+	revisions, err := s.ListChirpRevisions(chirp.ID)
+	if err != nil || len(revisions) != 1 {
+		t.Errorf("got revisions %+v (%v), want one", revisions, err)
+	}
+	if next, err := s.CreateChirp(handlers.Chirp{Body: "next", AuthorID: user.ID}); err != nil || next.ID != chirp.ID+1 {
+		t.Errorf("next chirp got ID %d (%v), want %d", next.ID, err, chirp.ID+1)
+	}
+}
+
+func TestWALRestoresSequencesAfterDelete(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	s := openJSONStore(t, filePath)
+	kept, err := s.CreateUser(handlers.User{Email: "kept@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	deleted, err := s.CreateUser(handlers.User{Email: "deleted@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateChirp(handlers.Chirp{Body: "kept", AuthorID: kept.ID}); err != nil {
+		t.Fatal(err)
+	}
+	deletedChirp, err := s.CreateChirp(handlers.Chirp{Body: "deleted", AuthorID: kept.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.DeleteChirp(deletedChirp.ID); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.DeleteUser(deleted.ID, false); err != nil {
+		t.Fatal(err)
+	}
+	crash(t, s)
+
+	// Only the log knows the highest IDs, as their rows are gone
+	s = openJSONStore(t, filePath)
+	defer s.Close()
+	user, err := s.CreateUser(handlers.User{Email: "new@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if user.ID != deleted.ID+1 {
+		t.Errorf("new user got ID %d, want %d", user.ID, deleted.ID+1)
+	}
+	chirp, err := s.CreateChirp(handlers.Chirp{Body: "new", AuthorID: kept.ID})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if chirp.ID != deletedChirp.ID+1 {
+		t.Errorf("new chirp got ID %d, want %d", chirp.ID, deletedChirp.ID+1)
+	}
+}
+
+func TestWALCheckpoints(t *testing.T) {
+	filePath := filepath.Join(t.TempDir(), "database.json")
+	s := openJSONStore(t, filePath)
+	defer s.Close()
+	user, err := s.CreateUser(handlers.User{Email: "user@example.com", Password: "hash"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for n := 1; n < checkpointEvery; n++ {
+		if _, err := s.CreateChirp(handlers.Chirp{Body: "chirp", AuthorID: user.ID}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// The 100th record folded the log into the snapshot
+	info, err := os.Stat(filePath + ".wal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Size() != 0 {
+		t.Errorf("WAL has %d bytes after %d records, want 0", info.Size(), checkpointEvery)
+	}
+	fileBytes, err := os.ReadFile(filePath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var snapshot handlers.Database
+	if err := json.Unmarshal(fileBytes, &snapshot); err != nil {
+		t.Fatal(err)
+	}
+	if len(snapshot.Chirps) != checkpointEvery-1 || len(snapshot.Users) != 1 {
+		t.Errorf("snapshot has %d chirps and %d users, want %d and 1", len(snapshot.Chirps), len(snapshot.Users), checkpointEvery-1)
+	}
+
+	// The next record starts a new log
+	if _, err := s.CreateChirp(handlers.Chirp{Body: "chirp", AuthorID: user.ID}); err != nil {
+		t.Fatal(err)
+	}
+	if info, err := os.Stat(filePath + ".wal"); err != nil || info.Size() == 0 {
+		t.Errorf("WAL is empty after a record past the checkpoint (%v)", err)
+	}
+}
@@ -0,0 +1,81 @@
+package totp
+
+import (
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA-1 key of RFC 6238 appendix B, "12345678901234567890"
+const rfcSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestCodeRFC6238(t *testing.T) {
+	// The RFC lists 8-digit codes; ours are their last 6 digits
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+		{20000000000, "353130"},
+	}
+
+	for _, tt := range tests {
+		got, err := Code(rfcSecret, time.Unix(tt.unix, 0))
+		if err != nil {
+			t.Fatalf("Code(%d): %v", tt.unix, err)
+		}
+		if got != tt.want {
+			t.Errorf("Code(%d) = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1111111111, 0)
+	step := Step(now)
+	code := func(t *testing.T, at time.Time) string {
+		t.Helper()
+		code, err := Code(rfcSecret, at)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return code
+	}
+
+	tests := []struct {
+		name      string
+		code      string
+		afterStep int64
+		wantStep  int64
+		wantOK    bool
+	}{
+		{"current step", code(t, now), 0, step, true},
+		{"previous step", code(t, now.Add(-Period)), 0, step - 1, true},
+		{"next step", code(t, now.Add(Period)), 0, step + 1, true},
+		{"two steps late", code(t, now.Add(-2*Period)), 0, 0, false},
+		{"already used", code(t, now), step, 0, false},
+		{"older than the last used", code(t, now.Add(-Period)), step, 0, false},
+		{"newer than the last used", code(t, now.Add(Period)), step, step + 1, true},
+		{"too short", code(t, now)[:Digits-1], 0, 0, false},
+		{"not a code", "abcdef", 0, 0, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gotStep, ok := Validate(rfcSecret, tt.code, now, tt.afterStep)
+			if ok != tt.wantOK || gotStep != tt.wantStep {
+				t.Errorf("Validate(%s) = %d, %v, want %d, %v", tt.code, gotStep, ok, tt.wantStep, tt.wantOK)
+			}
+		})
+	}
+}
+
+func TestValidateLowercaseSecret(t *testing.T) {
+	now := time.Unix(59, 0)
+	if _, ok := Validate("gezdgnbvgy3tqojqgezdgnbvgy3tqojq", "287082", now, 0); !ok {
+		t.Error("rejected a code for a lowercase secret")
+	}
+}
@@ -0,0 +1,171 @@
+package wordfilter
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// Mask is what a filtered word is replaced with
+const Mask = "****"
+
+// DefaultWords is used when no word list file is configured
+var DefaultWords = []string{"kerfuffle", "sharbert", "fornax"}
+
+// Filter finds banned words in text. Words are compared case-insensitively in
+// every script, and punctuation next to a word does not hide it. A nil
+// *Filter matches nothing.
+type Filter struct {
+	words map[string]bool // Case-folded banned words
+}
+
+// New returns a filter for the given words
+func New(words []string) *Filter {
+	f := &Filter{words: make(map[string]bool, len(words))}
+	for _, word := range words {
+		f.words[fold(word)] = true
+	}
+	return f
+}
+
+// Load reads a word list with one word per line. Blank lines and lines
+// starting with # are ignored.
+func Load(path string) (*Filter, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open word list: %v", err)
+	}
+	defer file.Close()
+
+	var words []string
+	scanner := bufio.NewScanner(file)
+	for line := 1; scanner.Scan(); line++ {
+		word := strings.TrimSpace(scanner.Text())
+		if word == "" || strings.HasPrefix(word, "#") {
+			continue
+		}
+		if !utf8.ValidString(word) || strings.IndexFunc(word, isSeparator) >= 0 {
+			return nil, fmt.Errorf("line %d of %s: %q is not a single word", line, path, word)
+		}
+		words = append(words, word)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read word list: %v", err)
+	}
+	return New(words), nil
+}
+
+// Find returns the banned words in text, as they were written, in order
+func (f *Filter) Find(text string) []string {
+	var found []string
+	f.scan(text, func(start, end int) {
+		found = append(found, text[start:end])
+	})
+	return found
+}
+
+// Replace returns text with every banned word replaced by Mask
+func (f *Filter) Replace(text string) string {
+	var out strings.Builder
+	last := 0
+	f.scan(text, func(start, end int) {
+		out.WriteString(text[last:start])
+		out.WriteString(Mask)
+		last = end
+	})
+	if last == 0 {
+		return text
+	}
+	out.WriteString(text[last:])
+	return out.String()
+}
+
+// scan calls match with the byte range of every banned word in text.
+// Letters spelled out with punctuation in between ("f.o.r.n.a.x") are read as
+// one word.
+func (f *Filter) scan(text string, match func(start, end int)) {
+	if f == nil || len(f.words) == 0 {
+		return
+	}
+
+	words := split(text)
+	for i := 0; i < len(words); i++ {
+		if end := spelledOut(text, words, i); end > i+1 {
+			var joined strings.Builder
+			for _, w := range words[i:end] {
+				joined.WriteString(text[w.start:w.end])
+			}
+			if f.words[fold(joined.String())] {
+				match(words[i].start, words[end-1].end)
+				i = end - 1
+				continue
+			}
+		}
+
+		if f.words[fold(text[words[i].start:words[i].end])] {
+			match(words[i].start, words[i].end)
+		}
+	}
+}
+
+// span is the byte range of a word in text
+type span struct {
+	start, end int
+}
+
+// split returns the byte ranges of the words in text
+func split(text string) []span {
+	var words []span
+	start := -1
+	for i, r := range text {
+		if !isSeparator(r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			words = append(words, span{start, i})
+		}
+		start = -1
+	}
+	if start >= 0 {
+		words = append(words, span{start, len(text)})
+	}
+	return words
+}
+
+// spelledOut returns the end of the run of one-letter words starting at
+// words[i] that are separated by punctuation only. Whitespace ends the run,
+// so separate words are never joined.
+func spelledOut(text string, words []span, i int) int {
+	end := i
+	for end < len(words) && utf8.RuneCountInString(text[words[end].start:words[end].end]) == 1 {
+		if end > i && strings.IndexFunc(text[words[end-1].end:words[end].start], unicode.IsSpace) >= 0 {
+			break
+		}
+		end++
+	}
+	return end
+}
+
+// isSeparator reports whether r splits words. Combining marks belong to the
+// word so accented letters written as two runes stay together.
+func isSeparator(r rune) bool {
+	return !unicode.IsLetter(r) && !unicode.IsNumber(r) && !unicode.IsMark(r)
+}
+
+// fold maps every rune to the smallest rune it is case-equivalent to, so
+// "KERFUFFLE", "Kerfuffle" and "kerfuffle" fold to the same string
+func fold(word string) string {
+	return strings.Map(func(r rune) rune {
+		smallest := r
+		for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+			smallest = min(smallest, f)
+		}
+		return smallest
+	}, word)
+}
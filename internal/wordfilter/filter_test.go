@@ -0,0 +1,82 @@
+package wordfilter
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func TestReplace(t *testing.T) {
+	filter := New([]string{"fuck", "kerfuffle", "straße"})
+
+	tests := []struct {
+		text string
+		want string
+	}{
+		{"what the fuck", "what the ****"},
+		{"FUCK this", "**** this"},
+		{"Fuck!", "****!"},
+		{"f.u.c.k", "****"},
+		{"oh f.u.c.k.", "oh ****."},
+		{"F-U-C-K you", "**** you"},
+		{"f*u*c*k", "****"},
+		{"(kerfuffle)", "(****)"},
+		{"KerFuffle, kerfuffle", "****, ****"},
+		{"STRASSE or STRAßE", "STRASSE or ****"},
+		// Banned words inside other words are left alone
+		{"fuckity kerfuffles", "fuckity kerfuffles"},
+		// Separate words are never joined
+		{"f u c k", "f u c k"},
+		{"f.u.c.k.s", "f.u.c.k.s"},
+		{"a.b.c", "a.b.c"},
+		{"nothing to see", "nothing to see"},
+		{"", ""},
+	}
+
+	for _, tt := range tests {
+		if got := filter.Replace(tt.text); got != tt.want {
+			t.Errorf("Replace(%q) = %q, want %q", tt.text, got, tt.want)
+		}
+	}
+}
+
+func TestFind(t *testing.T) {
+	filter := New([]string{"fuck", "kerfuffle"})
+	got := filter.Find("Kerfuffle? f.u.c.k that, FUCK")
+	want := []string{"Kerfuffle", "f.u.c.k", "FUCK"}
+	if !slices.Equal(got, want) {
+		t.Errorf("Find = %q, want %q", got, want)
+	}
+}
+
+func TestNilFilter(t *testing.T) {
+	var filter *Filter
+	if got := filter.Replace("kerfuffle"); got != "kerfuffle" {
+		t.Errorf("nil filter replaced: %q", got)
+	}
+	if got := filter.Find("kerfuffle"); got != nil {
+		t.Errorf("nil filter found %q", got)
+	}
+}
+
+func TestLoad(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "words.txt")
+	if err := os.WriteFile(path, []byte("# banned\n\nkerfuffle\n  Fornax  \n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	filter, err := Load(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := filter.Replace("kerfuffle fornax banned"); got != "**** **** banned" {
+		t.Errorf("Replace = %q", got)
+	}
+
+	if err := os.WriteFile(path, []byte("two words\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := Load(path); err == nil {
+		t.Error("loaded a line with two words")
+	}
+}
@@ -1,8 +1,12 @@
 package main
 
 import (
+	"errors"
 	"github.com/RichardHoa/go-server/internal/config"
 	"github.com/RichardHoa/go-server/internal/route"
+	"github.com/RichardHoa/go-server/internal/search"
+	"github.com/RichardHoa/go-server/internal/signing"
+	"github.com/RichardHoa/go-server/internal/storage"
 	"github.com/joho/godotenv"
 	"log"
 	"net/http"
@@ -16,12 +20,50 @@ func main() {
 		log.Fatal("Error loading .env file")
 	}
 
-	const port = "8080"
+	// Pick the storage backend, defaulting to the JSON file
+	dbDriver := os.Getenv("DB_DRIVER")
+	dbPath := os.Getenv("DB_PATH")
+	if dbPath == "" {
+		dbPath = "database.json"
+		if dbDriver == "sqlite" {
+			dbPath = "chirpy.db"
+		}
+	}
+
+	// `go-server import-json [database.json]` copies the JSON file into SQLite and exits
+	if len(os.Args) > 1 && os.Args[1] == "import-json" {
+		importJSON(dbPath)
+		return
+	}
+
+	// `go-server generate-key [EdDSA|RS256]` adds a signing key to JWT_KEYS_DIR and exits
+	if len(os.Args) > 1 && os.Args[1] == "generate-key" {
+		generateKey()
+		return
+	}
+
+	store, err := storage.Open(dbDriver, dbPath)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+
+	// `go-server make-admin <email>` gives an existing user the admin role and exits
+	if len(os.Args) > 1 && os.Args[1] == "make-admin" {
+		makeAdmin(store)
+		return
+	}
 
-	// Initialize apiConfig
-	apiCfg := &config.ApiConfig{
-		JWTSecret:   os.Getenv("JWT_SECRET"),
-		PolkaAPIKey: os.Getenv("POLKA_API_KEY"),
+	// Read the rest of the settings from the environment
+	apiCfg, err := config.FromEnv(os.Getenv)
+	if err != nil {
+		log.Fatalf("Error loading configuration: %v", err)
+	}
+	apiCfg.Store = store
+	apiCfg.Search = search.NewIndex()
+
+	// Index the existing chirps for keyword search
+	if err := apiCfg.BuildSearchIndex(); err != nil {
+		log.Fatalf("Error building search index: %v", err)
 	}
 
 	// Create a new ServeMux
@@ -32,11 +74,67 @@ func main() {
 
 	// Create and start the server
 	server := &http.Server{
-		Addr:    ":" + port,
+		Addr:    ":" + config.Port,
 		Handler: mux,
 	}
 
-	log.Printf("Serving files from %s on port: %s\n", ".", port)
+	log.Printf("Serving files from %s on port: %s\n", ".", config.Port)
 	log.Println("This server is intended for docker")
 	log.Fatal(server.ListenAndServe())
 }
+
+// importJSON imports a database.json file into the SQLite database at dbPath
+func importJSON(dbPath string) {
+	jsonPath := "database.json"
+	if len(os.Args) > 2 {
+		jsonPath = os.Args[2]
+	}
+	if os.Getenv("DB_DRIVER") != "sqlite" {
+		log.Fatal("import-json requires DB_DRIVER=sqlite")
+	}
+
+	store, err := storage.NewSQLiteStore(dbPath)
+	if err != nil {
+		log.Fatalf("Error opening database: %v", err)
+	}
+	defer store.Close()
+
+	users, chirps, err := storage.ImportJSON(jsonPath, store)
+	if err != nil {
+		log.Fatalf("Error importing %s: %v", jsonPath, err)
+	}
+	log.Printf("Imported %d users and %d chirps from %s into %s\n", users, chirps, jsonPath, dbPath)
+}
+
+// makeAdmin gives the user named on the command line the admin role
+func makeAdmin(store storage.Store) {
+	if len(os.Args) < 3 {
+		log.Fatal("usage: go-server make-admin <email>")
+	}
+	user, err := config.MakeAdmin(store, os.Args[2])
+	if errors.Is(err, storage.ErrNotFound) {
+		log.Fatalf("No user with email %s, sign up first", os.Args[2])
+	}
+	if err != nil {
+		log.Fatalf("Error updating user: %v", err)
+	}
+	log.Printf("User %d (%s) is now an admin\n", user.ID, user.Email)
+}
+
+// generateKey writes a new signing key to JWT_KEYS_DIR
+func generateKey() {
+	alg := "EdDSA"
+	if len(os.Args) > 2 {
+		alg = os.Args[2]
+	}
+	keysDir := os.Getenv("JWT_KEYS_DIR")
+	if keysDir == "" {
+		log.Fatal("generate-key requires JWT_KEYS_DIR")
+	}
+
+	kid, err := signing.GenerateKey(keysDir, alg)
+	if err != nil {
+		log.Fatalf("Error generating key: %v", err)
+	}
+	log.Printf("Generated %s key %s in %s\n", alg, kid, keysDir)
+}